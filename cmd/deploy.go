@@ -6,7 +6,6 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
-	"strings"
 
 	"github.com/valer-cara/mgo/pkg/deploy"
 	"github.com/valer-cara/mgo/pkg/services"
@@ -17,6 +16,8 @@ var (
 	deploySource  string
 	deployImage   string
 	deployAuthor  string
+
+	deployResolveDigest bool
 )
 
 var deployCmd = &cobra.Command{
@@ -39,18 +40,25 @@ func init() {
 	deployCmd.MarkFlagRequired("cluster")
 	deployCmd.Flags().StringVar(&deploySource, "source", "", "Repo associated with this deploy, as recored in the '__mygitops' section. Eg: github.com/foo/bar")
 	deployCmd.MarkFlagRequired("source")
-	deployCmd.Flags().StringVar(&deployImage, "image", "", "Docker image for the new deployment. Eg: quay.io/foo/bar")
+	deployCmd.Flags().StringVar(&deployImage, "image", "", "Docker image for the new deployment. Eg: quay.io/foo/bar:1.0, registry:5000/foo/bar@sha256:...")
 	deployCmd.MarkFlagRequired("image")
 	deployCmd.Flags().StringVar(&deployAuthor, "author", "", "Author recorded for this deployment. Eg: linus@kernel.org")
 	deployCmd.MarkFlagRequired("author")
+	deployCmd.Flags().BoolVar(&deployResolveDigest, "resolve-digest", false, "Pin the image to the digest its tag points to in the registry")
 }
 
 func doDeploy() error {
+	image, err := parseDeployImage(deployImage)
+	if err != nil {
+		return err
+	}
+
 	deploySvc := services.NewDeployService(gitopsRepo, &deploy.DeployOptions{
-		TriggerRepo: deploySource,
-		Image:       splitImage(deployImage),
-		Author:      deployAuthor,
-		Cluster:     deployCluster,
+		TriggerRepo:   deploySource,
+		Image:         image,
+		Author:        deployAuthor,
+		Cluster:       deployCluster,
+		ResolveDigest: deployResolveDigest,
 	})
 
 	if err := deploySvc.Execute(); err != nil {
@@ -60,16 +68,19 @@ func doDeploy() error {
 	return nil
 }
 
-func splitImage(image string) deploy.DeployOptionsImage {
-	tag := "latest"
-	separatedImage := strings.Split(image, ":")
-
-	if len(separatedImage) > 1 {
-		tag = separatedImage[1]
+// A reference without tag means `latest`, as for `docker pull`. Digests go
+// along with a tag, which is what values files are written with
+func parseDeployImage(image string) (deploy.DeployOptionsImage, error) {
+	parsed, err := deploy.ParseReference(image)
+	if err != nil {
+		return parsed, err
 	}
 
-	return deploy.DeployOptionsImage{
-		Repository: separatedImage[0],
-		Tag:        tag,
+	if parsed.Tag == "" {
+		if parsed.Digest != "" {
+			return parsed, errors.New(fmt.Sprintf("%s: the digest needs a tag, eg: %s:1.0@%s", image, parsed.Repository, parsed.Digest))
+		}
+		parsed.Tag = "latest"
 	}
+	return parsed, nil
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"testing"
 
+	"github.com/valer-cara/mgo/pkg/deploy"
 	"github.com/valer-cara/mgo/pkg/testutils"
 )
 
@@ -16,6 +17,7 @@ func TestDeploy(t *testing.T) {
 		"--cluster=myprodcluster",
 		"--author=Freddie",
 		"--source=github.com/a/repo1",
+		"--image=registry:5000/a/repo1:1.0.0",
 	})
 	if err != nil {
 		t.Fatal("Error parsing arguments:", err)
@@ -44,6 +46,36 @@ func TestDeployBadRepo(t *testing.T) {
 	}
 }
 
+func TestParseDeployImage(t *testing.T) {
+	digest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	tests := []struct {
+		Image    string
+		Expected deploy.DeployOptionsImage
+	}{
+		{"foo/bar", deploy.DeployOptionsImage{Repository: "foo/bar", Tag: "latest"}},
+		{"registry:5000/foo/bar", deploy.DeployOptionsImage{Repository: "registry:5000/foo/bar", Tag: "latest"}},
+		{"registry:5000/foo/bar:1.0", deploy.DeployOptionsImage{Repository: "registry:5000/foo/bar", Tag: "1.0"}},
+		{"foo/bar:1.0@" + digest, deploy.DeployOptionsImage{Repository: "foo/bar", Tag: "1.0", Digest: digest}},
+	}
+
+	for _, test := range tests {
+		image, err := parseDeployImage(test.Image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image != test.Expected {
+			t.Fatalf("%s: expected %+v, got %+v", test.Image, test.Expected, image)
+		}
+	}
+
+	for _, image := range []string{"", "Foo/bar:1.0", "foo/bar:1.0@sha256:nope", "foo/bar@" + digest} {
+		if _, err := parseDeployImage(image); err == nil {
+			t.Fatalf("Expected an error for `%s`", image)
+		}
+	}
+}
+
 func TestUpdatesCommited(t *testing.T) {
 	t.Skip("To be implemented")
 }
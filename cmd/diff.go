@@ -4,12 +4,13 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
-	"strings"
 
 	"github.com/valer-cara/mgo/pkg/config"
 	"github.com/valer-cara/mgo/pkg/helm"
 	"github.com/valer-cara/mgo/pkg/jobs"
+	"github.com/valer-cara/mgo/pkg/kubectl"
 	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
 )
 
 var (
@@ -40,7 +41,21 @@ var diffCmd = &cobra.Command{
 
 		diffHelmService.SetOutput(os.Stdout)
 
-		manifests, err := manifest.FindManifests(gitopsRepo, diffCluster)
+		diffKubectlService, err := diffInitKubectlService()
+		if err != nil {
+			log.Println("Error initializing: ", err)
+			os.Exit(1)
+		}
+
+		diffKubectlService.SetOutput(os.Stdout)
+
+		layout, err := config.Global.RepoLayout()
+		if err != nil {
+			log.Errorf("Invalid repo layout: %v", err)
+			os.Exit(1)
+		}
+
+		manifests, err := manifest.FindManifests(layout, gitopsRepo, diffCluster)
 		if err != nil {
 			log.Errorf("Cannot find manifests for cluster %s in repo %s", diffCluster, gitopsRepo)
 			os.Exit(1)
@@ -59,11 +74,10 @@ var diffCmd = &cobra.Command{
 				os.Exit(1)
 			}
 
-			files := []string{file}
-
-			potentialSecretsFilePath := strings.Replace(file, "-values.yaml", "-secrets.yaml", -1)
-			if _, err := os.Stat(potentialSecretsFilePath); err == nil {
-				files = append(files, potentialSecretsFilePath)
+			files, err := header.ResolveValueFiles(gitopsRepo, file)
+			if err != nil {
+				log.Errorf("File %s: %v", file, err)
+				os.Exit(1)
 			}
 
 			diffJobs = append(diffJobs, diffJob{
@@ -72,11 +86,15 @@ var diffCmd = &cobra.Command{
 			})
 		}
 
+		decrypter := secrets.NewSopsCmd(&config.Global.Secrets)
+
 		jobs.Parallel(func(job interface{}) error {
 			j := job.(diffJob)
 
 			// Helm service outputs to stdout as set above
-			err = diffHelmService.DiffRelease(j.Header, j.Files)
+			err := secrets.WithDecrypted(decrypter, j.Files, func(files []string) error {
+				return diffHelmService.DiffRelease(j.Header, files)
+			})
 			if err != nil {
 				log.Errorf("Cannot diff release %s: %v", j.Header.Name, err)
 				os.Exit(1)
@@ -84,6 +102,15 @@ var diffCmd = &cobra.Command{
 
 			return nil
 		}, diffJobs, &jobs.ParallelOpts{MaxParallel: 15})
+
+		for _, dir := range manifests.Kustomize {
+			// Kubectl service outputs to stdout as set above
+			err := kubectl.WithKustomization(diffKubectlService, dir, diffKubectlService.Diff)
+			if err != nil {
+				log.Errorf("Cannot diff kustomization %s: %v", dir, err)
+				os.Exit(1)
+			}
+		}
 	},
 }
 
@@ -93,16 +120,32 @@ func init() {
 	diffCmd.MarkFlagRequired("cluster")
 }
 
-func diffInitHelmService() (*helm.HelmCmd, error) {
-	helmService := helm.NewHelmCmd(&helm.HelmCmdOptions{
+func diffInitHelmService() (helm.HelmService, error) {
+	helmService, err := helm.NewHelmService(&helm.HelmServiceOptions{
+		Backend:      config.Global.Helm.Backend,
 		HelmHome:     getHelmHome(),
 		Kubeconfig:   getKubeconfig(),
 		Kubecontext:  diffCluster,
 		Repositories: config.Global.Helm.Repositories,
 	})
+	if err != nil {
+		return nil, err
+	}
 	if err := helmService.Init(); err != nil {
 		return nil, err
 	}
 
 	return helmService, nil
 }
+
+func diffInitKubectlService() (*kubectl.KubectlCmd, error) {
+	kubectlService := kubectl.NewKubectlCmd(&kubectl.KubectlCmdOptions{
+		Kubeconfig:  getKubeconfig(),
+		Kubecontext: diffCluster,
+	})
+	if err := kubectlService.Init(); err != nil {
+		return nil, err
+	}
+
+	return kubectlService, nil
+}
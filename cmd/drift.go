@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+
+	"github.com/valer-cara/mgo/pkg/config"
+	"github.com/valer-cara/mgo/pkg/drift"
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/secrets"
+)
+
+var (
+	driftCluster string
+	driftOutput  string
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Compare the live cluster objects against the manifests rendered from the gitops repo",
+	Long: `Renders every helm release (via 'helm template') and raw manifest of a cluster
+and compares the resulting objects with the live ones. Exits non-zero when drift
+is detected.`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := doDrift()
+		if err != nil {
+			log.Fatal(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(driftCmd)
+	driftCmd.Flags().StringVar(&driftCluster, "cluster", "", "Cluster to check, as given by 'kubectl config get-contexts'. Eg: minikube")
+	driftCmd.MarkFlagRequired("cluster")
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", "text", "Report format: text or json")
+}
+
+func doDrift() error {
+	if driftOutput != "text" && driftOutput != "json" {
+		return errors.New(fmt.Sprintf("Unknown output format '%s'. Use 'text' or 'json'", driftOutput))
+	}
+
+	helmService, err := helm.NewHelmService(&helm.HelmServiceOptions{
+		Backend:      config.Global.Helm.Backend,
+		HelmHome:     getHelmHome(),
+		Kubeconfig:   getKubeconfig(),
+		Kubecontext:  driftCluster,
+		Repositories: config.Global.Helm.Repositories,
+	})
+	if err != nil {
+		return err
+	}
+	if err := helmService.Init(); err != nil {
+		return errors.New(fmt.Sprintf("Cannot initialize helm service: %v", err))
+	}
+
+	kubectlService := kubectl.NewKubectlCmd(&kubectl.KubectlCmdOptions{
+		Kubeconfig:  getKubeconfig(),
+		Kubecontext: driftCluster,
+	})
+	if err := kubectlService.Init(); err != nil {
+		return errors.New(fmt.Sprintf("Cannot initialize kubectl service: %v", err))
+	}
+
+	layout, err := config.Global.RepoLayout()
+	if err != nil {
+		return err
+	}
+
+	report, err := drift.NewDrift(gitopsRepo, driftCluster, layout, helmService, kubectlService, secrets.NewSopsCmd(&config.Global.Secrets)).Detect()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot detect drift for cluster %s: %v", driftCluster, err))
+	}
+
+	if driftOutput == "json" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		report.Write(os.Stdout)
+	}
+
+	if report.HasErrors() {
+		return errors.New("Drift detection failed for some releases")
+	}
+	if report.HasDrift() {
+		return errors.New(fmt.Sprintf("Cluster %s has drifted from the gitops repo", driftCluster))
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+
+	"github.com/valer-cara/mgo/pkg/config"
+	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/history"
+)
+
+var (
+	historyCluster     string
+	historyTriggerRepo string
+	historyLimit       int
+	historyOutput      string
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the deploys recorded in the gitops repo, newest first",
+	Long: `Reads the deploy commits of the checked out branch of the gitops repo back
+into deploy records.`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := doHistory(os.Stdout)
+		if err != nil {
+			log.Fatal(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().StringVar(&historyCluster, "cluster", "", "Only deploys to this cluster")
+	historyCmd.Flags().StringVar(&historyTriggerRepo, "source", "", "Only deploys of this repo, as recored in the '__mygitops' section. Eg: github.com/foo/bar")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "Max number of deploys listed. 0 lists all")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", "text", "Output format: text or json")
+}
+
+func doHistory(out io.Writer) error {
+	if historyOutput != "text" && historyOutput != "json" {
+		return errors.New(fmt.Sprintf("Unknown output format '%s'. Use 'text' or 'json'", historyOutput))
+	}
+
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, gitopsRepo, &config.Global.Git)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot initialize git service on %s", gitopsRepo))
+	}
+
+	records, err := history.List(gitService, "HEAD", &history.Filter{
+		Cluster:     historyCluster,
+		TriggerRepo: historyTriggerRepo,
+		Limit:       historyLimit,
+	})
+	if err != nil {
+		return err
+	}
+
+	if historyOutput == "json" {
+		encoded, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(encoded))
+	} else {
+		history.Write(out, records)
+	}
+
+	return nil
+}
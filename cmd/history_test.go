@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/testutils"
+)
+
+func TestHistory(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../tests/minimal-gitops-repo")
+
+	for _, deploy := range []struct{ Source, Image string }{
+		{"github.com/a/repo1", "a/repo1:2.0.0"},
+		{"github.com/foo/bar", "foo/bar:3.0.0"},
+		{"github.com/a/repo1", "a/repo1:2.1.0"},
+	} {
+		err := testutils.PrepareArgs(t, deployCmd, []string{
+			"--gitops-repo=" + repo,
+			"--cluster=myprodcluster",
+			"--author=Freddie",
+			"--source=" + deploy.Source,
+			"--image=" + deploy.Image,
+		})
+		if err != nil {
+			t.Fatal("Error parsing arguments:", err)
+		}
+		if err := doDeploy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listHistory := func(args ...string) string {
+		err := testutils.PrepareArgs(t, historyCmd, append([]string{"--gitops-repo=" + repo}, args...))
+		if err != nil {
+			t.Fatal("Error parsing arguments:", err)
+		}
+
+		var out bytes.Buffer
+		if err := doHistory(&out); err != nil {
+			t.Fatal("Expected history to be listed:", err)
+		}
+		return out.String()
+	}
+
+	tests := []struct {
+		Args     []string
+		Expected []string
+	}{
+		{[]string{"--cluster=", "--source=", "--limit=0"}, []string{"a/repo1:2.1.0", "foo/bar:3.0.0", "a/repo1:2.0.0"}},
+		{[]string{"--cluster=myprodcluster", "--source=github.com/a/repo1"}, []string{"a/repo1:2.1.0", "a/repo1:2.0.0"}},
+		{[]string{"--cluster=", "--source=", "--limit=1"}, []string{"a/repo1:2.1.0"}},
+		{[]string{"--cluster=nope", "--source=", "--limit=0"}, []string{}},
+	}
+
+	for testIdx, test := range tests {
+		var records []*history.Record
+		if err := json.Unmarshal([]byte(listHistory(append(test.Args, "-o", "json")...)), &records); err != nil {
+			t.Fatalf("[test %d] Cannot parse json output: %v", testIdx, err)
+		}
+
+		images := []string{}
+		for _, record := range records {
+			images = append(images, record.Deploy.Image.String())
+			if len(record.Commit) != 40 || record.Deploy.Cluster != "myprodcluster" || record.Deploy.Author != "Freddie" {
+				t.Fatalf("[test %d] Unexpected record %+v", testIdx, record.Deploy)
+			}
+		}
+		if strings.Join(images, " ") != strings.Join(test.Expected, " ") {
+			t.Fatalf("[test %d] Expected %v, got %v", testIdx, test.Expected, images)
+		}
+	}
+
+	// One line per record, with its short commit
+	var records []*history.Record
+	if err := json.Unmarshal([]byte(listHistory("--cluster=", "--limit=1", "-o", "json")), &records); err != nil || len(records) != 1 {
+		t.Fatalf("Expected a record, got %v (%v)", records, err)
+	}
+	text := listHistory("--cluster=", "--limit=1", "-o", "text")
+	if !strings.HasPrefix(text, records[0].Commit[:8]+"  ") || !strings.Contains(text, "myprodcluster") || !strings.Contains(text, "a/repo1:2.1.0 by Freddie\n") {
+		t.Fatalf("Unexpected text output: %q", text)
+	}
+
+	err := testutils.PrepareArgs(t, historyCmd, []string{"--gitops-repo=" + repo, "-o", "yaml"})
+	if err != nil {
+		t.Fatal("Error parsing arguments:", err)
+	}
+	if err := doHistory(&bytes.Buffer{}); err == nil {
+		t.Fatal("Expected an error for an unknown output format")
+	}
+}
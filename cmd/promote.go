@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+var (
+	promoteFrom   string
+	promoteTo     string
+	promoteSource string
+	promoteAuthor string
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Deploy the image a repo has on a cluster to the next one, push and sync",
+	Long: `Copies the image of the given repo in the values files of the --from cluster
+to those of the --to cluster, commits it as a promotion, pushes and syncs the
+--to cluster. The image must have synced successfully on the --from cluster,
+and the pair must be one of the 'promotions' in mygitops.yaml.`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := doPromote()
+		if err != nil {
+			log.Fatal(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(promoteCmd)
+	promoteCmd.Flags().StringVar(&promoteFrom, "from", "", "Cluster the image is taken from. Eg: staging")
+	promoteCmd.MarkFlagRequired("from")
+	promoteCmd.Flags().StringVar(&promoteTo, "to", "", "Cluster to deploy the image to, as given by 'kubectl config get-contexts'. Eg: prod")
+	promoteCmd.MarkFlagRequired("to")
+	promoteCmd.Flags().StringVar(&promoteSource, "source", "", "Repo to promote, as recored in the '__mygitops' section. Eg: github.com/foo/bar")
+	promoteCmd.MarkFlagRequired("source")
+	promoteCmd.Flags().StringVar(&promoteAuthor, "author", os.Getenv("USER"), "Author recorded for this promotion. Eg: linus@kernel.org")
+	promoteCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Don't do any actual changes to the cluster")
+}
+
+func doPromote() error {
+	if promoteAuthor == "" {
+		return errors.New("No author for the promotion, use --author")
+	}
+
+	relMgr := services.NewReleaseManagerBatched(&services.ReleaseManagerBatchedOptions{
+		GitopsRepo: gitopsRepo,
+		KubeConfig: getKubeconfig(),
+		HelmHome:   getHelmHome(),
+		DryRun:     dryRun,
+	})
+	if err := relMgr.Init(); err != nil {
+		return errors.New(fmt.Sprintf("Failed init release manager: %v", err))
+	}
+
+	dopts := &deploy.DeployOptions{
+		TriggerRepo: promoteSource,
+		Author:      promoteAuthor,
+		Cluster:     promoteTo,
+		PromoteFrom: promoteFrom,
+	}
+
+	if err := relMgr.RequestRelease(dopts); err != nil {
+		return errors.New(fmt.Sprintf("Failed promotion %v: %v", dopts, err))
+	}
+
+	log.Printf("Promoted %s from %s to %s", dopts.Image, promoteFrom, promoteTo)
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+var (
+	rollbackCluster string
+	rollbackSource  string
+	rollbackTo      string
+	rollbackAuthor  string
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Go back to an earlier image of a repo on a cluster, push and sync",
+	Long: `Finds the image deployed before the current one for the given repo and
+cluster in the gitops repo history (or the one given by --to), commits it as a
+rollback, pushes and syncs the cluster.`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := doRollback()
+		if err != nil {
+			log.Fatal(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().StringVar(&rollbackCluster, "cluster", "", "Cluster to roll back, as given by 'kubectl config get-contexts'. Eg: minikube")
+	rollbackCmd.MarkFlagRequired("cluster")
+	rollbackCmd.Flags().StringVar(&rollbackSource, "source", "", "Repo to roll back, as recored in the '__mygitops' section. Eg: github.com/foo/bar")
+	rollbackCmd.MarkFlagRequired("source")
+	rollbackCmd.Flags().StringVar(&rollbackTo, "to", "", "Tag or commit of the deploy to go back to. Defaults to the image deployed before the current one")
+	rollbackCmd.Flags().StringVar(&rollbackAuthor, "author", os.Getenv("USER"), "Author recorded for this rollback. Eg: linus@kernel.org")
+	rollbackCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Don't do any actual changes to the cluster")
+}
+
+func doRollback() error {
+	if rollbackAuthor == "" {
+		return errors.New("No author for the rollback, use --author")
+	}
+
+	relMgr := services.NewReleaseManagerBatched(&services.ReleaseManagerBatchedOptions{
+		GitopsRepo: gitopsRepo,
+		KubeConfig: getKubeconfig(),
+		HelmHome:   getHelmHome(),
+		DryRun:     dryRun,
+	})
+	if err := relMgr.Init(); err != nil {
+		return errors.New(fmt.Sprintf("Failed init release manager: %v", err))
+	}
+
+	dopts := &deploy.DeployOptions{
+		TriggerRepo: rollbackSource,
+		Author:      rollbackAuthor,
+		Cluster:     rollbackCluster,
+		Rollback:    true,
+		RollbackTo:  rollbackTo,
+	}
+
+	if err := relMgr.RequestRelease(dopts); err != nil {
+		return errors.New(fmt.Sprintf("Failed rollback %v: %v", dopts, err))
+	}
+
+	log.Printf("Rolled back %s on %s to %s:%s", dopts.TriggerRepo, dopts.Cluster, dopts.Image.Repository, dopts.Image.Tag)
+	return nil
+}
@@ -5,16 +5,22 @@ import (
 	"github.com/spf13/cobra"
 	"os"
 
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/autoupdate"
 	"github.com/valer-cara/mgo/pkg/config"
+	"github.com/valer-cara/mgo/pkg/git"
 	"github.com/valer-cara/mgo/pkg/notification"
 	"github.com/valer-cara/mgo/pkg/notification/slack"
+	"github.com/valer-cara/mgo/pkg/registry"
 	"github.com/valer-cara/mgo/pkg/server"
+	"github.com/valer-cara/mgo/pkg/webhook"
 )
 
 var (
 	serveAddr   string
 	kubeconfig  string
 	kubecontext string
+	stateDir    string
 )
 
 var serveCmd = &cobra.Command{
@@ -36,6 +42,7 @@ func init() {
 	serveCmd.Flags().StringVarP(&serveAddr, "listen", "l", "127.0.0.1:8080", "Listen to address")
 	serveCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Alternative kubeconfig for helm")
 	serveCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Dry Run mode")
+	serveCmd.Flags().StringVar(&stateDir, "state-dir", "", "Dir where queued deploys are journaled, to be replayed after a restart. Empty keeps them in memory only")
 }
 
 func doServe() error {
@@ -49,6 +56,7 @@ func doServe() error {
 	log.Printf("  - handling gitops repo at %s", gitopsRepo)
 	log.Printf("  - kubeconfig at %s", kubeconfig)
 	log.Printf("  - helmHome at %s", helmHome)
+	log.Printf("  - state dir at %s", stateDir)
 
 	var slackWebhookNotifier notification.Notification
 
@@ -62,15 +70,77 @@ func doServe() error {
 		)
 	}
 
+	var authenticator *auth.Authenticator
+
+	if config.Global.Auth.Enabled() {
+		log.Printf("  - authenticating %d API clients", len(config.Global.Auth.Clients))
+		var err error
+		authenticator, err = auth.NewAuthenticator(&config.Global.Auth)
+		if err != nil {
+			return err
+		}
+		defer authenticator.Close()
+	} else {
+		log.Warnln("No API clients configured in mygitops.yaml (`auth.clients`), the API is open to anyone")
+	}
+
+	var webhooks *webhook.Verifier
+
+	if len(config.Global.Webhooks) > 0 {
+		log.Printf("  - checking webhooks of %d sources", len(config.Global.Webhooks))
+		var err error
+		webhooks, err = webhook.NewVerifier(config.Global.Webhooks)
+		if err != nil {
+			return err
+		}
+	}
+
+	routes, err := webhook.NewRoutes(config.Global.Routes)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		log.Printf("  - serving webhook route %s", route.Path)
+	}
+
+	var autoUpdater *autoupdate.AutoUpdater
+
+	if config.Global.AutoUpdate.Enabled() {
+		log.Printf("  - auto updating images on %v", config.Global.AutoUpdate.Clusters)
+		layout, err := config.Global.RepoLayout()
+		if err != nil {
+			return err
+		}
+		gitService, err := git.NewGit(git.BACKEND_EXTERNAL, gitopsRepo, &config.Global.Git)
+		if err != nil {
+			return err
+		}
+		autoUpdater, err = autoupdate.NewAutoUpdater(
+			gitService,
+			layout,
+			&config.Global.AutoUpdate,
+			registry.NewClient(config.Global.Registries),
+			slackWebhookNotifier,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	serv := server.NewServer(
 		serveAddr,
 		gitopsRepo,
 		helmHome,
 		kubeconfig,
+		stateDir,
 		slackWebhookNotifier,
+		authenticator,
+		webhooks,
+		routes,
+		autoUpdater,
 		dryRun,
 	)
-	err := serv.Serve()
+	err = serv.Serve()
 	if err != nil {
 		return err
 	}
@@ -4,9 +4,13 @@ import (
 	"errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io/ioutil"
 	"os"
 
+	"github.com/valer-cara/mgo/pkg/config"
+	"github.com/valer-cara/mgo/pkg/kubectl"
 	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
 )
 
 var (
@@ -36,7 +40,12 @@ func init() {
 func doValidate() error {
 	var allGood = true
 
-	manifests, err := manifest.FindManifests(gitopsRepo, validateCluster)
+	layout, err := config.Global.RepoLayout()
+	if err != nil {
+		return err
+	}
+
+	manifests, err := manifest.FindManifests(layout, gitopsRepo, validateCluster)
 	if err != nil {
 		log.Printf("Cannot locate manifests in repo %s for cluster %s", gitopsRepo, validateCluster)
 		return err
@@ -53,6 +62,47 @@ func doValidate() error {
 		if err != nil {
 			log.Printf("File %s invalid: %v", file, err)
 			allGood = false
+			continue
+		}
+
+		valueFiles, err := header.ResolveValueFiles(gitopsRepo, file)
+		if err != nil {
+			log.Printf("File %s invalid: %v", file, err)
+			allGood = false
+			continue
+		}
+
+		// Secrets must never be committed in plaintext
+		for _, valueFile := range valueFiles {
+			if !secrets.IsSecretsFile(valueFile) {
+				continue
+			}
+
+			content, err := ioutil.ReadFile(valueFile)
+			if err != nil {
+				log.Printf("Cannot read secrets file %s: %v", valueFile, err)
+				allGood = false
+			} else if !secrets.IsEncrypted(content) {
+				log.Printf("Secrets file %s is not encrypted with sops", valueFile)
+				allGood = false
+			}
+		}
+	}
+
+	if len(manifests.Kustomize) > 0 {
+		kubectlService := kubectl.NewKubectlCmd(&kubectl.KubectlCmdOptions{
+			Kubeconfig:  getKubeconfig(),
+			Kubecontext: validateCluster,
+		})
+		if err := kubectlService.Init(); err != nil {
+			return err
+		}
+
+		for _, dir := range manifests.Kustomize {
+			if _, err := kubectlService.Kustomize(dir); err != nil {
+				log.Printf("Kustomization %s invalid: %v", dir, err)
+				allGood = false
+			}
 		}
 	}
 
@@ -1,13 +1,19 @@
 package async
 
 type Result struct {
+	// The deploy request this is the result of
+	ID string
+
+	// Buffered: sending a result never blocks, even if nobody is waiting on it
+	// (eg: for deploys replayed after a restart)
 	Done chan bool
 	Err  chan error
 }
 
-func NewResult() *Result {
+func NewResult(id string) *Result {
 	return &Result{
-		Done: make(chan bool),
-		Err:  make(chan error),
+		ID:   id,
+		Done: make(chan bool, 1),
+		Err:  make(chan error, 1),
 	}
 }
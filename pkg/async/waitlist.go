@@ -20,6 +20,15 @@ func (w *Waitlist) Add(pendingResult *Result) {
 	w.mutex.Unlock()
 }
 
+// Snapshot of the pending results
+func (w *Waitlist) Results() []*Result {
+	w.mutex.Lock()
+	results := make([]*Result, len(w.pendingResults))
+	copy(results, w.pendingResults)
+	w.mutex.Unlock()
+	return results
+}
+
 func (w *Waitlist) Clear() {
 	w.mutex.Lock()
 	w.pendingResults = []*Result{}
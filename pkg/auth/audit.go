@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EVENT_AUTHENTICATION_FAILED = "authentication_failed"
+	EVENT_AUTHORIZATION_DENIED  = "authorization_denied"
+)
+
+type AuditEvent struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	Client      string    `json:"client,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Cluster     string    `json:"cluster,omitempty"`
+	TriggerRepo string    `json:"triggerRepo,omitempty"`
+	Reason      string    `json:"reason"`
+}
+
+// Audit log of rejected requests. A nil Audit logs to the server logs
+type Audit struct {
+	file  *os.File
+	mutex *sync.Mutex
+}
+
+// Appends to the file at `filePath`. Empty logs with the server logs
+func NewAudit(filePath string) (*Audit, error) {
+	audit := &Audit{mutex: &sync.Mutex{}}
+	if filePath == "" {
+		return audit, nil
+	}
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot open audit log %s: %v", filePath, err))
+	}
+	audit.file = file
+
+	return audit, nil
+}
+
+func (a *Audit) Record(r *http.Request, event, client, cluster, triggerRepo, reason string) {
+	entry := &AuditEvent{
+		Time:        time.Now().UTC(),
+		Event:       event,
+		Client:      client,
+		RemoteAddr:  r.RemoteAddr,
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Cluster:     cluster,
+		TriggerRepo: triggerRepo,
+		Reason:      reason,
+	}
+
+	if a == nil || a.file == nil {
+		log.WithFields(log.Fields{
+			"event":       entry.Event,
+			"client":      entry.Client,
+			"remoteAddr":  entry.RemoteAddr,
+			"path":        entry.Path,
+			"cluster":     entry.Cluster,
+			"triggerRepo": entry.TriggerRepo,
+		}).Warnf("Audit: %s", entry.Reason)
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("Cannot encode audit event: %v", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Errorf("Cannot write audit log: %v", err)
+	}
+}
+
+func (a *Audit) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
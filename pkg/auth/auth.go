@@ -0,0 +1,340 @@
+/*
+ * Auth: authenticates HTTP API clients and checks which clusters and trigger
+ * repos each of them may deploy to.
+ *
+ * Clients authenticate either with a bearer token:
+ *
+ *	Authorization: Bearer <token>
+ *
+ * or by signing the request with a shared secret:
+ *
+ *	X-Mgo-Client: <client name>
+ *	X-Mgo-Timestamp: <unix time>
+ *	X-Mgo-Signature: sha256=<hex hmac-sha256 of "<timestamp>\n<method>\n<request uri>\n<body>">
+ *
+ * A signed request is only accepted once.
+ */
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HEADER_CLIENT    = "X-Mgo-Client"
+	HEADER_TIMESTAMP = "X-Mgo-Timestamp"
+	HEADER_SIGNATURE = "X-Mgo-Signature"
+)
+
+// How far the timestamp of a signed request may be from the server's clock
+const maxClockSkew = 5 * time.Minute
+
+// Largest request body that gets signature checked
+const maxSignedBody = 10 << 20
+
+type Config struct {
+	Clients []ClientConfig
+
+	// File where rejected requests are appended as JSON lines. Empty logs
+	// them with the server logs
+	AuditLog string `yaml:"auditLog"`
+}
+
+type ClientConfig struct {
+	Name string
+
+	// At least one of them. `${VAR}` is replaced by the environment variable,
+	// to keep secrets out of the gitops repo
+	Token      string
+	HmacSecret string `yaml:"hmacSecret"`
+
+	// What the client may deploy, as patterns where `*` matches anything.
+	// Eg: `github.com/foo/*`. `*` allows any, empty allows none
+	Clusters     []string
+	TriggerRepos []string `yaml:"triggerRepos"`
+}
+
+// Auth is enabled as soon as a client is configured
+func (c *Config) Enabled() bool {
+	return len(c.Clients) > 0
+}
+
+func (c *Config) Validate() error {
+	names := map[string]bool{}
+	for i, client := range c.Clients {
+		if client.Name == "" {
+			return errors.New(fmt.Sprintf("auth: client %d has no name", i))
+		}
+		if names[client.Name] {
+			return errors.New(fmt.Sprintf("auth: client %s is defined twice", client.Name))
+		}
+		names[client.Name] = true
+
+		if os.ExpandEnv(client.Token) == "" && os.ExpandEnv(client.HmacSecret) == "" {
+			return errors.New(fmt.Sprintf("auth: client %s has neither a token nor an hmacSecret", client.Name))
+		}
+	}
+	return nil
+}
+
+type Client struct {
+	Name string
+
+	token        string
+	hmacSecret   string
+	clusters     []string
+	triggerRepos []string
+}
+
+// A client authenticated by other means (eg: a verified webhook), allowed the
+// given clusters and trigger repos
+func NewClient(name string, clusters, triggerRepos []string) *Client {
+	return &Client{
+		Name:         name,
+		clusters:     clusters,
+		triggerRepos: triggerRepos,
+	}
+}
+
+// Whether the client may deploy `triggerRepo` to `cluster`
+func (c *Client) Allowed(cluster, triggerRepo string) bool {
+	return matchAny(c.clusters, cluster) && matchAny(c.triggerRepos, triggerRepo)
+}
+
+type Authenticator struct {
+	clients []*Client
+	audit   *Audit
+
+	// Overridden in tests
+	now func() time.Time
+
+	// Signatures of the signed requests accepted, until their timestamp is
+	// too far off for them to be accepted again
+	seenMu sync.Mutex
+	seen   map[string]time.Time
+}
+
+func NewAuthenticator(config *Config) (*Authenticator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	audit, err := NewAudit(config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		audit: audit,
+		now:   time.Now,
+		seen:  map[string]time.Time{},
+	}
+	for _, client := range config.Clients {
+		a.clients = append(a.clients, &Client{
+			Name:         client.Name,
+			token:        os.ExpandEnv(client.Token),
+			hmacSecret:   os.ExpandEnv(client.HmacSecret),
+			clusters:     client.Clusters,
+			triggerRepos: client.TriggerRepos,
+		})
+	}
+
+	return a, nil
+}
+
+// Without clients configured, every request goes through unauthenticated
+func (a *Authenticator) Enabled() bool {
+	return a != nil && len(a.clients) > 0
+}
+
+// The client making the request. Failures are audited
+func (a *Authenticator) Authenticate(r *http.Request) (*Client, error) {
+	client, err := a.authenticate(r)
+	if err != nil {
+		a.audit.Record(r, EVENT_AUTHENTICATION_FAILED, r.Header.Get(HEADER_CLIENT), "", "", err.Error())
+		return nil, err
+	}
+	return client, nil
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*Client, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header {
+			return nil, errors.New("unsupported Authorization scheme, use `Bearer`")
+		}
+
+		for _, client := range a.clients {
+			if client.token != "" && subtle.ConstantTimeCompare([]byte(client.token), []byte(token)) == 1 {
+				return client, nil
+			}
+		}
+		return nil, errors.New("invalid bearer token")
+	}
+
+	if name := r.Header.Get(HEADER_CLIENT); name != "" {
+		return a.authenticateSigned(r, name)
+	}
+
+	return nil, errors.New("missing credentials: send a bearer token or a signed request")
+}
+
+func (a *Authenticator) authenticateSigned(r *http.Request, name string) (*Client, error) {
+	var client *Client
+	for _, c := range a.clients {
+		if c.Name == name && c.hmacSecret != "" {
+			client = c
+		}
+	}
+	if client == nil {
+		return nil, errors.New(fmt.Sprintf("unknown client %s", name))
+	}
+
+	timestamp := r.Header.Get(HEADER_TIMESTAMP)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("missing or bad %s header", HEADER_TIMESTAMP))
+	}
+	if skew := a.now().Sub(time.Unix(unix, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errors.New("request timestamp too far off")
+	}
+
+	body := []byte{}
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxSignedBody))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot read request body: %v", err))
+		}
+		// Left for the handler to read
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := Signature(client.hmacSecret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HEADER_SIGNATURE))) {
+		return nil, errors.New("invalid request signature")
+	}
+	if !a.firstSeen(expected, time.Unix(unix, 0).Add(maxClockSkew)) {
+		return nil, errors.New("replayed request signature")
+	}
+
+	return client, nil
+}
+
+// Whether `signature` wasn't seen before. It's remembered until `expires`
+func (a *Authenticator) firstSeen(signature string, expires time.Time) bool {
+	a.seenMu.Lock()
+	defer a.seenMu.Unlock()
+
+	now := a.now()
+	for seen, seenExpires := range a.seen {
+		if now.After(seenExpires) {
+			delete(a.seen, seen)
+		}
+	}
+
+	if _, ok := a.seen[signature]; ok {
+		return false
+	}
+	a.seen[signature] = expires
+	return true
+}
+
+// Checks the client authenticated for this request may deploy `triggerRepo`
+// to `cluster`. Without auth configured, requests without a client are let
+// through. Rejections are audited
+func (a *Authenticator) Authorize(r *http.Request, cluster, triggerRepo string) error {
+	client := ClientFrom(r.Context())
+	if client == nil {
+		if !a.Enabled() {
+			return nil
+		}
+		a.audit.Record(r, EVENT_AUTHORIZATION_DENIED, "", cluster, triggerRepo, "not authenticated")
+		return errors.New("not authenticated")
+	}
+
+	if !client.Allowed(cluster, triggerRepo) {
+		a.Audit().Record(r, EVENT_AUTHORIZATION_DENIED, client.Name, cluster, triggerRepo, "not allowed")
+		return errors.New(fmt.Sprintf("client %s may not deploy %s to %s", client.Name, triggerRepo, cluster))
+	}
+
+	return nil
+}
+
+// Whether the client authenticated for this request may deploy `triggerRepo`
+// to `cluster`, and so see those deploys. Same as Authorize, without auditing,
+// for filtering lists
+func (a *Authenticator) Allowed(r *http.Request, cluster, triggerRepo string) bool {
+	client := ClientFrom(r.Context())
+	if client == nil {
+		return !a.Enabled()
+	}
+	return client.Allowed(cluster, triggerRepo)
+}
+
+// Nil when auth isn't configured: rejections then go to the server logs
+func (a *Authenticator) Audit() *Audit {
+	if a == nil {
+		return nil
+	}
+	return a.audit
+}
+
+func (a *Authenticator) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.audit.Close()
+}
+
+// The `X-Mgo-Signature` header value of a request
+func Signature(secret, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", timestamp, method, requestURI)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sets the headers of a request signed by `client`
+func SignRequest(r *http.Request, client, secret string, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(HEADER_CLIENT, client)
+	r.Header.Set(HEADER_TIMESTAMP, timestamp)
+	r.Header.Set(HEADER_SIGNATURE, Signature(secret, timestamp, r.Method, r.URL.RequestURI(), body))
+}
+
+type contextKey struct{}
+
+func WithClient(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, contextKey{}, client)
+}
+
+// The client authenticated for the request, nil if none
+func ClientFrom(ctx context.Context) *Client {
+	client, _ := ctx.Value(contextKey{}).(*Client)
+	return client
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		expr := "^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$"
+		if regexp.MustCompile(expr).MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuthenticator(t *testing.T, auditLog string) *Authenticator {
+	os.Setenv("MGO_TEST_HMAC_SECRET", "s3cret")
+	defer os.Unsetenv("MGO_TEST_HMAC_SECRET")
+
+	a, err := NewAuthenticator(&Config{
+		AuditLog: auditLog,
+		Clients: []ClientConfig{
+			{Name: "ci", Token: "t0ken", Clusters: []string{"staging", "prod-*"}, TriggerRepos: []string{"github.com/a/*"}},
+			{Name: "bot", HmacSecret: "${MGO_TEST_HMAC_SECRET}", Clusters: []string{"*"}, TriggerRepos: []string{"github.com/b/repo"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticateBearer(t *testing.T) {
+	a := newTestAuthenticator(t, "")
+
+	tests := []struct {
+		Header   string
+		Expected string
+	}{
+		{"Bearer t0ken", "ci"},
+		{"Bearer nope", ""},
+		{"Basic t0ken", ""},
+		{"", ""},
+	}
+
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", "/deploy", nil)
+		if test.Header != "" {
+			req.Header.Set("Authorization", test.Header)
+		}
+
+		client, err := a.Authenticate(req)
+		if test.Expected == "" {
+			if err == nil {
+				t.Fatalf("[test %d] Expected an error, got client %s", testIdx, client.Name)
+			}
+		} else if err != nil || client.Name != test.Expected {
+			t.Fatalf("[test %d] Expected client %s, got %v %v", testIdx, test.Expected, client, err)
+		}
+	}
+}
+
+func TestAuthenticateSigned(t *testing.T) {
+	a := newTestAuthenticator(t, "")
+	now := time.Unix(1600000000, 0)
+	a.now = func() time.Time { return now }
+
+	body := "cluster=prod&triggerRepo=github.com/b/repo"
+
+	tests := []struct {
+		Client   string
+		Secret   string
+		SignedAt time.Time
+		Body     string
+		Valid    bool
+	}{
+		{"bot", "s3cret", now, body, true},
+		{"bot", "s3cret", now.Add(-2 * time.Minute), body, true},
+		{"bot", "s3cret", now.Add(-10 * time.Minute), body, false},
+		{"bot", "wrong", now, body, false},
+		{"bot", "s3cret", now, body + "&cluster=other", false},
+		{"ci", "t0ken", now, body, false},
+		{"nobody", "s3cret", now, body, false},
+	}
+
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", "/deploy?async=true", strings.NewReader(test.Body))
+		SignRequest(req, test.Client, test.Secret, []byte(body), test.SignedAt)
+
+		client, err := a.Authenticate(req)
+		if !test.Valid {
+			if err == nil {
+				t.Fatalf("[test %d] Expected an error", testIdx)
+			}
+			continue
+		}
+		if err != nil || client.Name != test.Client {
+			t.Fatalf("[test %d] Expected client %s, got %v", testIdx, test.Client, err)
+		}
+
+		// The body is still there for the handler
+		if b, _ := ioutil.ReadAll(req.Body); string(b) != body {
+			t.Fatalf("[test %d] Expected the body to be readable again, got '%s'", testIdx, string(b))
+		}
+	}
+}
+
+func TestAuthenticateSignedReplay(t *testing.T) {
+	auditLog := path.Join(t.TempDir(), "audit.log")
+	a := newTestAuthenticator(t, auditLog)
+	now := time.Unix(1600000000, 0)
+	a.now = func() time.Time { return now }
+
+	body := "cluster=prod&triggerRepo=github.com/b/repo"
+	request := func(signedAt time.Time) *http.Request {
+		req := httptest.NewRequest("POST", "/deploy", strings.NewReader(body))
+		SignRequest(req, "bot", "s3cret", []byte(body), signedAt)
+		return req
+	}
+
+	if _, err := a.Authenticate(request(now)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(maxClockSkew)
+	if _, err := a.Authenticate(request(now.Add(-maxClockSkew))); err == nil {
+		t.Fatal("Expected the replayed request to be rejected")
+	}
+
+	// Signed a second later: a new request
+	if _, err := a.Authenticate(request(now.Add(-maxClockSkew + time.Second))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forgotten once too far off to be accepted anyway
+	now = now.Add(time.Second)
+	if _, err := a.Authenticate(request(now.Add(-maxClockSkew - time.Second))); err == nil {
+		t.Fatal("Expected the expired request to be rejected")
+	}
+	if _, err := a.Authenticate(request(now)); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.seen) != 2 {
+		t.Fatalf("Expected the expired signature to be forgotten, got %v", a.seen)
+	}
+
+	a.Close()
+	audited, err := ioutil.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(audited), "replayed request signature") {
+		t.Fatalf("Expected the replay to be audited, got %s", audited)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	auditLog := path.Join(t.TempDir(), "audit.log")
+	a := newTestAuthenticator(t, auditLog)
+
+	tests := []struct {
+		Client      string
+		Cluster     string
+		TriggerRepo string
+		Allowed     bool
+	}{
+		{"ci", "staging", "github.com/a/repo1", true},
+		{"ci", "prod-eu", "github.com/a/nested/repo", true},
+		{"ci", "prod", "github.com/a/repo1", false},
+		{"ci", "staging", "github.com/b/repo", false},
+		{"bot", "anything", "github.com/b/repo", true},
+		{"bot", "anything", "github.com/b/repo2", false},
+		{"", "staging", "github.com/a/repo1", false},
+	}
+
+	denied := 0
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", "/deploy", nil)
+		for _, client := range a.clients {
+			if client.Name == test.Client {
+				req = req.WithContext(WithClient(req.Context(), client))
+			}
+		}
+
+		err := a.Authorize(req, test.Cluster, test.TriggerRepo)
+		if test.Allowed != (err == nil) {
+			t.Fatalf("[test %d] Expected allowed=%v, got %v", testIdx, test.Allowed, err)
+		}
+		if err != nil {
+			denied++
+		}
+	}
+	a.Close()
+
+	file, err := os.Open(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	events := []AuditEvent{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		event := AuditEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != denied {
+		t.Fatalf("Expected %d audited rejections, got %d", denied, len(events))
+	}
+	if events[0].Event != EVENT_AUTHORIZATION_DENIED || events[0].Client != "ci" || events[0].Cluster != "prod" {
+		t.Fatalf("Unexpected audit event %+v", events[0])
+	}
+}
+
+func TestAuthorizeDisabled(t *testing.T) {
+	var a *Authenticator
+	if err := a.Authorize(httptest.NewRequest("POST", "/deploy", nil), "prod", "github.com/a/repo1"); err != nil {
+		t.Fatal("Expected anything to be allowed without auth configured:", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	bad := []Config{
+		{Clients: []ClientConfig{{Token: "x"}}},
+		{Clients: []ClientConfig{{Name: "a"}}},
+		{Clients: []ClientConfig{{Name: "a", Token: "x"}, {Name: "a", Token: "y"}}},
+		{Clients: []ClientConfig{{Name: "a", Token: "${MGO_TEST_UNSET_VAR}"}}},
+	}
+
+	for testIdx, config := range bad {
+		if err := config.Validate(); err == nil {
+			t.Fatalf("[test %d] Expected a validation error", testIdx)
+		}
+	}
+}
+
+func TestAuthorizeWithoutAuthenticator(t *testing.T) {
+	var a *Authenticator
+	req := httptest.NewRequest("POST", "/deploy/dockerhub", nil)
+	req = req.WithContext(WithClient(req.Context(), NewClient("webhook", []string{"staging"}, []string{"*"})))
+
+	if err := a.Authorize(req, "staging", "github.com/a/repo1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Authorize(req, "prod", "github.com/a/repo1"); err == nil {
+		t.Fatal("Expected the client's allowlist to apply without auth configured")
+	}
+}
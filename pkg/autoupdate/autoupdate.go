@@ -0,0 +1,310 @@
+/*
+ * Autoupdate: deploys new images without anybody asking for it. The
+ * registries of the images having an `autoUpdate` policy in their
+ * `__mygitops` header are polled, and new tags matching the policy are
+ * deployed through the release manager, like any other deploy request.
+ */
+package autoupdate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/valer-cara/mgo/pkg/async"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/notification"
+	"github.com/valer-cara/mgo/pkg/registry"
+)
+
+// Author of the deploys
+const AUTHOR = "mgo-autoupdate"
+
+const defaultInterval = 5 * time.Minute
+
+// Auto update section in `mygitops.yaml`, eg:
+//
+//	autoUpdate:
+//	  interval: 10m
+//	  clusters: [staging]
+type Config struct {
+	// How often registries are polled, eg: `10m`. 5 minutes by default
+	Interval string
+	// Clusters whose images are kept up to date. None means auto updates are off
+	Clusters []string
+}
+
+func (c *Config) Enabled() bool {
+	return len(c.Clusters) > 0
+}
+
+func (c *Config) Validate() error {
+	_, err := c.interval()
+	return err
+}
+
+func (c *Config) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return defaultInterval, nil
+	}
+
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval <= 0 {
+		return 0, errors.New(fmt.Sprintf("autoUpdate: bad interval `%s`", c.Interval))
+	}
+	return interval, nil
+}
+
+// Where deploys go, the services.ReleaseManager
+type ReleaseQueue interface {
+	QueueRelease(*deploy.DeployOptions) (*async.Result, error)
+}
+
+type AutoUpdater struct {
+	// Shared with the release manager, whose batches reset and check out its
+	// work tree. Headers are read from the remote branch instead
+	gitService *git.Git
+	layout     manifest.Layout
+	clusters   []string
+	interval   time.Duration
+
+	registry *registry.Client
+	// Optional
+	notifier notification.Notification
+
+	// Deploys not done yet, by cluster and trigger repo, so they're not
+	// requested again before they land in the gitops repo
+	pendingMu sync.Mutex
+	pending   map[string]string
+
+	// Build dates by image, for the `latest` policy
+	createdMu sync.Mutex
+	created   map[string]time.Time
+}
+
+func NewAutoUpdater(gitService *git.Git, layout manifest.Layout, config *Config, registryClient *registry.Client, notifier notification.Notification) (*AutoUpdater, error) {
+	interval, err := config.interval()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AutoUpdater{
+		gitService: gitService,
+		layout:     layout,
+		clusters:   config.Clusters,
+		interval:   interval,
+		registry:   registryClient,
+		notifier:   notifier,
+		pending:    make(map[string]string),
+		created:    make(map[string]time.Time),
+	}, nil
+}
+
+// Poll the registries every interval, forever
+func (u *AutoUpdater) Run(releaseManager ReleaseQueue) {
+	for {
+		u.Check(releaseManager)
+		time.Sleep(u.interval)
+	}
+}
+
+// Look for new tags once and queue their deploys. Returns the deploys
+// queued. Clusters whose values files can't be listed, values files that
+// can't be read and images whose registry can't be reached are skipped
+func (u *AutoUpdater) Check(releaseManager ReleaseQueue) []*deploy.DeployOptions {
+	queued := []*deploy.DeployOptions{}
+
+	for _, cluster := range u.clusters {
+		images, err := u.images(cluster)
+		if err != nil {
+			log.Errorf("Auto update of %s: %v", cluster, err)
+			continue
+		}
+
+		for triggerRepo, image := range images {
+			dopts, err := u.check(cluster, triggerRepo, image)
+			if err != nil {
+				log.Errorf("Auto update of %s on %s: %v", triggerRepo, cluster, err)
+				continue
+			}
+			if dopts == nil {
+				continue
+			}
+
+			if err := u.queue(releaseManager, dopts); err != nil {
+				log.Errorf("Auto update of %s on %s: %v", triggerRepo, cluster, err)
+				continue
+			}
+			queued = append(queued, dopts)
+		}
+	}
+
+	return queued
+}
+
+// Images having an auto update policy in the cluster's values files, as
+// pushed, by trigger repo
+func (u *AutoUpdater) images(cluster string) (map[string]manifest.HeaderImage, error) {
+	root := u.gitService.Root()
+	valueFiles, err := u.layout.ValueFiles(root, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	images := map[string]manifest.HeaderImage{}
+	for _, valueFile := range valueFiles {
+		file, err := filepath.Rel(root, valueFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// Not pushed yet, or gone since
+		content, err := u.gitService.Show(u.gitService.RemoteRef(), filepath.ToSlash(file))
+		if err != nil {
+			log.Warnf("Auto update of %s: skipping %s: %v", cluster, file, err)
+			continue
+		}
+
+		header, err := manifest.ParseHeaderContent(file, content)
+		if err == nil {
+			err = header.Validate()
+		}
+		if err != nil {
+			log.Errorf("Auto update of %s: skipping %s: %v", cluster, file, err)
+			continue
+		}
+
+		for triggerRepo, image := range header.Images {
+			if image.AutoUpdate != nil {
+				images[triggerRepo] = image
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// The deploy moving the image to a newer tag, nil when it's up to date
+func (u *AutoUpdater) check(cluster, triggerRepo string, image manifest.HeaderImage) (*deploy.DeployOptions, error) {
+	if image.Frozen {
+		return nil, nil
+	}
+
+	repository, currentTag := image.Current()
+	if repository == "" {
+		return nil, errors.New("no image repository in the `__mygitops` header")
+	}
+
+	allTags, err := u.registry.Tags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only what the image's policy would let through
+	tags := []string{}
+	for _, tag := range allTags {
+		if image.CheckTag(tag, false) == nil {
+			tags = append(tags, tag)
+		}
+	}
+
+	tag, err := Candidate(image.AutoUpdate, currentTag, tags, func(tag string) (time.Time, error) {
+		return u.createdAt(repository, tag)
+	})
+	if err != nil || tag == "" {
+		return nil, err
+	}
+
+	u.pendingMu.Lock()
+	defer u.pendingMu.Unlock()
+	if u.pending[cluster+" "+triggerRepo] == tag {
+		return nil, nil
+	}
+
+	return &deploy.DeployOptions{
+		TriggerRepo: triggerRepo,
+		Author:      AUTHOR,
+		Cluster:     cluster,
+		Image:       deploy.DeployOptionsImage{Repository: repository, Tag: tag},
+	}, nil
+}
+
+func (u *AutoUpdater) createdAt(repository, tag string) (time.Time, error) {
+	key := repository + ":" + tag
+
+	u.createdMu.Lock()
+	created, ok := u.created[key]
+	u.createdMu.Unlock()
+	if ok {
+		return created, nil
+	}
+
+	created, err := u.registry.Created(repository, tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	u.createdMu.Lock()
+	u.created[key] = created
+	u.createdMu.Unlock()
+
+	return created, nil
+}
+
+// Queue the deploy and notify once it's done
+func (u *AutoUpdater) queue(releaseManager ReleaseQueue, dopts *deploy.DeployOptions) error {
+	key := dopts.Cluster + " " + dopts.TriggerRepo
+
+	log.Printf("Auto update: new deploy request: %s", dopts)
+	result, err := releaseManager.QueueRelease(dopts)
+	if err != nil {
+		return err
+	}
+
+	u.pendingMu.Lock()
+	u.pending[key] = dopts.Image.Tag
+	u.pendingMu.Unlock()
+
+	go func() {
+		var err error
+		select {
+		case <-result.Done:
+			log.Printf("Auto update: deploy %s successful!", dopts.ID)
+		case err = <-result.Err:
+			log.Errorf("Auto update: deploy %s failed: %v", dopts.ID, err)
+		}
+
+		// A failed deploy is retried on the next poll
+		u.pendingMu.Lock()
+		delete(u.pending, key)
+		u.pendingMu.Unlock()
+
+		u.sendNotification(dopts, err)
+	}()
+
+	return nil
+}
+
+func (u *AutoUpdater) sendNotification(dopts *deploy.DeployOptions, err error) {
+	if u.notifier == nil {
+		return
+	}
+
+	errNotif := u.notifier.Deployed(
+		dopts.TriggerRepo,
+		dopts.Image.Repository,
+		dopts.Image.Tag,
+		dopts.Cluster,
+		dopts.Author,
+		err,
+	)
+	if errNotif != nil {
+		log.Errorf("Error sending notification, err: %v", errNotif)
+	}
+}
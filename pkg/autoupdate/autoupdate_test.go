@@ -0,0 +1,225 @@
+package autoupdate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/async"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/registry"
+	"github.com/valer-cara/mgo/pkg/testutils"
+	"github.com/valer-cara/mgo/pkg/util"
+)
+
+// Keeps the results of the deploys, to be signaled by the test
+type testQueue struct {
+	results []*async.Result
+}
+
+func (q *testQueue) QueueRelease(dopts *deploy.DeployOptions) (*async.Result, error) {
+	dopts.ID = dopts.TriggerRepo
+	result := async.NewResult(dopts.ID)
+	q.results = append(q.results, result)
+	return result, nil
+}
+
+const testValues = `__mygitops:
+  chart: stable/web
+  version: 1.0.0
+  name: web
+  namespace: default
+  images:
+    github.com/foo/web:
+      repository: %s/foo/web
+      tag: 1.0.0
+      autoUpdate:
+        policy: semver
+        semver: ~1
+    github.com/foo/worker:
+      image: %s/foo/worker:main-1
+      autoUpdate:
+        policy: latest
+        pattern: ^main-
+    github.com/foo/pinned:
+      repository: %s/foo/web
+      tag: 1.0.0
+    github.com/foo/frozen:
+      repository: %s/foo/web
+      tag: 1.0.0
+      frozen: true
+      autoUpdate:
+        policy: semver
+`
+
+func TestCheck(t *testing.T) {
+	reg := testutils.NewTestRegistry(t)
+	built := time.Now().Add(-time.Hour)
+	for i, tag := range []string{"1.0.0", "1.1.0", "2.0.0"} {
+		reg.Push("foo/web", tag, built.Add(time.Duration(i)*time.Minute))
+	}
+	reg.Push("foo/worker", "main-1", built)
+	reg.Push("foo/worker", "main-2", built.Add(time.Minute))
+
+	gitService := testGitopsRepo(t, map[string]string{
+		"installations/staging/web-values.yaml": fmt.Sprintf(testValues, reg.Host, reg.Host, reg.Host, reg.Host),
+	})
+
+	updater, err := NewAutoUpdater(
+		gitService,
+		&manifest.DefaultLayout{},
+		&Config{Clusters: []string{"staging"}},
+		registry.NewClient([]registry.RegistryConfig{{Host: reg.Host, Insecure: true}}),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := &testQueue{}
+	queued := updater.Check(queue)
+
+	expected := map[string]deploy.DeployOptionsImage{
+		"github.com/foo/web":    {Repository: reg.Host + "/foo/web", Tag: "1.1.0"},
+		"github.com/foo/worker": {Repository: reg.Host + "/foo/worker", Tag: "main-2"},
+	}
+	if len(queued) != len(expected) {
+		t.Fatalf("Expected %d deploys, got %v", len(expected), queued)
+	}
+	for _, dopts := range queued {
+		if dopts.Image != expected[dopts.TriggerRepo] || dopts.Cluster != "staging" || dopts.Author != AUTHOR {
+			t.Fatalf("Unexpected deploy %s", dopts)
+		}
+	}
+
+	// Not requested again while in flight
+	if queued := updater.Check(queue); len(queued) != 0 {
+		t.Fatalf("Expected no deploys while the others are pending, got %v", queued)
+	}
+
+	for _, result := range queue.results {
+		result.Done <- true
+	}
+	waitPending(t, updater)
+
+	// The gitops repo is still behind, eg: the deploys failed
+	if queued := updater.Check(queue); len(queued) != 2 {
+		t.Fatalf("Expected the deploys to be requested again, got %v", queued)
+	}
+}
+
+// Bad values files are skipped, without stopping the check of the other
+// files and clusters. Only pushed content is read
+func TestCheckSkipsBadValues(t *testing.T) {
+	reg := testutils.NewTestRegistry(t)
+	reg.Push("foo/web", "1.0.0", time.Now().Add(-time.Hour))
+	reg.Push("foo/web", "1.1.0", time.Now().Add(-time.Minute))
+
+	values := `__mygitops:
+  chart: stable/web
+  version: 1.0.0
+  name: web
+  namespace: default
+  images:
+    github.com/foo/web:
+      repository: ` + reg.Host + `/foo/web
+      tag: 1.0.0
+      autoUpdate:
+        policy: semver
+`
+	gitService := testGitopsRepo(t, map[string]string{
+		"installations/staging/broken-values.yaml":  "__mygitops: [",
+		"installations/staging/invalid-values.yaml": "__mygitops:\n  chart: stable/web\n",
+		"installations/staging/web-values.yaml":     values,
+		"installations/prod/web-values.yaml":        values,
+	})
+
+	// Not pushed: a batch in progress
+	root := gitService.Root()
+	if err := ioutil.WriteFile(filepath.Join(root, "installations", "prod", "web-values.yaml"), []byte("__mygitops: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "installations", "prod", "new-values.yaml"), []byte("__mygitops: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	updater, err := NewAutoUpdater(
+		gitService,
+		&manifest.DefaultLayout{},
+		&Config{Clusters: []string{"missing", "staging", "prod"}},
+		registry.NewClient([]registry.RegistryConfig{{Host: reg.Host, Insecure: true}}),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := updater.Check(&testQueue{})
+	clusters := map[string]bool{}
+	for _, dopts := range queued {
+		if dopts.Image.Tag != "1.1.0" {
+			t.Fatalf("Unexpected deploy %s", dopts)
+		}
+		clusters[dopts.Cluster] = true
+	}
+	if len(queued) != 2 || !clusters["staging"] || !clusters["prod"] {
+		t.Fatalf("Expected a deploy on staging and prod, got %v", queued)
+	}
+}
+
+// A gitops repo with `files` pushed to its origin
+func testGitopsRepo(t *testing.T, files map[string]string) *git.Git {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		file := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = util.CallFunctions(
+		gitService.AddAll,
+		func() error { return gitService.Commit("Add values") },
+		gitService.Push,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gitService
+}
+
+func waitPending(t *testing.T, updater *AutoUpdater) {
+	for i := 0; i < 100; i++ {
+		updater.pendingMu.Lock()
+		pending := len(updater.pending)
+		updater.pendingMu.Unlock()
+
+		if pending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Deploys still pending")
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (&Config{Interval: "soon"}).Validate(); err == nil {
+		t.Fatal("Expected an error for a bad interval")
+	}
+	if err := (&Config{}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
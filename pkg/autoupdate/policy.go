@@ -0,0 +1,137 @@
+package autoupdate
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/valer-cara/mgo/pkg/manifest"
+)
+
+// Never picked by the `latest` policy: it always looks like the newest image
+const TAG_LATEST = "latest"
+
+// When a tag was built
+type CreatedFunc func(tag string) (time.Time, error)
+
+// The tag the image should move to according to the policy, empty when the
+// current one is already the best. Tags that would be a downgrade are never
+// returned
+func Candidate(policy *manifest.AutoUpdate, current string, tags []string, created CreatedFunc) (string, error) {
+	switch policy.Policy {
+	case manifest.AUTO_UPDATE_SEMVER:
+		return candidateSemver(policy, current, tags)
+	case manifest.AUTO_UPDATE_REGEX:
+		return candidateRegex(policy, current, tags)
+	case manifest.AUTO_UPDATE_LATEST:
+		return candidateLatest(policy, current, tags, created)
+	default:
+		return "", errors.New(fmt.Sprintf("unknown auto update policy `%s`", policy.Policy))
+	}
+}
+
+func candidateSemver(policy *manifest.AutoUpdate, current string, tags []string) (string, error) {
+	constraint := "*"
+	if policy.Semver != "" {
+		constraint = policy.Semver
+	}
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	var best *semver.Version
+	bestTag := ""
+	for _, tag := range tags {
+		version, err := semver.NewVersion(tag)
+		if err != nil || !constraints.Check(version) {
+			continue
+		}
+		if best == nil || version.GreaterThan(best) {
+			best, bestTag = version, tag
+		}
+	}
+
+	if best == nil || bestTag == current {
+		return "", nil
+	}
+	if currentVersion, err := semver.NewVersion(current); err == nil && !best.GreaterThan(currentVersion) {
+		return "", nil
+	}
+
+	return bestTag, nil
+}
+
+func candidateRegex(policy *manifest.AutoUpdate, current string, tags []string) (string, error) {
+	pattern, err := regexp.Compile(policy.Pattern)
+	if err != nil {
+		return "", err
+	}
+
+	// Whether tag a comes before tag b. Both match the pattern
+	less := func(a, b string) bool {
+		if pattern.NumSubexp() > 0 {
+			numA, okA := new(big.Int).SetString(pattern.FindStringSubmatch(a)[1], 10)
+			numB, okB := new(big.Int).SetString(pattern.FindStringSubmatch(b)[1], 10)
+			if okA && okB && numA.Cmp(numB) != 0 {
+				return numA.Cmp(numB) < 0
+			}
+		}
+		return a < b
+	}
+
+	bestTag := ""
+	for _, tag := range tags {
+		if !pattern.MatchString(tag) {
+			continue
+		}
+		if bestTag == "" || less(bestTag, tag) {
+			bestTag = tag
+		}
+	}
+
+	if bestTag == "" || bestTag == current {
+		return "", nil
+	}
+	if pattern.MatchString(current) && less(bestTag, current) {
+		return "", nil
+	}
+
+	return bestTag, nil
+}
+
+func candidateLatest(policy *manifest.AutoUpdate, current string, tags []string, created CreatedFunc) (string, error) {
+	pattern, err := regexp.Compile(policy.Pattern)
+	if err != nil {
+		return "", err
+	}
+
+	var bestCreated time.Time
+	bestTag := ""
+	for _, tag := range tags {
+		if tag == TAG_LATEST || !pattern.MatchString(tag) {
+			continue
+		}
+
+		tagCreated, err := created(tag)
+		if err != nil {
+			return "", err
+		}
+		if bestTag == "" || tagCreated.After(bestCreated) {
+			bestCreated, bestTag = tagCreated, tag
+		}
+	}
+
+	if bestTag == "" || bestTag == current {
+		return "", nil
+	}
+	if currentCreated, err := created(current); err == nil && !bestCreated.After(currentCreated) {
+		return "", nil
+	}
+
+	return bestTag, nil
+}
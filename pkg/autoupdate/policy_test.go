@@ -0,0 +1,68 @@
+package autoupdate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/manifest"
+)
+
+func TestCandidate(t *testing.T) {
+	tags := []string{"1.0.0", "1.2.0", "1.2.7", "v1.10.1", "2.0.0", "2.1.0-rc.1", "latest", "main-9-aaaa", "main-10-bbbb", "main-8-cccc"}
+
+	built := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	buildDates := map[string]time.Time{
+		"1.0.0":        built.Add(1 * time.Hour),
+		"1.2.0":        built.Add(2 * time.Hour),
+		"1.2.7":        built.Add(3 * time.Hour),
+		"v1.10.1":      built.Add(4 * time.Hour),
+		"2.0.0":        built.Add(5 * time.Hour),
+		"2.1.0-rc.1":   built.Add(6 * time.Hour),
+		"latest":       built.Add(9 * time.Hour),
+		"main-9-aaaa":  built.Add(7 * time.Hour),
+		"main-10-bbbb": built.Add(8 * time.Hour),
+		"main-8-cccc":  built,
+	}
+	created := func(tag string) (time.Time, error) {
+		if date, ok := buildDates[tag]; ok {
+			return date, nil
+		}
+		return time.Time{}, errors.New("no such tag")
+	}
+
+	tests := []struct {
+		Policy   manifest.AutoUpdate
+		Current  string
+		Expected string
+	}{
+		{manifest.AutoUpdate{Policy: "semver"}, "1.0.0", "2.0.0"},
+		{manifest.AutoUpdate{Policy: "semver", Semver: "~1.2"}, "1.2.0", "1.2.7"},
+		{manifest.AutoUpdate{Policy: "semver", Semver: "^1"}, "1.2.0", "v1.10.1"},
+		{manifest.AutoUpdate{Policy: "semver", Semver: ">=2.1.0-0"}, "2.0.0", "2.1.0-rc.1"},
+		// Already there, or ahead
+		{manifest.AutoUpdate{Policy: "semver", Semver: "~1.2"}, "1.2.7", ""},
+		{manifest.AutoUpdate{Policy: "semver", Semver: "~1.2"}, "1.3.0", ""},
+		// Numeric capture group: 10 comes after 9
+		{manifest.AutoUpdate{Policy: "regex", Pattern: `^main-(\d+)-`}, "main-8-cccc", "main-10-bbbb"},
+		{manifest.AutoUpdate{Policy: "regex", Pattern: `^main-(\d+)-`}, "main-10-bbbb", ""},
+		{manifest.AutoUpdate{Policy: "regex", Pattern: `^main-(\d+)-`}, "main-11-dddd", ""},
+		{manifest.AutoUpdate{Policy: "regex", Pattern: `^main-`}, "main-10-bbbb", "main-9-aaaa"},
+		{manifest.AutoUpdate{Policy: "regex", Pattern: `^main-`}, "1.0.0", "main-9-aaaa"},
+		// `latest` itself is never picked
+		{manifest.AutoUpdate{Policy: "latest"}, "1.0.0", "main-10-bbbb"},
+		{manifest.AutoUpdate{Policy: "latest", Pattern: `^\d+\.\d+\.\d+$`}, "1.0.0", "2.0.0"},
+		{manifest.AutoUpdate{Policy: "latest", Pattern: `^\d+\.\d+\.\d+$`}, "2.0.0", ""},
+		{manifest.AutoUpdate{Policy: "latest", Pattern: `^1\.`}, "2.0.0", ""},
+	}
+
+	for testIdx, test := range tests {
+		candidate, err := Candidate(&test.Policy, test.Current, tags, created)
+		if err != nil {
+			t.Fatalf("[test %d] %v", testIdx, err)
+		}
+		if candidate != test.Expected {
+			t.Fatalf("[test %d] Expected `%s`, got `%s`", testIdx, test.Expected, candidate)
+		}
+	}
+}
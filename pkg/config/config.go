@@ -5,14 +5,54 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/autoupdate"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/forge"
+	"github.com/valer-cara/mgo/pkg/git"
 	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/registry"
+	"github.com/valer-cara/mgo/pkg/secrets"
+	"github.com/valer-cara/mgo/pkg/webhook"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type Config struct {
+	// Where the files of each cluster live in the gitops repo
+	Layout manifest.LayoutConfig
+	// Branch, remote and identity deploys are committed with
+	Git git.Config
+
 	Helm struct {
+		// How helm is run: `cmd` (the helm binary, default) or `sdk` (in-process)
+		Backend      string
 		Repositories []helm.HelmRepo
 	}
+	// How SOPS encrypted `*-secrets.yaml` files are decrypted
+	Secrets secrets.Config
+	// HTTP API clients. No clients means no authentication
+	Auth auth.Config
+
+	// Secrets of the webhook sources, eg: `github`
+	Webhooks webhook.Config
+	// Webhooks of other systems, mapped to deploys with templates
+	Routes []webhook.RouteConfig
+
+	// Credentials of the image registries, by host
+	Registries []registry.RegistryConfig
+	// Pin every deploy to the digest its tag points to in the registry
+	ResolveDigests bool `yaml:"resolveDigests"`
+	// Clusters whose deploys are reviewed in a pull request before landing
+	PullRequests forge.Config `yaml:"pullRequests"`
+	// Clusters images may be promoted between, see `mgo promote`
+	Promotions []deploy.Promotion
+	// Clusters whose failed syncs are rolled back, by reverting the deploys
+	// of the batch. Releases can opt in on their own with `autoRollback`
+	AutoRollback []string `yaml:"autoRollback"`
+	// Polling registries for new tags of the images with an `autoUpdate` policy
+	AutoUpdate autoupdate.Config `yaml:"autoUpdate"`
+
 	Notification struct {
 		Slack struct {
 			Webhookurl string
@@ -35,5 +75,46 @@ func LoadGlobalConfig(path string) error {
 		return errors.New(fmt.Sprintf("YAML Unmarshal error: %s: %v", configFile, err))
 	}
 
+	if _, err := Global.RepoLayout(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if err := Global.Git.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if _, err := helm.NewHelmService(&helm.HelmServiceOptions{Backend: Global.Helm.Backend}); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if err := Global.Auth.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if err := Global.Webhooks.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if _, err := webhook.NewRoutes(Global.Routes); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if err := Global.PullRequests.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if err := deploy.ValidatePromotions(Global.Promotions); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	if err := Global.AutoUpdate.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
 	return nil
 }
+
+// The gitops repo layout set in the config, the default one if none is set
+func (c *Config) RepoLayout() (manifest.Layout, error) {
+	return manifest.NewLayout(&c.Layout)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/reference"
 	"github.com/valer-cara/mgo/pkg/util"
 )
 
@@ -16,9 +17,31 @@ type Deploy struct {
 type DeployOptionsImage struct {
 	Repository string `json:"repository"`
 	Tag        string `json:"tag"`
+	// Optional, eg: `sha256:0123...`. Pins the image the tag pointed to
+	Digest string `json:"digest,omitempty"`
 }
 
+// `repository:tag[@digest]`
+func (i DeployOptionsImage) String() string {
+	return reference.Reference(i).String()
+}
+
+// Trailers added to deploy commits, parsed back by ParseCommitMessage()
+const (
+	TRAILER_DEPLOY_ID    = "Mgo-Deploy-Id"
+	TRAILER_TRIGGER_REPO = "Mgo-Trigger-Repo"
+	TRAILER_IMAGE        = "Mgo-Image"
+	TRAILER_CLUSTER      = "Mgo-Cluster"
+	TRAILER_AUTHOR       = "Mgo-Author"
+	TRAILER_ROLLBACK_TO  = "Mgo-Rollback-To"
+	TRAILER_PROMOTE_FROM = "Mgo-Promote-From"
+	TRAILER_REVERTS      = "Mgo-Reverts"
+)
+
 type DeployOptions struct {
+	// Identifies the deploy request across restarts. Set by the release manager
+	ID string `json:"id,omitempty"`
+
 	// The URL of the repo that triggered this deploy
 	TriggerRepo string `json:"triggerRepo"`
 
@@ -30,18 +53,41 @@ type DeployOptions struct {
 
 	// The target cluster for this deploy
 	Cluster string `json:"cluster"`
+
+	// Pin the image to the digest its tag points to in the registry. Always
+	// on with `resolveDigests` in `mygitops.yaml`
+	ResolveDigest bool `json:"resolveDigest,omitempty"`
+
+	// Rollbacks go back to the image of an earlier deploy of the trigger repo
+	// to the cluster, instead of deploying `Image`
+	Rollback bool `json:"rollback,omitempty"`
+	// Tag or commit of the deploy to go back to. Empty means the previous
+	// image. Once resolved, the commit of that deploy
+	RollbackTo string `json:"rollbackTo,omitempty"`
+
+	// Promotions deploy the image the trigger repo has on this cluster, once
+	// it synced there, instead of `Image`. See ResolvePromotion()
+	PromoteFrom string `json:"promoteFrom,omitempty"`
 }
 
 func (d *DeployOptions) String() string {
-	return fmt.Sprintf("triggerRepo: %s, author: %s, cluster: %s, image: %s:%s",
+	return fmt.Sprintf("triggerRepo: %s, author: %s, cluster: %s, image: %s",
 		d.TriggerRepo,
 		d.Author,
 		d.Cluster,
-		d.Image.Repository,
-		d.Image.Tag,
+		d.Image,
 	)
 }
 
+// The commit trailer identifying this deploy request, eg: `Mgo-Deploy-Id: 1f2e...`
+func (d *DeployOptions) Trailer() string {
+	return Trailer(d.ID)
+}
+
+func Trailer(id string) string {
+	return fmt.Sprintf("%s: %s", TRAILER_DEPLOY_ID, id)
+}
+
 func NewDeploy(gitService *git.Git, updaterService Updater, options *DeployOptions) *Deploy {
 	return &Deploy{
 		options:        options,
@@ -55,7 +101,7 @@ func (d *Deploy) Create() error {
 }
 
 func (d *Deploy) msg() string {
-	return fmt.Sprintf("Deploy: %s:%s to %s by %s", d.options.Image.Repository, d.options.Image.Tag, d.options.Cluster, d.options.Author)
+	return CommitMessage(d.options)
 }
 
 func (d *Deploy) doCreate() error {
@@ -64,7 +110,7 @@ func (d *Deploy) doCreate() error {
 			return d.updaterService.Update(d.gitService.Root(), d.options)
 		},
 		func() error { return d.gitService.AddAll() },
-		func() error { return d.gitService.Commit(d.msg()) },
+		func() error { return d.gitService.CommitAs(d.msg(), d.options.Author) },
 	)
 
 	return err
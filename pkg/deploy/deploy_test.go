@@ -1,13 +1,17 @@
 package deploy
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/valer-cara/mgo/pkg/git"
 )
 
+const testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
 func TestNewDeploy(t *testing.T) {
-	gitService, _ := git.NewGit(git.BACKEND_FAKE, "whatevs")
+	gitService, _ := git.NewGit(git.BACKEND_FAKE, "whatevs", nil)
 	x := NewDeploy(gitService, &FakeUpdater{}, &DeployOptions{
 		Author:      "Ronaldo",
 		TriggerRepo: "git.kernel.org",
@@ -27,3 +31,114 @@ func TestNewDeploy(t *testing.T) {
 func TestDeployUpdatesCorrespondingImages(t *testing.T) {
 
 }
+
+func TestCommitMessageRoundTrip(t *testing.T) {
+	for _, image := range []DeployOptionsImage{
+		{Repository: "registry:5000/a/repo1", Tag: "1.0.0"},
+		{Repository: "registry:5000/a/repo1", Tag: "1.0.0", Digest: testDigest},
+	} {
+		dopts := &DeployOptions{
+			ID:          "abc123",
+			TriggerRepo: "github.com/a/repo1",
+			Author:      "Ronaldo <ronaldo@example.com>",
+			Cluster:     "myprodcluster",
+			Image:       image,
+		}
+
+		parsed, ok := ParseCommitMessage(CommitMessage(dopts))
+		if !ok {
+			t.Fatal("Expected a deploy commit")
+		}
+		if *parsed != *dopts {
+			t.Fatalf("Expected %+v, got %+v", dopts, parsed)
+		}
+	}
+}
+
+func TestRollbackCommitMessageRoundTrip(t *testing.T) {
+	dopts := &DeployOptions{
+		ID:          "abc123",
+		TriggerRepo: "github.com/a/repo1",
+		Author:      "Ronaldo",
+		Cluster:     "myprodcluster",
+		Image:       DeployOptionsImage{Repository: "a/repo1", Tag: "0.9.0"},
+		Rollback:    true,
+		RollbackTo:  "0123456789abcdef0123456789abcdef01234567",
+	}
+
+	message := CommitMessage(dopts)
+	if !strings.HasPrefix(message, "Rollback: a/repo1:0.9.0 to myprodcluster by Ronaldo\n") {
+		t.Fatalf("Unexpected rollback commit message:\n%s", message)
+	}
+
+	parsed, ok := ParseCommitMessage(message)
+	if !ok {
+		t.Fatal("Expected a deploy commit")
+	}
+	if *parsed != *dopts {
+		t.Fatalf("Expected %+v, got %+v", dopts, parsed)
+	}
+}
+
+func TestPromoteCommitMessageRoundTrip(t *testing.T) {
+	dopts := &DeployOptions{
+		ID:          "abc123",
+		TriggerRepo: "github.com/a/repo1",
+		Author:      "Ronaldo",
+		Cluster:     "myprodcluster",
+		Image:       DeployOptionsImage{Repository: "a/repo1", Tag: "1.0.0", Digest: testDigest},
+		PromoteFrom: "mystagingcluster",
+	}
+
+	message := CommitMessage(dopts)
+	if !strings.HasPrefix(message, "Promote: a/repo1:1.0.0@"+testDigest+" to myprodcluster by Ronaldo\n") {
+		t.Fatalf("Unexpected promotion commit message:\n%s", message)
+	}
+
+	parsed, ok := ParseCommitMessage(message)
+	if !ok {
+		t.Fatal("Expected a deploy commit")
+	}
+	if *parsed != *dopts {
+		t.Fatalf("Expected %+v, got %+v", dopts, parsed)
+	}
+}
+
+func TestRevertMessageRoundTrip(t *testing.T) {
+	message := RevertMessage("myprodcluster", []string{"abc123", "def456"}, errors.New("Release app/foobar not ready\n"))
+	if !strings.HasPrefix(message, "Revert: 2 deploys to myprodcluster\n\nSyncing failed with:\nRelease app/foobar not ready\n\n") {
+		t.Fatalf("Unexpected revert commit message:\n%s", message)
+	}
+
+	ids, ok := ParseRevertMessage(message)
+	if !ok || len(ids) != 2 || ids[0] != "abc123" || ids[1] != "def456" {
+		t.Fatalf("Unexpected reverted deploys %v", ids)
+	}
+
+	if _, ok := ParseCommitMessage(message); ok {
+		t.Fatal("A revert commit is not a deploy commit")
+	}
+	if _, ok := ParseRevertMessage(CommitMessage(&DeployOptions{ID: "abc123"})); ok {
+		t.Fatal("A deploy commit is not a revert commit")
+	}
+}
+
+func TestParseLegacyCommitMessage(t *testing.T) {
+	parsed, ok := ParseCommitMessage("Deploy: quay.io/foobar:beta to myprodcluster by Ronaldo\n")
+	if !ok {
+		t.Fatal("Expected a deploy commit")
+	}
+
+	expected := DeployOptions{
+		Author:  "Ronaldo",
+		Cluster: "myprodcluster",
+		Image:   DeployOptionsImage{Repository: "quay.io/foobar", Tag: "beta"},
+	}
+	if *parsed != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, parsed)
+	}
+
+	if _, ok := ParseCommitMessage("Initial commit"); ok {
+		t.Fatal("Expected a non-deploy commit to be skipped")
+	}
+}
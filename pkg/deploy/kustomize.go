@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/valer-cara/mgo/pkg/yamledit"
+)
+
+// Kustomize rejects unknown fields in kustomization files, so `images:` entries
+// are tied to a trigger repo with a comment on the entry instead of a header:
+//
+//	images:
+//	- name: web # __mygitops: github.com/foo/web
+//	  newTag: "1.0.0"
+var kustomizeMarker = regexp.MustCompile(`(?m)^#\s*__mygitops:\s*(\S+)\s*$`)
+
+func updateKustomization(kustomizationPath string, deployOptions *DeployOptions) (bool, error) {
+	file, err := ioutil.ReadFile(kustomizationPath)
+	if err != nil {
+		return false, err
+	}
+
+	patched, didPatch, err := patchKustomizationImages(file, deployOptions)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("File %s: %v", kustomizationPath, err))
+	} else if !didPatch {
+		return false, nil
+	}
+
+	err = ioutil.WriteFile(kustomizationPath, patched, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Bump `newTag` (and `newName`, when the repository differs from `name`, and
+// `digest`) of the `images:` entries marked with the trigger repo
+func patchKustomizationImages(content []byte, deployOptions *DeployOptions) ([]byte, bool, error) {
+	doc, err := yamledit.Parse(content)
+	if err != nil {
+		return nil, false, err
+	}
+
+	images := yamledit.Lookup(doc.Root(), "images")
+	if images == nil || images.Kind != yaml.SequenceNode {
+		return nil, false, nil
+	}
+
+	didPatch := false
+	for _, entry := range images.Content {
+		if entry.Kind != yaml.MappingNode || kustomizeTriggerRepo(entry) != deployOptions.TriggerRepo {
+			continue
+		}
+
+		var (
+			name    = yamledit.Lookup(entry, "name")
+			newName = yamledit.Lookup(entry, "newName")
+			newTag  = yamledit.Lookup(entry, "newTag")
+			digest  = yamledit.Lookup(entry, "digest")
+		)
+
+		if name == nil {
+			return nil, false, errors.New(fmt.Sprintf("line %d: image entry has no `name`", entry.Line))
+		}
+
+		if newName != nil {
+			err = doc.SetScalar(newName, deployOptions.Image.Repository)
+		} else if name.Value != deployOptions.Image.Repository {
+			err = doc.InsertKey(entry, "newName", deployOptions.Image.Repository)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if newTag != nil {
+			err = doc.SetScalar(newTag, deployOptions.Image.Tag)
+		} else {
+			err = doc.InsertKey(entry, "newTag", deployOptions.Image.Tag)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		// Kustomize prefers the digest over the tag: an old one must go
+		if digest != nil {
+			err = doc.SetScalar(digest, deployOptions.Image.Digest)
+		} else if deployOptions.Image.Digest != "" {
+			err = doc.InsertKey(entry, "digest", deployOptions.Image.Digest)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		didPatch = true
+	}
+
+	if !didPatch {
+		return nil, false, nil
+	}
+
+	patched, err := doc.Bytes()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return patched, true, nil
+}
+
+// The trigger repo an image entry is marked with, if any
+func kustomizeTriggerRepo(entry *yaml.Node) string {
+	nodes := append([]*yaml.Node{entry}, entry.Content...)
+
+	for _, node := range nodes {
+		for _, comment := range []string{node.HeadComment, node.LineComment, node.FootComment} {
+			if match := kustomizeMarker.FindStringSubmatch(comment); match != nil {
+				return match[1]
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,193 @@
+package deploy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/valer-cara/mgo/pkg/reference"
+)
+
+// Subject of deploy commits made before trailers were added
+var legacySubject = regexp.MustCompile(`^Deploy: (\S+) to (\S+) by (.+)$`)
+
+// Subject prefixes of deploy commits
+const (
+	SUBJECT_DEPLOY   = "Deploy: "
+	SUBJECT_ROLLBACK = "Rollback: "
+	SUBJECT_PROMOTE  = "Promote: "
+	SUBJECT_REVERT   = "Revert: "
+)
+
+// The deploy commit message: a human readable subject, then the deploy as
+// trailers. Rollbacks have a `Rollback: ` subject and a `Mgo-Rollback-To`
+// trailer, promotions a `Promote: ` subject and a `Mgo-Promote-From` trailer.
+// Eg:
+//
+//	Deploy: a/repo1:1.0.0 to myprodcluster by Ronaldo
+//
+//	Mgo-Deploy-Id: 1f2e...
+//	Mgo-Trigger-Repo: github.com/a/repo1
+//	Mgo-Image: a/repo1:1.0.0
+//	Mgo-Cluster: myprodcluster
+//	Mgo-Author: Ronaldo
+//
+// The image carries its digest when it's pinned, eg: `a/repo1:1.0.0@sha256:...`
+func CommitMessage(d *DeployOptions) string {
+	image := d.Image.String()
+
+	subject := SUBJECT_DEPLOY
+	if d.Rollback {
+		subject = SUBJECT_ROLLBACK
+	} else if d.PromoteFrom != "" {
+		subject = SUBJECT_PROMOTE
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s%s to %s by %s", subject, image, d.Cluster, d.Author),
+		"",
+	}
+	if d.ID != "" {
+		lines = append(lines, d.Trailer())
+	}
+	if d.Rollback {
+		lines = append(lines, fmt.Sprintf("%s: %s", TRAILER_ROLLBACK_TO, d.RollbackTo))
+	} else if d.PromoteFrom != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", TRAILER_PROMOTE_FROM, d.PromoteFrom))
+	}
+	lines = append(lines,
+		fmt.Sprintf("%s: %s", TRAILER_TRIGGER_REPO, d.TriggerRepo),
+		fmt.Sprintf("%s: %s", TRAILER_IMAGE, image),
+		fmt.Sprintf("%s: %s", TRAILER_CLUSTER, d.Cluster),
+		fmt.Sprintf("%s: %s", TRAILER_AUTHOR, d.Author),
+	)
+
+	return strings.Join(lines, "\n")
+}
+
+// Read a deploy back from its commit message. Falls back to the subject for
+// commits without trailers, in which case the trigger repo is unknown.
+// Returns false if it's not a deploy commit
+func ParseCommitMessage(message string) (*DeployOptions, bool) {
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	if len(lines) == 0 || !hasDeploySubject(lines[0]) {
+		return nil, false
+	}
+
+	d := &DeployOptions{}
+	found := false
+
+	for _, line := range lines[1:] {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+
+		switch parts[0] {
+		case TRAILER_DEPLOY_ID:
+			d.ID = value
+		case TRAILER_TRIGGER_REPO:
+			d.TriggerRepo = value
+		case TRAILER_IMAGE:
+			d.Image = ParseImage(value)
+		case TRAILER_CLUSTER:
+			d.Cluster = value
+		case TRAILER_AUTHOR:
+			d.Author = value
+		case TRAILER_ROLLBACK_TO:
+			d.Rollback = true
+			d.RollbackTo = value
+		case TRAILER_PROMOTE_FROM:
+			d.PromoteFrom = value
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if found {
+		return d, true
+	}
+
+	match := legacySubject.FindStringSubmatch(lines[0])
+	if match == nil {
+		return nil, false
+	}
+	d.Image = ParseImage(match[1])
+	d.Cluster = match[2]
+	d.Author = match[3]
+
+	return d, true
+}
+
+// The message of the commit reverting deploys to a cluster whose sync failed,
+// with a `Mgo-Reverts` trailer per deploy. Eg:
+//
+//	Revert: 2 deploys to myprodcluster
+//
+//	Syncing failed with:
+//	Release app/foobar not ready: ...
+//
+//	Mgo-Reverts: 1f2e...
+//	Mgo-Reverts: 3a4b...
+//	Mgo-Cluster: myprodcluster
+func RevertMessage(cluster string, ids []string, reason error) string {
+	noun := "deploys"
+	if len(ids) == 1 {
+		noun = "deploy"
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s%d %s to %s", SUBJECT_REVERT, len(ids), noun, cluster),
+		"",
+		"Syncing failed with:",
+		strings.TrimSpace(reason.Error()),
+		"",
+	}
+	for _, id := range ids {
+		lines = append(lines, fmt.Sprintf("%s: %s", TRAILER_REVERTS, id))
+	}
+	lines = append(lines, fmt.Sprintf("%s: %s", TRAILER_CLUSTER, cluster))
+
+	return strings.Join(lines, "\n")
+}
+
+// The IDs of the deploys a revert commit undid. Returns false if it's not
+// a revert commit
+func ParseRevertMessage(message string) ([]string, bool) {
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], SUBJECT_REVERT) {
+		return nil, false
+	}
+
+	var ids []string
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, TRAILER_REVERTS+": ") {
+			ids = append(ids, strings.TrimSpace(strings.TrimPrefix(line, TRAILER_REVERTS+": ")))
+		}
+	}
+	return ids, true
+}
+
+func hasDeploySubject(subject string) bool {
+	for _, prefix := range []string{SUBJECT_DEPLOY, SUBJECT_ROLLBACK, SUBJECT_PROMOTE} {
+		if strings.HasPrefix(subject, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split `repository:tag[@digest]`, without checking it. The tag is whatever
+// follows the last colon after the last slash, so registry ports are kept in
+// the repository
+func ParseImage(image string) DeployOptionsImage {
+	return DeployOptionsImage(reference.Split(image))
+}
+
+// Split and check an image reference given by a client
+func ParseReference(image string) (DeployOptionsImage, error) {
+	ref, err := reference.Parse(image)
+	return DeployOptionsImage(ref), err
+}
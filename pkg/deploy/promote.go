@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/reference"
+)
+
+// Where the last commit synced successfully to each cluster is recorded, eg:
+// `refs/mgo/synced/staging`. Pushed along with the deploys
+const SYNCED_REFS = "refs/mgo/synced/"
+
+func SyncedRef(cluster string) string {
+	return SYNCED_REFS + cluster
+}
+
+// Promotion pipelines section in `mygitops.yaml`: the clusters images may be
+// promoted between, eg:
+//
+//	promotions:
+//	  - from: staging
+//	    to: prod
+type Promotion struct {
+	From string
+	To   string
+}
+
+func ValidatePromotions(promotions []Promotion) error {
+	for i, promotion := range promotions {
+		if promotion.From == "" || promotion.To == "" {
+			return errors.New(fmt.Sprintf("promotions[%d]: both `from` and `to` are needed", i))
+		}
+		if promotion.From == promotion.To {
+			return errors.New(fmt.Sprintf("promotions[%d]: `%s` promoted to itself", i, promotion.From))
+		}
+	}
+	return nil
+}
+
+func CanPromote(promotions []Promotion, from, to string) bool {
+	for _, promotion := range promotions {
+		if promotion.From == from && promotion.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+// A promotion that's not configured, or whose image isn't fit to go further
+type PromotionError struct {
+	TriggerRepo string
+	From        string
+	To          string
+	Reason      string
+}
+
+func (e *PromotionError) Error() string {
+	return fmt.Sprintf("Promotion of %s from %s to %s refused: %s", e.TriggerRepo, e.From, e.To, e.Reason)
+}
+
+// Turn a promotion request into a deploy of the image the trigger repo has in
+// the values files of the `PromoteFrom` cluster. That image must be the one
+// these values files had when the cluster last synced successfully, so nothing
+// goes further than where it has run
+func ResolvePromotion(gitService *git.Git, layout manifest.Layout, dopts *DeployOptions) error {
+	refuse := func(format string, args ...interface{}) error {
+		return &PromotionError{
+			TriggerRepo: dopts.TriggerRepo,
+			From:        dopts.PromoteFrom,
+			To:          dopts.Cluster,
+			Reason:      fmt.Sprintf(format, args...),
+		}
+	}
+
+	root := gitService.Root()
+	valueFiles, err := layout.ValueFiles(root, dopts.PromoteFrom)
+	if err != nil {
+		return err
+	}
+
+	if err := gitService.FetchRefs(SYNCED_REFS + "*"); err != nil {
+		return err
+	}
+	synced, err := gitService.RevParse(SyncedRef(dopts.PromoteFrom))
+	if err != nil {
+		return err
+	} else if synced == "" {
+		return refuse("%s never synced", dopts.PromoteFrom)
+	}
+
+	var current *DeployOptionsImage
+	for _, valueFile := range valueFiles {
+		content, err := ioutil.ReadFile(valueFile)
+		if err != nil {
+			return err
+		}
+
+		image, err := deployedImage(content, dopts.TriggerRepo)
+		if err != nil {
+			return errors.New(fmt.Sprintf("File %s: %v", valueFile, err))
+		} else if image == nil {
+			continue
+		}
+
+		if current != nil && *image != *current {
+			return refuse("different images in the values files of %s: %s, %s", dopts.PromoteFrom, current, image)
+		}
+		current = image
+
+		file, err := filepath.Rel(root, valueFile)
+		if err != nil {
+			return err
+		}
+
+		// Missing files never synced either
+		syncedContent, _ := gitService.Show(synced, filepath.ToSlash(file))
+		syncedImage, err := deployedImage(syncedContent, dopts.TriggerRepo)
+		if err != nil || syncedImage == nil || *syncedImage != *image {
+			return refuse("%s never synced successfully on %s (%s)", image, dopts.PromoteFrom, file)
+		}
+	}
+
+	if current == nil {
+		return refuse("no image in the values files of %s", dopts.PromoteFrom)
+	}
+
+	dopts.Image = *current
+	return nil
+}
+
+// The image of the trigger repo in a values file's content, nil if it has none
+func deployedImage(content []byte, triggerRepo string) (*DeployOptionsImage, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	header, err := headerImage(content, triggerRepo)
+	if err != nil || header == nil {
+		return nil, err
+	}
+
+	repository, tag := header.Current()
+	if repository == "" || tag == "" {
+		return nil, nil
+	}
+
+	digest := header.Digest
+	if digest == "" && header.Image != "" {
+		digest = reference.Split(header.Image).Digest
+	}
+
+	return &DeployOptionsImage{Repository: repository, Tag: tag, Digest: digest}, nil
+}
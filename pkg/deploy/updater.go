@@ -3,20 +3,18 @@ package deploy
 import (
 	"errors"
 	"fmt"
-	yaml "gopkg.in/yaml.v2"
 	"io/ioutil"
-	//log "github.com/sirupsen/logrus"
-	"path"
 	"path/filepath"
-	"regexp"
-	"strings"
+
+	yaml "gopkg.in/yaml.v3"
 
 	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/yamledit"
 )
 
 // Updater groups operations tied to a specific gitops repo structure.
-// This is where the opinionated stuff is restrained.
-// If you'd like to structure your project differently, hack here!
+// This is where the opinionated stuff is restrained. Where files live is up to
+// the manifest.Layout in use, see the `layout` section in `mygitops.yaml`
 type Updater interface {
 	Update(string, *DeployOptions) error
 }
@@ -29,25 +27,41 @@ func (u *FakeUpdater) Update(gitopsRepo string, deployOptions *DeployOptions) er
 }
 
 // The updater used with mygitops' conventions
-type MyUpdater struct{}
+type MyUpdater struct {
+	// Where to look for value files. Defaults to manifest.DefaultLayout
+	Layout manifest.Layout
+}
 
 func (u *MyUpdater) Update(gitopsRepo string, deployOptions *DeployOptions) error {
-	globPath := path.Join(gitopsRepo, "/installations/", deployOptions.Cluster, "/*-values.yaml")
+	layout := u.Layout
+	if layout == nil {
+		layout = &manifest.DefaultLayout{}
+	}
+
+	valueFiles, err := layout.ValueFiles(gitopsRepo, deployOptions.Cluster)
+	if err != nil {
+		return err
+	}
 
-	valueFiles, err := filepath.Glob(globPath)
+	kustomizeDirs, err := layout.KustomizeDirs(gitopsRepo, deployOptions.Cluster)
 	if err != nil {
 		return err
 	}
 
-	if len(valueFiles) == 0 {
+	if len(valueFiles) == 0 && len(kustomizeDirs) == 0 {
 		return errors.New(fmt.Sprintf(
-			"No files found for for cluster '%s' in gitops repo '%s'. Searched for '%s'. Aborting...",
+			"No files found for for cluster '%s' in gitops repo '%s'. Searched for '%v'. Aborting...",
 			deployOptions.Cluster,
 			gitopsRepo,
-			globPath,
+			layout,
 		))
 	}
 
+	// Before editing anything, so a refused deploy leaves no file behind
+	if err := checkPolicies(gitopsRepo, valueFiles, deployOptions); err != nil {
+		return err
+	}
+
 	didPatchAnything := false
 	for _, valueFile := range valueFiles {
 		didPatch, err := updateFile(valueFile, deployOptions)
@@ -59,6 +73,16 @@ func (u *MyUpdater) Update(gitopsRepo string, deployOptions *DeployOptions) erro
 		}
 	}
 
+	for _, dir := range kustomizeDirs {
+		didPatch, err := updateKustomization(manifest.KustomizationFile(dir), deployOptions)
+		if err != nil {
+			return err
+		}
+		if didPatch {
+			didPatchAnything = true
+		}
+	}
+
 	if !didPatchAnything {
 		// TODO: nicer errors/hints
 		return errors.New(fmt.Sprintf(
@@ -70,147 +94,212 @@ func (u *MyUpdater) Update(gitopsRepo string, deployOptions *DeployOptions) erro
 	return nil
 }
 
+// A deploy refused by the policy of the image in a values file, see
+// manifest.HeaderImage.CheckTag()
+type PolicyError struct {
+	TriggerRepo string
+	// Relative to the gitops repo
+	File   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("Deploy of %s refused by the policy in %s: %s", e.TriggerRepo, e.File, e.Reason)
+}
+
+// Wrap an error of Deploy.Create(). Policy refusals are left as they are, so
+// they reach the client as such
+func WrapCreateError(err error) error {
+	if _, ok := err.(*PolicyError); ok || err == nil {
+		return err
+	}
+	return errors.New(fmt.Sprintf("Cannot create deployment: %v", err))
+}
+
+// Check the deploy against the policies of the trigger repo's image in the
+// values files
+func checkPolicies(gitopsRepo string, valueFiles []string, deployOptions *DeployOptions) error {
+	for _, valueFile := range valueFiles {
+		content, err := ioutil.ReadFile(valueFile)
+		if err != nil {
+			return err
+		}
+
+		image, err := headerImage(content, deployOptions.TriggerRepo)
+		if err != nil {
+			return errors.New(fmt.Sprintf("File %s: %v", valueFile, err))
+		} else if image == nil {
+			continue
+		}
+
+		if err := image.CheckTag(deployOptions.Image.Tag, deployOptions.Rollback); err != nil {
+			file, errRel := filepath.Rel(gitopsRepo, valueFile)
+			if errRel != nil {
+				file = valueFile
+			}
+			return &PolicyError{
+				TriggerRepo: deployOptions.TriggerRepo,
+				File:        file,
+				Reason:      err.Error(),
+			}
+		}
+	}
+
+	return nil
+}
+
+// The trigger repo's entry in `__mygitops.images`, nil if there's none
+func headerImage(content []byte, triggerRepo string) (*manifest.HeaderImage, error) {
+	doc, err := yamledit.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := yamledit.Lookup(doc.Root(), "__mygitops", "images", triggerRepo)
+	if entry == nil || entry.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	var image manifest.HeaderImage
+	if err := entry.Decode(&image); err != nil {
+		return nil, errors.New(fmt.Sprintf("`__mygitops.images.%s`: %v", triggerRepo, err))
+	}
+	return &image, nil
+}
+
 // Update the values file
 //
-// Until this pull request is merged (yaml.v3) we're hacking yaml edits so we don't affect comments
-// https://github.com/go-yaml/yaml/pull/219
-// https://github.com/go-yaml/yaml/issues/132
-//
-// The hacks means matching the line interval for the whole `__mygitops` block and
-// only editing that piece, writing the rest unchanged
+// Only the `__mygitops.images.<triggerRepo>` scalars are edited, in place. The
+// rest of the file is written back byte for byte.
 //
 // Indicates whether it actually patched or not via the bool return
 // Returns: didPatch bool, error
 func updateFile(valueFilePath string, deployOptions *DeployOptions) (bool, error) {
-	var (
-		offt         []int
-		chartSection *manifest.HelmBasic
-	)
 	file, err := ioutil.ReadFile(valueFilePath)
 	if err != nil {
 		return false, err
 	}
 
-	offt, chartSection, err = getChartSection(file)
+	patched, didPatch, err := patchImages(file, deployOptions)
 	if err != nil {
 		return false, errors.New(fmt.Sprintf("File %s: %v", valueFilePath, err))
-	} else if chartSection == nil {
+	} else if !didPatch {
 		return false, nil
 	}
 
-	if len(chartSection.Chart.Images) == 0 {
-		//return errors.New(fmt.Sprintf("File <%s> does not define __mygitops.images.*", valueFilePath))
-		return false, nil
+	err = ioutil.WriteFile(valueFilePath, patched, 0644)
+	if err != nil {
+		return false, err
 	}
 
-	var proceedPatching = false
-
-	for crtTargetRepo, _ := range chartSection.Chart.Images {
-		if strings.Compare(deployOptions.TriggerRepo, crtTargetRepo) == 0 {
-			proceedPatching = true
-			//log.Printf("Will update: %s -> %s:%s", crtTargetRepo, conf.Image, conf.Tag)
-
-			if chartSection.Chart.Images[crtTargetRepo].Image != "" {
-				/// In the manifest, we only had `image: "foo:bar"`, will use the same for the update
-				chartSection.Chart.Images[crtTargetRepo] = manifest.HeaderImage{
-					Image: deployOptions.Image.Repository + ":" + deployOptions.Image.Tag,
-				}
-			} else {
-				// In the manifest, we had a repo/tag combo, we'll keep the same
-				chartSection.Chart.Images[crtTargetRepo] = manifest.HeaderImage{
-					Repository: deployOptions.Image.Repository,
-					Tag:        deployOptions.Image.Tag,
-				}
-			}
+	return true, nil
+}
 
-			//log.Printf("Updated: %s -> %s:%s", crtTargetRepo, chartSection.Chart.Images[crtTargetRepo].Image, chartSection.Chart.Images[crtTargetRepo].Tag)
-		}
+// Patch the image entry of the trigger repo in a values file's content.
+//
+// An entry holding a `tag` gets its `tag` updated, along with `repository` (or
+// `image`, when that's used as the repository). An entry holding only an
+// `image` gets the full `repository:tag[@digest]` in there. Entries with none
+// of these get a repository/tag pair added. The digest goes in `digest`, unless
+// it's part of `image`; an existing `digest` is emptied for deploys without one.
+func patchImages(content []byte, deployOptions *DeployOptions) ([]byte, bool, error) {
+	doc, err := yamledit.Parse(content)
+	if err != nil {
+		return nil, false, err
 	}
 
-	if !proceedPatching {
-		return false, nil
+	images := yamledit.Lookup(doc.Root(), "__mygitops", "images")
+	if images == nil || images.Kind != yaml.MappingNode {
+		return nil, false, nil
 	}
 
-	return true, patchChartSection(valueFilePath, file, chartSection, offt)
-}
+	entry := yamledit.Lookup(images, deployOptions.TriggerRepo)
+	if entry == nil {
+		return nil, false, nil
+	}
+	if entry.Kind != yaml.MappingNode {
+		return nil, false, errors.New(fmt.Sprintf("`__mygitops.images.%s` should be a map", deployOptions.TriggerRepo))
+	}
+
+	// Editing through an alias would edit the anchored node, which belongs to
+	// another entry or value
+	if isAlias(images, deployOptions.TriggerRepo) {
+		return nil, false, errors.New(fmt.Sprintf("`__mygitops.images.%s` is an alias, give it its own map", deployOptions.TriggerRepo))
+	}
+	for _, key := range []string{"repository", "tag", "image", "digest"} {
+		if isAlias(entry, key) {
+			return nil, false, errors.New(fmt.Sprintf("`__mygitops.images.%s.%s` is an alias, give it its own value", deployOptions.TriggerRepo, key))
+		}
+	}
 
-// Super hacky: until we get yaml.v3 which should be better with a unmarshal->marshal loop // returns offsets, parsed chart, error
-func getChartSection(file []byte) ([]int, *manifest.HelmBasic, error) {
 	var (
-		start, end    int
-		parsedSection manifest.HelmBasic
+		repository = yamledit.Lookup(entry, "repository")
+		tag        = yamledit.Lookup(entry, "tag")
+		image      = yamledit.Lookup(entry, "image")
+		digest     = yamledit.Lookup(entry, "digest")
 	)
 
-	// Find section start
-	re := regexp.MustCompile(`(?m)^__mygitops:`)
-	idxStart := re.FindAllIndex(file, -1)
+	switch {
+	case tag != nil:
+		if err := doc.SetScalar(tag, deployOptions.Image.Tag); err != nil {
+			return nil, false, err
+		}
 
-	if len(idxStart) == 0 {
-		return nil, nil, nil
-	} else if len(idxStart) > 1 {
-		return nil, nil, errors.New(fmt.Sprintf("File contains multiple __mygitops keys"))
-	}
+		if repository == nil && image != nil {
+			repository = image
+		}
+		if repository != nil {
+			err = doc.SetScalar(repository, deployOptions.Image.Repository)
+		} else {
+			err = doc.InsertKey(entry, "repository", deployOptions.Image.Repository)
+		}
 
-	start = idxStart[0][0]
+	case image != nil:
+		if digest != nil {
+			err = doc.SetScalar(image, deployOptions.Image.Repository+":"+deployOptions.Image.Tag)
+		} else {
+			err = doc.SetScalar(image, deployOptions.Image.String())
+		}
 
-	// Find section end
-	re = regexp.MustCompile(`\n\r?\S`) // newline followed by non space immediately
-	idxEnd := re.FindIndex(file[start:])
+	case repository != nil:
+		if err = doc.SetScalar(repository, deployOptions.Image.Repository); err == nil {
+			err = doc.InsertKey(entry, "tag", deployOptions.Image.Tag)
+		}
 
-	if idxEnd == nil {
-		end = len(file) - 1
-	} else {
-		end = start + idxEnd[0] + 1
+	default:
+		if err = doc.InsertKey(entry, "repository", deployOptions.Image.Repository); err == nil {
+			err = doc.InsertKey(entry, "tag", deployOptions.Image.Tag)
+		}
 	}
 
-	err := yaml.Unmarshal(file[start:end], &parsedSection)
 	if err != nil {
-		return nil, nil, err
+		return nil, false, err
 	}
 
-	return []int{start, end}, &parsedSection, nil
-}
-
-func patchChartSection(path string, originalContent []byte, chartSection *manifest.HelmBasic, patchOffests []int) error {
-	var (
-		snipStart = patchOffests[0]
-		snipEnd   = patchOffests[1]
-	)
-
-	newChartSection, err := yaml.Marshal(*chartSection)
-	if err != nil {
-		return err
+	// A digest left from an earlier deploy would pin the old image
+	if digest != nil {
+		err = doc.SetScalar(digest, deployOptions.Image.Digest)
+	} else if deployOptions.Image.Digest != "" && (tag != nil || image == nil) {
+		err = doc.InsertKey(entry, "digest", deployOptions.Image.Digest)
 	}
-
-	newChartSection, err = restoreAnchors(originalContent[snipStart:snipEnd], newChartSection)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	out := []byte{}
-	out = append(out, originalContent[0:patchOffests[0]]...)
-	out = append(out, newChartSection...)
-	out = append(out, originalContent[patchOffests[1]:]...)
-
-	err = ioutil.WriteFile(path, out, 0644)
+	patched, err := doc.Bytes()
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	return nil
+	return patched, true, nil
 }
 
-func restoreAnchors(oldSection, newSection []byte) ([]byte, error) {
-	//re := regexp.MustCompile(`(?m)^\s+(\S): \&([\S])`)
-	reOrig := regexp.MustCompile(`(?m)^\s+([\S]+):\s*(&[\S]+)`)
-	res := reOrig.FindAllSubmatch(oldSection, -1)
-
-	for _, match := range res {
-		searchFor := `(?m)^\s+` + string(match[1]) + `:`
-		replaceWith := string(match[0])
-		reNew := regexp.MustCompile(searchFor)
-		newSection = reNew.ReplaceAll(newSection, []byte(replaceWith))
+// Whether the value of `key` in a mapping is an alias (`*foo`)
+func isAlias(mapping *yaml.Node, key string) bool {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return mapping.Content[idx+1].Kind == yaml.AliasNode
+		}
 	}
-
-	return newSection, nil
+	return false
 }
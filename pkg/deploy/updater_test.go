@@ -1,11 +1,22 @@
 package deploy
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/valer-cara/mgo/pkg/manifest"
 	"github.com/valer-cara/mgo/pkg/testutils"
 )
 
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata/")
+
 func TestOnlyChangeChartSection(t *testing.T) {
 	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
 	t.Log("Testing repo", repo)
@@ -25,3 +36,347 @@ func TestOnlyChangeChartSection(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// Every sample file gets the same deploys applied; the result is compared to
+// testdata/<file>.golden. Run `go test -update` to regenerate the golden files
+func TestUpdateGoldenFiles(t *testing.T) {
+	deploys := []*DeployOptions{
+		{
+			TriggerRepo: "github.com/a/repo1",
+			Image:       DeployOptionsImage{Repository: "quay.io/foobar", Tag: "beta"},
+		},
+		{
+			TriggerRepo: "github.com/foo/bar",
+			Image:       DeployOptionsImage{Repository: "registry:5000/foo/bar", Tag: "1.10"},
+		},
+	}
+
+	files, err := filepath.Glob("../../tests/minimal-gitops-repo/installations/myprodcluster/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, dopts := range deploys {
+			patched, didPatch, err := patchImages(content, dopts)
+			if err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+			if didPatch {
+				content = patched
+			}
+		}
+
+		goldenPath := path.Join("testdata", filepath.Base(file)+".golden")
+		if *updateGolden {
+			if err := ioutil.WriteFile(goldenPath, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		golden, err := ioutil.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(content, golden) {
+			t.Errorf("%s: output differs from %s. Got:\n%s", file, goldenPath, string(content))
+		}
+	}
+}
+
+func TestUpdateAlias(t *testing.T) {
+	tests := []struct {
+		Content string
+		Error   string
+	}{
+		{`__mygitops:
+  images:
+    github.com/a/repo1: &shared
+      repository: a/repo1
+      tag: "old"
+    github.com/a/repo2: *shared
+`, "`__mygitops.images.github.com/a/repo2` is an alias"},
+		{`__mygitops:
+  images:
+    github.com/a/repo1:
+      repository: a/repo1
+      tag: &tag "old"
+    github.com/a/repo2:
+      repository: a/repo2
+      tag: *tag
+`, "`__mygitops.images.github.com/a/repo2.tag` is an alias"},
+	}
+
+	for testIdx, test := range tests {
+		_, didPatch, err := patchImages([]byte(test.Content), &DeployOptions{
+			TriggerRepo: "github.com/a/repo2",
+			Image:       DeployOptionsImage{Repository: "a/repo2", Tag: "new"},
+		})
+		if err == nil || !strings.Contains(err.Error(), test.Error) {
+			t.Fatalf("[test %d] Expected '%s', got %v", testIdx, test.Error, err)
+		}
+		if didPatch {
+			t.Fatalf("[test %d] Expected repo1's entry to be left unchanged", testIdx)
+		}
+	}
+
+	// Deploying the anchored entry itself is fine, the aliases follow it
+	patched, _, err := patchImages([]byte(tests[0].Content), &DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Image:       DeployOptionsImage{Repository: "a/repo1", Tag: "new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(patched), "github.com/a/repo1: &shared\n      repository: a/repo1\n      tag: \"new\"\n") {
+		t.Fatalf("Unexpected output:\n%s", string(patched))
+	}
+}
+
+func TestUpdateNoMatch(t *testing.T) {
+	_, didPatch, err := patchImages([]byte("__mygitops:\n  images: {}\nfoo: bar\n"), &DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if didPatch {
+		t.Fatal("Expected no patching when the trigger repo isn't referenced")
+	}
+}
+
+func TestUpdateCustomLayout(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/custom-layout-gitops-repo")
+
+	layout, err := manifest.NewLayout(&manifest.LayoutConfig{
+		Type:   manifest.LAYOUT_CUSTOM,
+		Values: "envs/*/{{.Cluster}}/*/values.yaml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updater := MyUpdater{Layout: layout}
+	err = updater.Update(repo, &DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Image:       DeployOptionsImage{Repository: "a/repo1", Tag: "1.1.0"},
+		Cluster:     "myprodcluster",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prod, _ := ioutil.ReadFile(path.Join(repo, "envs/prod/myprodcluster/app/values.yaml"))
+	if !bytes.Contains(prod, []byte(`tag: "1.1.0"`)) {
+		t.Fatalf("Expected prod values to be updated, got:\n%s", string(prod))
+	}
+
+	staging, _ := ioutil.ReadFile(path.Join(repo, "envs/staging/mystagingcluster/app/values.yaml"))
+	if !bytes.Contains(staging, []byte(`tag: "0.9.0"`)) {
+		t.Fatalf("Expected staging values to be left alone, got:\n%s", string(staging))
+	}
+}
+
+func TestUpdateKustomization(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	updater := MyUpdater{}
+	err := updater.Update(repo, &DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Image:       DeployOptionsImage{Repository: "quay.io/a/repo1", Tag: "1.2.0"},
+		Cluster:     "myprodcluster",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(path.Join(repo, "installations/myprodcluster/web/kustomization.yaml"))
+	expected := `images:
+# Entries tagged with ` + "`__mygitops: <trigger repo>`" + ` are bumped on deploys
+- name: web # __mygitops: github.com/a/repo1
+  newName: quay.io/a/repo1
+  newTag: "1.2.0"
+- name: sidecar
+  newTag: "2.0"
+`
+	if !bytes.HasSuffix(content, []byte(expected)) {
+		t.Fatalf("Unexpected kustomization:\n%s", string(content))
+	}
+}
+
+func TestUpdateKustomizationInsertsKeys(t *testing.T) {
+	content := []byte(`images:
+- name: web # __mygitops: github.com/a/repo1
+- name: other
+`)
+	expected := []byte(`images:
+- name: web # __mygitops: github.com/a/repo1
+  newName: a/repo1
+  newTag: "1.0"
+- name: other
+`)
+
+	patched, didPatch, err := patchKustomizationImages(content, &DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Image:       DeployOptionsImage{Repository: "a/repo1", Tag: "1.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !didPatch {
+		t.Fatal("Expected the marked entry to be patched")
+	}
+	if !bytes.Equal(patched, expected) {
+		t.Fatalf("Unexpected output:\n%s", string(patched))
+	}
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	repo, err := ioutil.TempDir("", "_mygitops-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	files := map[string]string{
+		"web-values.yaml": `__mygitops:
+  images:
+    github.com/a/repo1:
+      repository: a/repo1
+      tag: 1.2.0
+`,
+		"worker-values.yaml": `__mygitops:
+  images:
+    github.com/a/repo1:
+      repository: a/repo1
+      tag: 1.2.0
+      semver: ^1
+      allowTags: ['^\d+\.\d+\.\d+$']
+      denyDowngrade: true
+`,
+	}
+
+	dir := path.Join(repo, "installations", "prod")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		Tag      string
+		Rollback bool
+		Refused  bool
+	}{
+		{"2.0.0", false, true},
+		{"latest", false, true},
+		{"1.3.0-rc.1", false, true},
+		{"1.1.0", false, true},
+		{"1.1.0", true, false},
+		{"1.3.0", false, false},
+	}
+
+	updater := MyUpdater{}
+	for testIdx, test := range tests {
+		err := updater.Update(repo, &DeployOptions{
+			TriggerRepo: "github.com/a/repo1",
+			Image:       DeployOptionsImage{Repository: "a/repo1", Tag: test.Tag},
+			Cluster:     "prod",
+			Rollback:    test.Rollback,
+		})
+
+		if !test.Refused {
+			if err != nil {
+				t.Fatalf("[test %d] %v", testIdx, err)
+			}
+			continue
+		}
+
+		policyErr, ok := err.(*PolicyError)
+		if !ok {
+			t.Fatalf("[test %d] Expected a PolicyError, got %v", testIdx, err)
+		}
+		if policyErr.File != "installations/prod/worker-values.yaml" {
+			t.Fatalf("[test %d] Unexpected file %s", testIdx, policyErr.File)
+		}
+		if WrapCreateError(err) != err {
+			t.Fatalf("[test %d] Expected the PolicyError to be kept as is", testIdx)
+		}
+
+		// Nothing edited, not even the files without a policy
+		web, _ := ioutil.ReadFile(path.Join(dir, "web-values.yaml"))
+		if string(web) != files["web-values.yaml"] {
+			t.Fatalf("[test %d] Expected no file to be edited, got:\n%s", testIdx, string(web))
+		}
+	}
+}
+
+func TestWrapCreateError(t *testing.T) {
+	if err := WrapCreateError(errors.New("boom")); err == nil || err.Error() != "Cannot create deployment: boom" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := WrapCreateError(nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestUpdateDigest(t *testing.T) {
+	content := []byte(`__mygitops:
+  images:
+    github.com/a/repo1:
+      repository: a/repo1
+      tag: "old"
+    github.com/a/repo2:
+      image: registry:5000/a/repo2:old
+    github.com/a/repo3:
+      repository: a/repo3
+      tag: old
+      digest: sha256:0000000000000000000000000000000000000000000000000000000000000000
+`)
+
+	tests := []struct {
+		TriggerRepo string
+		Image       DeployOptionsImage
+		Expected    string
+	}{
+		{"github.com/a/repo1", DeployOptionsImage{Repository: "a/repo1", Tag: "new", Digest: testDigest}, "      digest: " + testDigest + "\n"},
+		{"github.com/a/repo2", DeployOptionsImage{Repository: "registry:5000/a/repo2", Tag: "new", Digest: testDigest}, "      image: registry:5000/a/repo2:new@" + testDigest + "\n"},
+		{"github.com/a/repo3", DeployOptionsImage{Repository: "a/repo3", Tag: "new", Digest: testDigest}, "      tag: new\n      digest: " + testDigest + "\n"},
+		// The old digest doesn't stick around
+		{"github.com/a/repo3", DeployOptionsImage{Repository: "a/repo3", Tag: "new"}, "      tag: new\n      digest: \"\"\n"},
+	}
+
+	for testIdx, test := range tests {
+		patched, _, err := patchImages(content, &DeployOptions{TriggerRepo: test.TriggerRepo, Image: test.Image})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(patched, []byte(test.Expected)) {
+			t.Fatalf("[test %d] Expected `%s` in:\n%s", testIdx, test.Expected, string(patched))
+		}
+	}
+
+	kustomization := []byte(`images:
+  - name: a/repo1 # __mygitops: github.com/a/repo1
+    newTag: old
+`)
+	patched, _, err := patchKustomizationImages(kustomization, &DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Image:       DeployOptionsImage{Repository: "a/repo1", Tag: "new", Digest: testDigest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(patched, []byte("digest: "+testDigest)) {
+		t.Fatalf("Expected the digest in the kustomization:\n%s", string(patched))
+	}
+}
@@ -0,0 +1,280 @@
+package drift
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/jobs"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
+)
+
+// Namespace assumed for raw manifests that don't set one
+const defaultRawNamespace = "default"
+
+// Drift compares the state described in the gitops repo with what is actually
+// running in a cluster. Helm releases are rendered locally with `helm template`
+// and raw manifests are taken as they are; the resulting objects are then
+// compared with the live ones.
+type Drift struct {
+	gitopsRepoRoot string
+	cluster        string
+	layout         manifest.Layout
+
+	helmService    helm.HelmService
+	kubectlService kubectl.KubectlService
+	decrypter      secrets.Decrypter
+}
+
+type driftJob struct {
+	idx       int
+	file      string
+	kustomize bool
+	release   *helm.HelmRelease
+	valFiles  []string
+}
+
+func NewDrift(gitopsRepoRoot string, cluster string, layout manifest.Layout, helmService helm.HelmService, kubectlService kubectl.KubectlService, decrypter secrets.Decrypter) *Drift {
+	return &Drift{
+		gitopsRepoRoot: gitopsRepoRoot,
+		cluster:        cluster,
+		layout:         layout,
+		helmService:    helmService,
+		kubectlService: kubectlService,
+		decrypter:      decrypter,
+	}
+}
+
+func (d *Drift) Detect() (*Report, error) {
+	var driftJobs []interface{}
+
+	manifests, err := manifest.FindManifests(d.layout, d.gitopsRepoRoot, d.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Cluster:  d.cluster,
+		Releases: make([]ReleaseReport, len(manifests.Helm)+len(manifests.Raw)+len(manifests.Kustomize)),
+	}
+
+	for idx, path := range manifests.Helm {
+		header, err := manifest.ParseHeader(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := header.Validate(); err != nil {
+			return nil, errors.New("Manifest " + path + ":" + err.Error())
+		}
+
+		files, err := header.ResolveValueFiles(d.gitopsRepoRoot, path)
+		if err != nil {
+			return nil, errors.New("Manifest " + path + ": " + err.Error())
+		}
+
+		driftJobs = append(driftJobs, driftJob{
+			idx:      idx,
+			file:     path,
+			release:  &header.HelmRelease,
+			valFiles: files,
+		})
+	}
+
+	for idx, path := range manifests.Raw {
+		driftJobs = append(driftJobs, driftJob{
+			idx:  len(manifests.Helm) + idx,
+			file: path,
+		})
+	}
+
+	for idx, dir := range manifests.Kustomize {
+		driftJobs = append(driftJobs, driftJob{
+			idx:       len(manifests.Helm) + len(manifests.Raw) + idx,
+			file:      dir,
+			kustomize: true,
+		})
+	}
+
+	// Each job only writes its own slot in the report
+	jobs.Parallel(func(job interface{}) error {
+		j := job.(driftJob)
+
+		if j.release != nil {
+			report.Releases[j.idx] = d.detectRelease(j.release, j.valFiles)
+		} else if j.kustomize {
+			report.Releases[j.idx] = d.detectKustomization(j.file)
+		} else {
+			report.Releases[j.idx] = d.detectRaw(j.file)
+		}
+		report.Releases[j.idx].File = d.relativePath(j.file)
+
+		return nil
+	}, driftJobs, &jobs.ParallelOpts{MaxParallel: 15})
+
+	return report, nil
+}
+
+func (d *Drift) detectRelease(release *helm.HelmRelease, valueFiles []string) ReleaseReport {
+	result := ReleaseReport{Name: release.Name}
+
+	var rendered []byte
+	err := secrets.WithDecrypted(d.decrypter, valueFiles, func(files []string) (err error) {
+		rendered, err = d.helmService.TemplateRelease(release, files)
+		return err
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	desired, err := parseObjects(rendered, release.Namespace)
+	if err != nil {
+		result.Error = fmt.Sprintf("Cannot parse rendered manifests: %v", err)
+		return result
+	}
+
+	if err := d.compare(&result, desired); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := d.findAdded(&result, desired, "release="+release.Name); err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Raw manifests carry no release label, so there's no way of telling which
+// live objects belong to them: only changed/missing objects are reported.
+// Same goes for kustomizations
+func (d *Drift) detectRaw(path string) ReleaseReport {
+	result := ReleaseReport{Name: filepath.Base(path)}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	desired, err := parseObjects(content, defaultRawNamespace)
+	if err != nil {
+		result.Error = fmt.Sprintf("Cannot parse manifest: %v", err)
+		return result
+	}
+
+	if err := d.compare(&result, desired); err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Same as raw manifests, once the kustomization is built
+func (d *Drift) detectKustomization(dir string) ReleaseReport {
+	result := ReleaseReport{Name: filepath.Base(dir)}
+
+	built, err := d.kubectlService.Kustomize(dir)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	desired, err := parseObjects(built, defaultRawNamespace)
+	if err != nil {
+		result.Error = fmt.Sprintf("Cannot parse built kustomization: %v", err)
+		return result
+	}
+
+	if err := d.compare(&result, desired); err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func (d *Drift) compare(result *ReleaseReport, desired []*object) error {
+	for _, obj := range desired {
+		liveYaml, err := d.kubectlService.Get(obj.ref.Kind, obj.ref.Namespace, obj.ref.Name)
+		if err == kubectl.ErrNotFound {
+			result.Missing = append(result.Missing, obj.ref)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		var live map[interface{}]interface{}
+		if err := yaml.Unmarshal(liveYaml, &live); err != nil {
+			return errors.New(fmt.Sprintf("Cannot parse live object %s: %v", obj.ref, err))
+		}
+
+		if fields := compareObjects(obj.data, live); len(fields) > 0 {
+			result.Changed = append(result.Changed, ObjectChange{
+				ObjectRef: obj.ref,
+				Fields:    fields,
+			})
+		}
+	}
+
+	return nil
+}
+
+// Look for live objects labeled as part of the release that are no longer
+// rendered from the gitops repo. Only the kinds the release renders are searched
+func (d *Drift) findAdded(result *ReleaseReport, desired []*object, selector string) error {
+	var kinds []string
+
+	known := make(map[ObjectRef]bool)
+	seenKinds := make(map[string]bool)
+
+	for _, obj := range desired {
+		known[obj.ref] = true
+		if !seenKinds[obj.ref.Kind] {
+			seenKinds[obj.ref.Kind] = true
+			kinds = append(kinds, obj.ref.Kind)
+		}
+	}
+
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	sort.Strings(kinds)
+
+	liveYaml, err := d.kubectlService.List(kinds, "", selector)
+	if err != nil {
+		return err
+	}
+
+	live, err := parseObjects(liveYaml, "")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot parse live objects: %v", err))
+	}
+
+	for _, obj := range live {
+		if !known[obj.ref] {
+			result.Added = append(result.Added, obj.ref)
+		}
+	}
+
+	sort.Slice(result.Added, func(i, j int) bool {
+		return result.Added[i].String() < result.Added[j].String()
+	})
+
+	return nil
+}
+
+func (d *Drift) relativePath(path string) string {
+	if rel, err := filepath.Rel(d.gitopsRepoRoot, path); err == nil {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,194 @@
+package drift
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
+	"github.com/valer-cara/mgo/pkg/testutils"
+)
+
+func TestDetect(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	helmService := &helm.HelmFake{
+		Manifests: map[string]string{
+			"redis-one": `
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: redis-one
+  labels:
+    release: redis-one
+spec:
+  replicas: 1
+`,
+			"foobar": `
+apiVersion: v1
+kind: Service
+metadata:
+  name: foobar
+  labels:
+    release: foobar
+`,
+		},
+	}
+
+	kubectlService := &kubectl.KubectlFake{
+		Kustomizations: map[string]string{
+			repo + "/installations/myprodcluster/web": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web
+`,
+		},
+		Objects: map[string]string{
+			"Deployment/default/redis-one": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: redis-one
+  namespace: default
+  labels:
+    release: redis-one
+spec:
+  replicas: 3
+status:
+  readyReplicas: 3
+`,
+			"ConfigMap/app/myapp": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myapp
+  namespace: app
+  labels:
+    app: myapp
+data:
+  foo: "123"
+  bar: "this is sparta!"
+`,
+			"Service/app/foobar-leftover": `
+apiVersion: v1
+kind: Service
+metadata:
+  name: foobar-leftover
+  namespace: app
+  labels:
+    release: foobar
+`,
+		},
+	}
+
+	report, err := NewDrift(repo, "myprodcluster", &manifest.DefaultLayout{}, helmService, kubectlService, &secrets.DecrypterFake{}).Detect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.HasDrift() {
+		t.Fatal("Expected drift to be detected")
+	}
+	if report.HasErrors() {
+		t.Fatalf("Expected no errors, got %+v", report.Releases)
+	}
+
+	byName := map[string]ReleaseReport{}
+	for _, release := range report.Releases {
+		byName[release.Name] = release
+	}
+
+	expected := map[string]ReleaseReport{
+		"redis-one": {
+			Name: "redis-one",
+			File: "installations/myprodcluster/no-handling-values.yaml",
+			Changed: []ObjectChange{
+				{ObjectRef{"Deployment", "default", "redis-one"}, []string{"spec.replicas"}},
+			},
+		},
+		"foobar": {
+			Name:    "foobar",
+			File:    "installations/myprodcluster/some-values.yaml",
+			Added:   []ObjectRef{{"Service", "app", "foobar-leftover"}},
+			Missing: []ObjectRef{{"Service", "app", "foobar"}},
+		},
+		"one-configmap-raw.yaml": {
+			Name: "one-configmap-raw.yaml",
+			File: "installations/myprodcluster/one-configmap-raw.yaml",
+		},
+		"web": {
+			Name:    "web",
+			File:    "installations/myprodcluster/web",
+			Missing: []ObjectRef{{"Deployment", "web", "web"}},
+		},
+	}
+
+	if len(byName) != len(expected) {
+		t.Fatalf("Expected reports for %d releases, got %+v", len(expected), report.Releases)
+	}
+	for name, exp := range expected {
+		if !reflect.DeepEqual(byName[name], exp) {
+			t.Errorf("Release %s: expected %+v, got %+v", name, exp, byName[name])
+		}
+	}
+}
+
+func TestDetectRenderError(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	helmService := &helm.HelmFake{FailOnTemplateRelease: "no such chart"}
+	kubectlService := &kubectl.KubectlFake{}
+
+	report, err := NewDrift(repo, "myprodcluster", &manifest.DefaultLayout{}, helmService, kubectlService, &secrets.DecrypterFake{}).Detect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.HasErrors() {
+		t.Fatal("Expected render errors to be reported")
+	}
+}
+
+func TestCompareObjectsIgnoresLiveOnlyFields(t *testing.T) {
+	desired, _ := parseObjects([]byte(`
+kind: Deployment
+metadata:
+  name: x
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: foo:1
+      volumes: []
+`), "ns")
+
+	live, _ := parseObjects([]byte(`
+kind: Deployment
+metadata:
+  name: x
+  namespace: ns
+  uid: 1234
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: foo:2
+        imagePullPolicy: Always
+`), "")
+
+	if desired[0].ref != live[0].ref {
+		t.Fatalf("Expected matching refs, got %v and %v", desired[0].ref, live[0].ref)
+	}
+
+	diffs := compareObjects(desired[0].data, live[0].data)
+	if !reflect.DeepEqual(diffs, []string{"spec.template.spec.containers[0].image"}) {
+		t.Fatalf("Unexpected diffs: %v", diffs)
+	}
+}
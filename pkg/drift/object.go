@@ -0,0 +1,179 @@
+package drift
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Kinds that don't live in a namespace. Their refs always have an empty namespace
+var clusterScopedKinds = map[string]bool{
+	"apiservice":                     true,
+	"clusterrole":                    true,
+	"clusterrolebinding":             true,
+	"customresourcedefinition":       true,
+	"mutatingwebhookconfiguration":   true,
+	"namespace":                      true,
+	"node":                           true,
+	"persistentvolume":               true,
+	"podsecuritypolicy":              true,
+	"priorityclass":                  true,
+	"storageclass":                   true,
+	"validatingwebhookconfiguration": true,
+}
+
+// Identifies a kubernetes object
+type ObjectRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (o ObjectRef) String() string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s/%s", o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+type object struct {
+	ref  ObjectRef
+	data map[interface{}]interface{}
+}
+
+// Parse a multi document yaml stream of kubernetes objects. `List` objects are
+// flattened. Objects with no namespace set get `defaultNamespace`
+func parseObjects(manifests []byte, defaultNamespace string) ([]*object, error) {
+	var objects []*object
+
+	decoder := yaml.NewDecoder(bytes.NewReader(manifests))
+	for {
+		var doc map[interface{}]interface{}
+
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(doc) == 0 {
+			continue
+		}
+
+		if getString(doc, "kind") == "List" {
+			items, _ := doc["items"].([]interface{})
+			for _, item := range items {
+				if itemMap, ok := item.(map[interface{}]interface{}); ok {
+					objects = append(objects, newObject(itemMap, defaultNamespace))
+				}
+			}
+			continue
+		}
+
+		objects = append(objects, newObject(doc, defaultNamespace))
+	}
+
+	return objects, nil
+}
+
+func newObject(data map[interface{}]interface{}, defaultNamespace string) *object {
+	ref := ObjectRef{
+		Kind:      getString(data, "kind"),
+		Namespace: getString(data, "metadata", "namespace"),
+		Name:      getString(data, "metadata", "name"),
+	}
+
+	if clusterScopedKinds[strings.ToLower(ref.Kind)] {
+		ref.Namespace = ""
+	} else if ref.Namespace == "" {
+		ref.Namespace = defaultNamespace
+	}
+
+	return &object{ref: ref, data: data}
+}
+
+func getString(data map[interface{}]interface{}, keys ...string) string {
+	var current interface{} = data
+
+	for _, key := range keys {
+		m, ok := current.(map[interface{}]interface{})
+		if !ok {
+			return ""
+		}
+		current = m[key]
+	}
+
+	if current == nil {
+		return ""
+	}
+	return fmt.Sprint(current)
+}
+
+// Compare a desired object with its live counterpart. Only the fields set in
+// the desired object are compared, since the live one is full of defaults and
+// status fields. Returns the paths of the fields that differ
+func compareObjects(desired, live interface{}) []string {
+	diffs := compareValues(desired, live, "")
+	sort.Strings(diffs)
+	return diffs
+}
+
+func compareValues(desired, live interface{}, path string) []string {
+	switch d := desired.(type) {
+	case map[interface{}]interface{}:
+		l, ok := live.(map[interface{}]interface{})
+		if !ok {
+			if len(d) == 0 && live == nil {
+				return nil
+			}
+			return []string{path}
+		}
+
+		var diffs []string
+		for key, value := range d {
+			diffs = append(diffs, compareValues(value, l[key], joinPath(path, fmt.Sprint(key)))...)
+		}
+		return diffs
+
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			if len(d) == 0 && live == nil {
+				return nil
+			}
+			return []string{path}
+		}
+		if len(d) != len(l) {
+			return []string{path}
+		}
+
+		var diffs []string
+		for idx := range d {
+			diffs = append(diffs, compareValues(d[idx], l[idx], fmt.Sprintf("%s[%d]", path, idx))...)
+		}
+		return diffs
+
+	case nil:
+		return nil
+
+	default:
+		// Scalars are compared by their string representation: a configmap
+		// value written as `123` comes back from the cluster as "123"
+		if live == nil || fmt.Sprint(desired) != fmt.Sprint(live) {
+			return []string{path}
+		}
+		return nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,83 @@
+package drift
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Drift report for a whole cluster
+type Report struct {
+	Cluster  string          `json:"cluster"`
+	Releases []ReleaseReport `json:"releases"`
+}
+
+// Drift report for a helm release or a raw manifest file
+//
+// - Added: objects found in the cluster that are not in the gitops repo
+// - Changed: objects whose live state differs from the gitops repo
+// - Missing: objects in the gitops repo that don't exist in the cluster
+type ReleaseReport struct {
+	Name    string         `json:"name"`
+	File    string         `json:"file"`
+	Added   []ObjectRef    `json:"added,omitempty"`
+	Changed []ObjectChange `json:"changed,omitempty"`
+	Missing []ObjectRef    `json:"missing,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type ObjectChange struct {
+	ObjectRef
+	Fields []string `json:"fields"`
+}
+
+func (r *ReleaseReport) HasDrift() bool {
+	return len(r.Added) > 0 || len(r.Changed) > 0 || len(r.Missing) > 0
+}
+
+func (r *Report) HasDrift() bool {
+	for _, release := range r.Releases {
+		if release.HasDrift() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) HasErrors() bool {
+	for _, release := range r.Releases {
+		if release.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Human readable report
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "Drift report for cluster %s\n", r.Cluster)
+	fmt.Fprintf(w, "(+ only in cluster, - missing from cluster, ~ changed)\n\n")
+
+	for _, release := range r.Releases {
+		fmt.Fprintf(w, "%s (%s)\n", release.Name, release.File)
+
+		if release.Error != "" {
+			fmt.Fprintf(w, "  ! error: %s\n", release.Error)
+			continue
+		}
+		if !release.HasDrift() {
+			fmt.Fprintf(w, "  no drift\n")
+			continue
+		}
+
+		for _, ref := range release.Added {
+			fmt.Fprintf(w, "  + %s\n", ref)
+		}
+		for _, change := range release.Changed {
+			fmt.Fprintf(w, "  ~ %s: %s\n", change.ObjectRef, strings.Join(change.Fields, ", "))
+		}
+		for _, ref := range release.Missing {
+			fmt.Fprintf(w, "  - %s\n", ref)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Forge: opens pull requests on the forge hosting the gitops repo (GitHub,
+ * GitLab or Gitea) and tells when they're merged. Deploys to the clusters
+ * listed in `pullRequests` go through one, so they're reviewed before they
+ * land and get synced.
+ */
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	FORGE_GITHUB = "github"
+	FORGE_GITLAB = "gitlab"
+	FORGE_GITEA  = "gitea"
+)
+
+// States of a pull request
+const (
+	STATE_OPEN   = "open"
+	STATE_MERGED = "merged"
+	// Closed without merging
+	STATE_CLOSED = "closed"
+)
+
+const defaultPollInterval = time.Minute
+
+// Largest response read
+const maxResponse = 1 << 20
+
+type PullRequest struct {
+	// The number shown by the forge: `#12` or `!12` on GitLab
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+type Forge interface {
+	// Ask for `branch` to be merged into `base`
+	Open(branch, base, title, body string) (*PullRequest, error)
+	// STATE_OPEN, STATE_MERGED or STATE_CLOSED
+	State(number int) (string, error)
+}
+
+// Pull requests section in `mygitops.yaml`, eg:
+//
+//	pullRequests:
+//	  clusters: [prod]
+//	  forge: github
+//	  repository: foo/gitops
+//	  token: ${MGO_FORGE_TOKEN}
+type Config struct {
+	// Clusters whose deploys go through a pull request. None means they're
+	// all pushed straight to the branch
+	Clusters []string
+	// `github`, `gitlab` or `gitea`
+	Forge string
+	// The API, eg: `https://gitea.example.com`. Defaults to the public
+	// GitHub and GitLab ones
+	URL string
+	// The gitops repo on the forge, eg: `foo/gitops`
+	Repository string
+	// `${VAR}` is replaced by the environment variable
+	Token string
+	// How often open pull requests are checked for a merge, eg: `30s`. 1
+	// minute by default
+	PollInterval string `yaml:"pollInterval"`
+}
+
+func (c *Config) Enabled() bool {
+	return len(c.Clusters) > 0
+}
+
+// Whether deploys to the cluster go through a pull request
+func (c *Config) Reviewed(cluster string) bool {
+	for _, reviewed := range c.Clusters {
+		if reviewed == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if _, err := NewForge(c); err != nil {
+		return err
+	}
+	if c.Repository == "" {
+		return errors.New("pullRequests: missing `repository`")
+	}
+	_, err := c.Interval()
+	return err
+}
+
+func (c *Config) Interval() (time.Duration, error) {
+	if c.PollInterval == "" {
+		return defaultPollInterval, nil
+	}
+
+	interval, err := time.ParseDuration(c.PollInterval)
+	if err != nil || interval <= 0 {
+		return 0, errors.New(fmt.Sprintf("pullRequests: bad pollInterval `%s`", c.PollInterval))
+	}
+	return interval, nil
+}
+
+func NewForge(c *Config) (Forge, error) {
+	token := os.ExpandEnv(c.Token)
+
+	switch c.Forge {
+	case FORGE_GITHUB:
+		return NewGithub(c.URL, c.Repository, token), nil
+	case FORGE_GITLAB:
+		return NewGitlab(c.URL, c.Repository, token), nil
+	case FORGE_GITEA:
+		if c.URL == "" {
+			return nil, errors.New("pullRequests: missing `url` of the gitea instance")
+		}
+		return NewGitea(c.URL, c.Repository, token), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("pullRequests: unknown forge `%s`. Should be `%s`, `%s` or `%s`", c.Forge, FORGE_GITHUB, FORGE_GITLAB, FORGE_GITEA))
+	}
+}
+
+// JSON over HTTP, with the auth header of the forge
+type client struct {
+	httpClient *http.Client
+	authHeader string
+	authValue  string
+}
+
+func newClient(authHeader, authValue string) *client {
+	return &client{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		authHeader: authHeader,
+		authValue:  authValue,
+	}
+}
+
+func (c *client) do(method, url string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = encoded
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.authValue != "" {
+		req.Header.Set(c.authHeader, c.authValue)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxResponse))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(respBody)))
+	}
+
+	return json.Unmarshal(respBody, out)
+}
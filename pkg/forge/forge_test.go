@@ -0,0 +1,79 @@
+package forge
+
+import (
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/testutils"
+)
+
+func TestForges(t *testing.T) {
+	for _, flavor := range []string{FORGE_GITHUB, FORGE_GITLAB, FORGE_GITEA} {
+		server := testutils.NewTestForge(t)
+
+		f, err := NewForge(&Config{Forge: flavor, URL: server.URL, Repository: server.Repository, Token: server.Token})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := f.Open("mgo/deploy/abc", "master", "Deploy: a/repo1:1.0 to prod by Ronaldo", "Mgo-Deploy-Id: abc")
+		if err != nil {
+			t.Fatalf("%s: %v", flavor, err)
+		}
+		if pr.Number != 1 || pr.URL == "" {
+			t.Fatalf("%s: unexpected pull request %+v", flavor, pr)
+		}
+
+		opened := server.PullRequests()[0]
+		if opened.Branch != "mgo/deploy/abc" || opened.Base != "master" || opened.Body != "Mgo-Deploy-Id: abc" {
+			t.Fatalf("%s: unexpected pull request opened %+v", flavor, opened)
+		}
+
+		for _, step := range []struct {
+			Change func(int)
+			State  string
+		}{
+			{func(int) {}, STATE_OPEN},
+			{server.Merge, STATE_MERGED},
+			{server.Close, STATE_CLOSED},
+		} {
+			step.Change(pr.Number)
+			if state, err := f.State(pr.Number); err != nil || state != step.State {
+				t.Fatalf("%s: expected state %s, got %s (%v)", flavor, step.State, state, err)
+			}
+		}
+
+		if _, err := f.State(42); err == nil {
+			t.Fatalf("%s: expected an error for a missing pull request", flavor)
+		}
+
+		f, _ = NewForge(&Config{Forge: flavor, URL: server.URL, Repository: server.Repository, Token: "nope"})
+		if _, err := f.Open("mgo/deploy/abc", "master", "title", ""); err == nil {
+			t.Fatalf("%s: expected an error with a bad token", flavor)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := []Config{
+		{},
+		{Clusters: []string{"prod"}, Forge: FORGE_GITHUB, Repository: "foo/gitops"},
+		{Clusters: []string{"prod"}, Forge: FORGE_GITEA, URL: "https://gitea.example.com", Repository: "foo/gitops", PollInterval: "30s"},
+	}
+	for _, config := range valid {
+		if err := config.Validate(); err != nil {
+			t.Fatalf("Expected %+v to be valid: %v", config, err)
+		}
+	}
+
+	invalid := []Config{
+		{Clusters: []string{"prod"}, Forge: "bitbucket", Repository: "foo/gitops"},
+		{Clusters: []string{"prod"}, Forge: FORGE_GITHUB},
+		{Clusters: []string{"prod"}, Forge: FORGE_GITEA, Repository: "foo/gitops"},
+		{Clusters: []string{"prod"}, Forge: FORGE_GITLAB, Repository: "foo/gitops", PollInterval: "often"},
+	}
+	for _, config := range invalid {
+		if err := config.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", config)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const GITHUB_API = "https://api.github.com"
+
+// GitHub and Gitea share the pull requests API, under a different prefix
+type Github struct {
+	pulls  string
+	client *client
+}
+
+func NewGithub(url, repository, token string) *Github {
+	if url == "" {
+		url = GITHUB_API
+	}
+
+	return &Github{
+		pulls:  fmt.Sprintf("%s/repos/%s/pulls", strings.TrimSuffix(url, "/"), repository),
+		client: newClient("Authorization", "Bearer "+token),
+	}
+}
+
+func NewGitea(url, repository, token string) *Github {
+	return &Github{
+		pulls:  fmt.Sprintf("%s/api/v1/repos/%s/pulls", strings.TrimSuffix(url, "/"), repository),
+		client: newClient("Authorization", "token "+token),
+	}
+}
+
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Merged  bool   `json:"merged"`
+}
+
+func (g *Github) Open(branch, base, title, body string) (*PullRequest, error) {
+	var pr githubPullRequest
+	err := g.client.do("POST", g.pulls, map[string]string{
+		"head":  branch,
+		"base":  base,
+		"title": title,
+		"body":  body,
+	}, &pr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot open pull request of %s: %v", branch, err))
+	}
+
+	return &PullRequest{Number: pr.Number, URL: pr.HTMLURL}, nil
+}
+
+func (g *Github) State(number int) (string, error) {
+	var pr githubPullRequest
+	if err := g.client.do("GET", fmt.Sprintf("%s/%d", g.pulls, number), nil, &pr); err != nil {
+		return "", errors.New(fmt.Sprintf("Cannot get pull request #%d: %v", number, err))
+	}
+
+	switch {
+	case pr.Merged:
+		return STATE_MERGED, nil
+	case pr.State == "closed":
+		return STATE_CLOSED, nil
+	default:
+		return STATE_OPEN, nil
+	}
+}
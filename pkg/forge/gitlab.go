@@ -0,0 +1,65 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const GITLAB_API = "https://gitlab.com"
+
+// Merge requests, GitLab's pull requests
+type Gitlab struct {
+	mergeRequests string
+	client        *client
+}
+
+func NewGitlab(apiURL, repository, token string) *Gitlab {
+	if apiURL == "" {
+		apiURL = GITLAB_API
+	}
+
+	return &Gitlab{
+		mergeRequests: fmt.Sprintf("%s/api/v4/projects/%s/merge_requests", strings.TrimSuffix(apiURL, "/"), url.PathEscape(repository)),
+		client:        newClient("PRIVATE-TOKEN", token),
+	}
+}
+
+type gitlabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+	// `opened`, `merged`, `closed` or `locked`
+	State string `json:"state"`
+}
+
+func (g *Gitlab) Open(branch, base, title, body string) (*PullRequest, error) {
+	var mr gitlabMergeRequest
+	err := g.client.do("POST", g.mergeRequests, map[string]string{
+		"source_branch": branch,
+		"target_branch": base,
+		"title":         title,
+		"description":   body,
+	}, &mr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot open merge request of %s: %v", branch, err))
+	}
+
+	return &PullRequest{Number: mr.IID, URL: mr.WebURL}, nil
+}
+
+func (g *Gitlab) State(number int) (string, error) {
+	var mr gitlabMergeRequest
+	if err := g.client.do("GET", fmt.Sprintf("%s/%d", g.mergeRequests, number), nil, &mr); err != nil {
+		return "", errors.New(fmt.Sprintf("Cannot get merge request !%d: %v", number, err))
+	}
+
+	switch mr.State {
+	case "merged":
+		return STATE_MERGED, nil
+	case "closed":
+		return STATE_CLOSED, nil
+	default:
+		return STATE_OPEN, nil
+	}
+}
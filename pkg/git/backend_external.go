@@ -7,8 +7,11 @@ import (
 	//log "github.com/sirupsen/logrus"
 	"os/exec"
 	"path"
+	"strings"
+	"time"
 )
 
+// The default committer
 const (
 	GIT_EMAIL = "mygitops@foo.bar"
 	GIT_NAME  = "MyGitops robot"
@@ -17,11 +20,25 @@ const (
 type GitBackendExternal struct {
 	repoPath string
 	branch   string
+	remote   string
+
+	name, email string
+	signing     SigningConfig
 }
 
-func NewGitBackendExternal() *GitBackendExternal {
+// A nil config means the defaults
+func NewGitBackendExternal(config *Config) *GitBackendExternal {
+	if config == nil {
+		config = &Config{}
+	}
+	c := config.withDefaults()
+
 	return &GitBackendExternal{
-		branch: "master",
+		branch:  c.Branch,
+		remote:  c.Remote,
+		name:    c.Name,
+		email:   c.Email,
+		signing: c.Signing,
 	}
 }
 
@@ -48,10 +65,12 @@ func (g *GitBackendExternal) Root() string {
 	return g.repoPath
 }
 
+// Like `reset --hard` to the remote branch, checking out the branch first
+// in case the repo was cloned with another one
 func (g *GitBackendExternal) Reset() error {
 	var out bytes.Buffer
 
-	cmd := g.craftGitCommand("reset", "--hard", "origin/"+g.branch)
+	cmd := g.craftGitCommand("checkout", "-f", "-B", g.branch, g.RemoteRef())
 	cmd.Stderr = &out
 
 	err := cmd.Run()
@@ -64,7 +83,7 @@ func (g *GitBackendExternal) Reset() error {
 func (g *GitBackendExternal) Fetch() error {
 	var out bytes.Buffer
 
-	cmd := g.craftGitCommand("fetch")
+	cmd := g.craftGitCommand("fetch", g.remote)
 	cmd.Stderr = &out
 
 	err := cmd.Run()
@@ -77,7 +96,7 @@ func (g *GitBackendExternal) Fetch() error {
 func (g *GitBackendExternal) Pull(extraArgs ...string) error {
 	var out bytes.Buffer
 
-	args := append([]string{"pull", "origin", g.branch}, extraArgs...)
+	args := append([]string{"pull", g.remote, g.branch}, extraArgs...)
 
 	cmd := g.craftGitCommand(args...)
 	cmd.Stderr = &out
@@ -92,7 +111,7 @@ func (g *GitBackendExternal) Pull(extraArgs ...string) error {
 func (g *GitBackendExternal) Push() error {
 	var out bytes.Buffer
 
-	cmd := g.craftGitCommand("push", "origin", g.branch)
+	cmd := g.craftGitCommand("push", g.remote, g.branch)
 	cmd.Stderr = &out
 
 	err := cmd.Run()
@@ -115,10 +134,15 @@ func (g *GitBackendExternal) AddAll() error {
 	return nil
 }
 
-func (g *GitBackendExternal) Commit(msg string) error {
+func (g *GitBackendExternal) Commit(msg, author string) error {
 	var out bytes.Buffer
 
-	cmd := g.craftGitCommand("commit", "--allow-empty", "-m", msg)
+	args := []string{"commit", "--allow-empty", "-m", msg}
+	if author != "" {
+		args = append(args, "--author="+Ident(author))
+	}
+
+	cmd := g.craftGitCommand(args...)
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 
@@ -129,11 +153,223 @@ func (g *GitBackendExternal) Commit(msg string) error {
 	return nil
 }
 
+func (g *GitBackendExternal) Head() (string, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := g.craftGitCommand("rev-parse", "HEAD")
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.New("Git.Head(): " + stderr.String())
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (g *GitBackendExternal) FindCommit(ref, text string) (string, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := g.craftGitCommand("log", ref, "--fixed-strings", "--grep="+text, "--format=%H", "-n", "1")
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.New("Git.FindCommit(): " + stderr.String())
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (g *GitBackendExternal) Log(ref string, texts ...string) ([]Commit, error) {
+	var out, stderr bytes.Buffer
+
+	// Fields separated by NUL, commits by RS
+	args := []string{"log", ref, "--fixed-strings", "--format=%H%x00%cI%x00%B%x1e"}
+	for _, text := range texts {
+		args = append(args, "--grep="+text)
+	}
+
+	cmd := g.craftGitCommand(args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.New("Git.Log(): " + stderr.String())
+	}
+
+	commits := []Commit{}
+	for _, record := range strings.Split(out.String(), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x00", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, errors.New("Git.Log(): bad commit date " + fields[1])
+		}
+
+		commits = append(commits, Commit{
+			Sha:     fields[0],
+			Date:    date,
+			Message: fields[2],
+		})
+	}
+
+	return commits, nil
+}
+
+func (g *GitBackendExternal) UpdateRef(ref, sha string) error {
+	var out bytes.Buffer
+
+	cmd := g.craftGitCommand("update-ref", ref, sha)
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Git.UpdateRef(): " + out.String())
+	}
+	return nil
+}
+
+func (g *GitBackendExternal) RevParse(ref string) (string, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := g.craftGitCommand("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		return "", nil
+	} else if err != nil {
+		return "", errors.New("Git.RevParse(): " + stderr.String())
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (g *GitBackendExternal) PushRefs(pattern string) error {
+	var out bytes.Buffer
+
+	cmd := g.craftGitCommand("push", g.remote, "+"+pattern+":"+pattern)
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Git.PushRefs(): " + out.String())
+	}
+	return nil
+}
+
+func (g *GitBackendExternal) FetchRefs(pattern string) error {
+	var out bytes.Buffer
+
+	cmd := g.craftGitCommand("fetch", g.remote, "+"+pattern+":"+pattern)
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Git.FetchRefs(): " + out.String())
+	}
+	return nil
+}
+
+func (g *GitBackendExternal) Show(ref, path string) ([]byte, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := g.craftGitCommand("show", ref+":"+path)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.New("Git.Show(): " + stderr.String())
+	}
+	return out.Bytes(), nil
+}
+
+func (g *GitBackendExternal) Branch() string {
+	return g.branch
+}
+
+func (g *GitBackendExternal) Checkout(branch, startPoint string) error {
+	var out bytes.Buffer
+
+	args := []string{"checkout", "-f", branch}
+	if startPoint != "" {
+		args = []string{"checkout", "-f", "-B", branch, startPoint}
+	}
+
+	cmd := g.craftGitCommand(args...)
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Git.Checkout(): " + out.String())
+	}
+	return nil
+}
+
+func (g *GitBackendExternal) PushBranch(branch string) error {
+	var out bytes.Buffer
+
+	cmd := g.craftGitCommand("push", "-f", g.remote, branch)
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Git.PushBranch(): " + out.String())
+	}
+	return nil
+}
+
+// Aborted on conflicts, leaving the work tree as it was
+func (g *GitBackendExternal) Revert(shas ...string) error {
+	var out bytes.Buffer
+
+	cmd := g.craftGitCommand(append([]string{"revert", "--no-commit"}, shas...)...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if err != nil {
+		if errAbort := g.craftGitCommand("revert", "--abort").Run(); errAbort != nil {
+			log.Warnln("Git.Revert(): cannot abort:", errAbort)
+		}
+		return errors.New("Git.Revert(): " + out.String())
+	}
+	return nil
+}
+
+func (g *GitBackendExternal) RemoteRef() string {
+	return g.remote + "/" + g.branch
+}
+
 func (g *GitBackendExternal) craftGitCommand(extraArgs ...string) *exec.Cmd {
-	args := append([]string{
-		"-c", "user.name='" + GIT_NAME + "'",
-		"-c", "user.email='" + GIT_EMAIL + "'",
-	}, extraArgs...)
+	args := []string{
+		"-c", "user.name=" + g.name,
+		"-c", "user.email=" + g.email,
+	}
+
+	switch g.signing.Format {
+	case SIGNING_GPG:
+		args = append(args, "-c", "commit.gpgsign=true", "-c", "gpg.format=openpgp")
+		if g.signing.Program != "" {
+			args = append(args, "-c", "gpg.program="+g.signing.Program)
+		}
+	case SIGNING_SSH:
+		args = append(args, "-c", "commit.gpgsign=true", "-c", "gpg.format=ssh")
+		if g.signing.Program != "" {
+			args = append(args, "-c", "gpg.ssh.program="+g.signing.Program)
+		}
+	}
+	if g.signing.Key != "" {
+		args = append(args, "-c", "user.signingkey="+g.signing.Key)
+	}
+
+	args = append(args, extraArgs...)
 
 	cmd := exec.Command("git", args...)
 	log.Debugln("  - running: git", args)
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 )
@@ -44,7 +45,57 @@ func (g *FakeGitBackend) AddAll() error {
 	log.Println("FakeGit: AddAll")
 	return nil
 }
-func (g *FakeGitBackend) Commit(msg string) error {
-	log.Printf("FakeGit: Commit with message \"%s\"\n", msg)
+func (g *FakeGitBackend) Commit(msg, author string) error {
+	log.Printf("FakeGit: Commit by %s with message \"%s\"\n", author, msg)
+	return nil
+}
+func (g *FakeGitBackend) Head() (string, error) {
+	log.Println("FakeGit: Head")
+	return "0000000000000000000000000000000000000000", nil
+}
+func (g *FakeGitBackend) FindCommit(ref, text string) (string, error) {
+	log.Println("FakeGit: FindCommit", ref, text)
+	return "", nil
+}
+func (g *FakeGitBackend) RemoteRef() string {
+	return "origin/master"
+}
+func (g *FakeGitBackend) Log(ref string, texts ...string) ([]Commit, error) {
+	log.Println("FakeGit: Log", ref, texts)
+	return []Commit{}, nil
+}
+func (g *FakeGitBackend) UpdateRef(ref, sha string) error {
+	log.Println("FakeGit: UpdateRef", ref, sha)
+	return nil
+}
+func (g *FakeGitBackend) RevParse(ref string) (string, error) {
+	log.Println("FakeGit: RevParse", ref)
+	return "", nil
+}
+func (g *FakeGitBackend) PushRefs(pattern string) error {
+	log.Println("FakeGit: PushRefs", pattern)
+	return nil
+}
+func (g *FakeGitBackend) FetchRefs(pattern string) error {
+	log.Println("FakeGit: FetchRefs", pattern)
+	return nil
+}
+func (g *FakeGitBackend) Show(ref, path string) ([]byte, error) {
+	log.Println("FakeGit: Show", ref, path)
+	return nil, errors.New("FakeGit: nothing to show")
+}
+func (g *FakeGitBackend) Branch() string {
+	return "master"
+}
+func (g *FakeGitBackend) Checkout(branch, startPoint string) error {
+	log.Println("FakeGit: Checkout", branch, startPoint)
+	return nil
+}
+func (g *FakeGitBackend) PushBranch(branch string) error {
+	log.Println("FakeGit: PushBranch", branch)
+	return nil
+}
+func (g *FakeGitBackend) Revert(shas ...string) error {
+	log.Println("FakeGit: Revert", shas)
 	return nil
 }
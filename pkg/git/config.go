@@ -0,0 +1,104 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	DEFAULT_BRANCH = "master"
+	DEFAULT_REMOTE = "origin"
+)
+
+const (
+	SIGNING_GPG = "gpg"
+	SIGNING_SSH = "ssh"
+)
+
+// Git section in `mygitops.yaml`, eg:
+//
+//	git:
+//	  branch: main
+//	  remote: upstream
+//	  name: mgo
+//	  email: mgo@example.com
+//	  signing:
+//	    format: ssh
+//	    key: /etc/mgo/id_ed25519
+type Config struct {
+	// Where deploys are committed and pushed, `master` and `origin` by default
+	Branch string
+	Remote string
+
+	// Committer of the deploy commits, GIT_NAME and GIT_EMAIL by default. The
+	// deploy's author is recorded as the commit author
+	Name  string
+	Email string
+
+	Signing SigningConfig
+}
+
+type SigningConfig struct {
+	// `gpg` or `ssh`. Empty means commits aren't signed
+	Format string
+	// GPG key ID, or the SSH key file. Empty means git's default GPG key
+	Key string
+	// Optional, eg: `gpg2`
+	Program string
+}
+
+func (c *Config) Validate() error {
+	switch c.Signing.Format {
+	case "", SIGNING_GPG:
+	case SIGNING_SSH:
+		if c.Signing.Key == "" {
+			return errors.New("git: SSH signing needs a `key`")
+		}
+	default:
+		return errors.New(fmt.Sprintf("git: unknown signing format `%s`. Should be `%s` or `%s`", c.Signing.Format, SIGNING_GPG, SIGNING_SSH))
+	}
+
+	if strings.ContainsAny(c.Name+c.Email, "<>\n") {
+		return errors.New("git: bad committer name or email")
+	}
+	return nil
+}
+
+func (c *Config) withDefaults() Config {
+	d := *c
+	if d.Branch == "" {
+		d.Branch = DEFAULT_BRANCH
+	}
+	if d.Remote == "" {
+		d.Remote = DEFAULT_REMOTE
+	}
+	if d.Name == "" {
+		d.Name = GIT_NAME
+	}
+	if d.Email == "" {
+		d.Email = GIT_EMAIL
+	}
+	return d
+}
+
+var identPattern = regexp.MustCompile(`^([^<>]*[^<>\s])\s*<([^<>]*)>$`)
+
+// A deploy author as a git identity, `Name <email>`, eg:
+// `Ronaldo <ronaldo@example.com>` is kept as is
+// `ronaldo@example.com` -> `ronaldo <ronaldo@example.com>`
+// `Ronaldo` -> `Ronaldo <>`
+func Ident(author string) string {
+	author = strings.TrimSpace(strings.NewReplacer("\n", " ", "\r", " ").Replace(author))
+
+	if match := identPattern.FindStringSubmatch(author); match != nil {
+		return fmt.Sprintf("%s <%s>", match[1], match[2])
+	}
+
+	author = strings.NewReplacer("<", "", ">", "").Replace(author)
+	if at := strings.Index(author, "@"); at > 0 && !strings.ContainsAny(author, " \t") {
+		return fmt.Sprintf("%s <%s>", author[:at], author)
+	}
+	return fmt.Sprintf("%s <>", author)
+}
@@ -2,6 +2,8 @@ package git
 
 import (
 	"errors"
+	"fmt"
+	"time"
 	//log "github.com/sirupsen/logrus"
 )
 
@@ -9,6 +11,12 @@ type Git struct {
 	backend GitBackend
 }
 
+type Commit struct {
+	Sha     string
+	Date    time.Time
+	Message string
+}
+
 type GitBackend interface {
 	Init(string) error
 	Pull(extraArgs ...string) error
@@ -16,7 +24,40 @@ type GitBackend interface {
 	Fetch() error
 	Push() error
 	AddAll() error
-	Commit(string) error
+	// Commit as `author` (see Ident()), or as the committer if empty
+	Commit(msg, author string) error
+
+	// Sha of the checked out commit
+	Head() (string, error)
+	// Sha of the most recent commit reachable from `ref` whose message
+	// contains `text`. Empty if there's none
+	FindCommit(ref, text string) (string, error)
+	// The remote tracking ref of the branch, eg: origin/master
+	RemoteRef() string
+	// Commits reachable from `ref` whose message contains any of `texts`,
+	// newest first
+	Log(ref string, texts ...string) ([]Commit, error)
+
+	// Point `ref` (eg: `refs/mgo/...`) at the commit `sha`
+	UpdateRef(ref, sha string) error
+	// Sha `ref` points to. Empty if there's no such ref
+	RevParse(ref string) (string, error)
+	// Push or fetch refs outside of the branch, eg: `refs/mgo/*`. Both
+	// overwrite what's on the other side
+	PushRefs(pattern string) error
+	FetchRefs(pattern string) error
+	// Content of `path` (relative to the repo root) at `ref`
+	Show(ref, path string) ([]byte, error)
+
+	// The branch deploys land on, eg: master
+	Branch() string
+	// Switch to `branch`, dropping local changes. With a `startPoint`, the
+	// branch is (re)created there
+	Checkout(branch, startPoint string) error
+	// Push another branch than Branch(), overwriting it on the remote
+	PushBranch(branch string) error
+	// Undo the changes of `shas`, in the order given, without committing
+	Revert(shas ...string) error
 
 	Root() string
 }
@@ -26,12 +67,13 @@ const (
 	BACKEND_FAKE     = 999
 )
 
-func NewGit(backend int, path string) (*Git, error) {
+// A nil config means the defaults, see Config
+func NewGit(backend int, path string, config *Config) (*Git, error) {
 	var bk GitBackend
 
 	switch backend {
 	case BACKEND_EXTERNAL:
-		bk = NewGitBackendExternal()
+		bk = NewGitBackendExternal(config)
 
 		err := bk.Init(path)
 		if err != nil {
@@ -46,7 +88,7 @@ func NewGit(backend int, path string) (*Git, error) {
 		}
 
 	default:
-		return nil, errors.New("NewGit(): no such backend " + string(backend))
+		return nil, errors.New(fmt.Sprintf("NewGit(): no such backend %d", backend))
 	}
 
 	return &Git{
@@ -73,5 +115,47 @@ func (g *Git) AddAll() error {
 	return g.backend.AddAll()
 }
 func (g *Git) Commit(msg string) error {
-	return g.backend.Commit(msg)
+	return g.backend.Commit(msg, "")
+}
+func (g *Git) CommitAs(msg, author string) error {
+	return g.backend.Commit(msg, author)
+}
+func (g *Git) Head() (string, error) {
+	return g.backend.Head()
+}
+func (g *Git) FindCommit(ref, text string) (string, error) {
+	return g.backend.FindCommit(ref, text)
+}
+func (g *Git) RemoteRef() string {
+	return g.backend.RemoteRef()
+}
+func (g *Git) Log(ref string, texts ...string) ([]Commit, error) {
+	return g.backend.Log(ref, texts...)
+}
+func (g *Git) UpdateRef(ref, sha string) error {
+	return g.backend.UpdateRef(ref, sha)
+}
+func (g *Git) RevParse(ref string) (string, error) {
+	return g.backend.RevParse(ref)
+}
+func (g *Git) PushRefs(pattern string) error {
+	return g.backend.PushRefs(pattern)
+}
+func (g *Git) FetchRefs(pattern string) error {
+	return g.backend.FetchRefs(pattern)
+}
+func (g *Git) Show(ref, path string) ([]byte, error) {
+	return g.backend.Show(ref, path)
+}
+func (g *Git) Branch() string {
+	return g.backend.Branch()
+}
+func (g *Git) Checkout(branch, startPoint string) error {
+	return g.backend.Checkout(branch, startPoint)
+}
+func (g *Git) PushBranch(branch string) error {
+	return g.backend.PushBranch(branch)
+}
+func (g *Git) Revert(shas ...string) error {
+	return g.backend.Revert(shas...)
 }
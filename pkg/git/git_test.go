@@ -1,13 +1,18 @@
 package git
 
 import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/valer-cara/mgo/pkg/testutils"
+	"github.com/valer-cara/mgo/pkg/util"
 )
 
 func TestInexistentGitBackend(t *testing.T) {
-	_, err := NewGit(909090, "whatever")
+	_, err := NewGit(909090, "whatever", nil)
 	if err == nil {
 		t.Fatal("Should not allow initialization with inexsitent git backend")
 	}
@@ -15,15 +20,242 @@ func TestInexistentGitBackend(t *testing.T) {
 
 func TestWithExternalBackendGoodRepo(t *testing.T) {
 	repo, _ := testutils.CreateTestRepoWithOrigin(t)
-	_, err := NewGit(BACKEND_EXTERNAL, repo)
+	_, err := NewGit(BACKEND_EXTERNAL, repo, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestWithExternalBackendBadRepo(t *testing.T) {
-	_, err := NewGit(BACKEND_EXTERNAL, "/tmp/no-repo-here-nope")
+	_, err := NewGit(BACKEND_EXTERNAL, "/tmp/no-repo-here-nope", nil)
 	if err == nil {
 		t.Fatal("non-git-repo should have returned an error")
 	}
 }
+
+func TestFindCommit(t *testing.T) {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	g, err := NewGit(BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Commit("Deploy: foo\n\nMgo-Deploy-Id: abc123"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := g.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sha, err := g.FindCommit("HEAD", "Mgo-Deploy-Id: abc123"); err != nil || sha != head {
+		t.Fatalf("Expected to find commit %s, got '%s' (%v)", head, sha, err)
+	}
+
+	// Not pushed yet
+	if sha, err := g.FindCommit(g.RemoteRef(), "Mgo-Deploy-Id: abc123"); err != nil || sha != "" {
+		t.Fatalf("Expected no pushed commit, got '%s' (%v)", sha, err)
+	}
+
+	if err := g.Push(); err != nil {
+		t.Fatal(err)
+	}
+	if sha, err := g.FindCommit(g.RemoteRef(), "Mgo-Deploy-Id: abc123"); err != nil || sha != head {
+		t.Fatalf("Expected to find pushed commit %s, got '%s' (%v)", head, sha, err)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	g, err := NewGit(BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sha, err := g.RevParse("refs/mgo/synced/foo"); err != nil || sha != "" {
+		t.Fatalf("Expected no ref, got '%s' (%v)", sha, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repo, "foo.yaml"), []byte("foo: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Commit("foo"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := g.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.UpdateRef("refs/mgo/synced/foo", head); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.PushRefs("refs/mgo/synced/*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.FetchRefs("refs/mgo/synced/*"); err != nil {
+		t.Fatal(err)
+	}
+	if sha, err := g.RevParse("refs/mgo/synced/foo"); err != nil || sha != head {
+		t.Fatalf("Expected ref at %s, got '%s' (%v)", head, sha, err)
+	}
+
+	content, err := g.Show("refs/mgo/synced/foo", "foo.yaml")
+	if err != nil || string(content) != "foo: 1\n" {
+		t.Fatalf("Unexpected content '%s' (%v)", content, err)
+	}
+	if _, err := g.Show("refs/mgo/synced/foo", "nope.yaml"); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestIdent(t *testing.T) {
+	tests := map[string]string{
+		"Ronaldo <ronaldo@example.com>":     "Ronaldo <ronaldo@example.com>",
+		"  Ronaldo   <ronaldo@example.com>": "Ronaldo <ronaldo@example.com>",
+		"ronaldo@example.com":               "ronaldo <ronaldo@example.com>",
+		"Ronaldo":                           "Ronaldo <>",
+		"Ronaldo <nope":                     "Ronaldo nope <>",
+	}
+
+	for author, expected := range tests {
+		if ident := Ident(author); ident != expected {
+			t.Fatalf("%s: expected %s, got %s", author, expected, ident)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, config := range []Config{{}, {Signing: SigningConfig{Format: SIGNING_GPG}}, {Signing: SigningConfig{Format: SIGNING_SSH, Key: "/key"}}} {
+		if err := config.Validate(); err != nil {
+			t.Fatalf("Expected %+v to be valid: %v", config, err)
+		}
+	}
+
+	for _, config := range []Config{{Signing: SigningConfig{Format: "x509"}}, {Signing: SigningConfig{Format: SIGNING_SSH}}, {Name: "mgo <evil>"}} {
+		if err := config.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestBranchRemoteAndAuthor(t *testing.T) {
+	repo, origin := testutils.CreateTestRepoWithOrigin(t)
+	err := util.RunCommands([]string{"GIT_DIR=" + filepath.Join(repo, ".git")},
+		exec.Command("git", "remote", "add", "upstream", origin),
+		exec.Command("git", "push", "upstream", "master:main"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGit(BACKEND_EXTERNAL, repo, &Config{Branch: "main", Remote: "upstream", Name: "mgo", Email: "mgo@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.RemoteRef() != "upstream/main" {
+		t.Fatalf("Unexpected remote ref %s", g.RemoteRef())
+	}
+
+	err = util.CallFunctions(
+		g.Fetch,
+		g.Reset,
+		func() error { return g.CommitAs("Deploy: foo", "Ronaldo <ronaldo@example.com>") },
+		g.Push,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("git", "--git-dir", filepath.Join(origin, ".git"), "log", "main", "-1", "--format=%an|%ae|%cn|%ce|%s").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Ronaldo|ronaldo@example.com|mgo|mgo@example.com|Deploy: foo"; strings.TrimSpace(string(out)) != expected {
+		t.Fatalf("Expected %s, got %s", expected, out)
+	}
+}
+
+func TestSSHSigning(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	g, err := NewGit(BACKEND_EXTERNAL, repo, &Config{Signing: SigningConfig{Format: SIGNING_SSH, Key: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.CommitAs("Deploy: foo", "Ronaldo"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("git", "-C", repo, "cat-file", "commit", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("Expected an SSH signed commit, got:\n%s", out)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	g, err := NewGit(BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var shas []string
+	for _, content := range []string{"foo: 1\n", "foo: 2\n", "foo: 3\n"} {
+		err := util.CallFunctions(
+			func() error { return ioutil.WriteFile(filepath.Join(repo, "foo.yaml"), []byte(content), 0644) },
+			g.AddAll,
+			func() error { return g.Commit("foo") },
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, _ := g.Head()
+		shas = append(shas, head)
+	}
+	// Empty deploy commits are reverted too
+	if err := g.Commit("empty"); err != nil {
+		t.Fatal(err)
+	}
+	empty, _ := g.Head()
+
+	if err := g.Revert(empty, shas[2], shas[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Commit("Revert"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(repo, "foo.yaml")); string(content) != "foo: 1\n" {
+		t.Fatalf("Expected the first version back, got %s", content)
+	}
+
+	// Conflicts leave the work tree as it was
+	err = util.CallFunctions(
+		func() error { return ioutil.WriteFile(filepath.Join(repo, "foo.yaml"), []byte("foo: 4\n"), 0644) },
+		g.AddAll,
+		func() error { return g.Commit("foo") },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Revert(shas[2]); err == nil {
+		t.Fatal("Expected a conflict")
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(repo, "foo.yaml")); string(content) != "foo: 4\n" {
+		t.Fatalf("Expected the work tree untouched, got %s", content)
+	}
+}
@@ -0,0 +1,108 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+)
+
+// Kinds whose rollout is waited for
+var workloadKinds = []string{"DaemonSet", "Deployment", "Job", "StatefulSet"}
+
+// Labels telling which release an object belongs to. Older charts set
+// `release`, newer ones `app.kubernetes.io/instance`
+var releaseLabels = []string{"release", "app.kubernetes.io/instance"}
+
+// How often the workloads are looked at while waiting
+var PollInterval = 2 * time.Second
+
+// A release whose workloads didn't roll out
+type NotReadyError struct {
+	Release string
+	// Whether it gave up waiting, rather than something failing for good
+	TimedOut bool
+	Timeout  time.Duration
+	Reasons  []string
+}
+
+func (e *NotReadyError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("Release %s not ready after %s: %s", e.Release, e.Timeout, strings.Join(e.Reasons, "; "))
+	}
+	return fmt.Sprintf("Release %s not ready: %s", e.Release, strings.Join(e.Reasons, "; "))
+}
+
+// Waits until the Deployments, StatefulSets, DaemonSets and Jobs of a release
+// rolled out, like `kubectl rollout status` would. Gives up after timeout, or
+// as soon as a Job fails or a Deployment exceeds its progress deadline
+func WaitReady(k kubectl.KubectlService, release *helm.HelmRelease, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	name := release.Namespace + "/" + release.Name
+
+	for {
+		pending, failed, err := check(k, release)
+		if err != nil {
+			// Might be the apiserver having a moment, keep trying
+			log.Warnf("Cannot check release %s: %v", name, err)
+			pending = []string{err.Error()}
+		}
+
+		if len(failed) > 0 {
+			return &NotReadyError{Release: name, Reasons: failed}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &NotReadyError{Release: name, TimedOut: true, Timeout: timeout, Reasons: pending}
+		}
+
+		log.Debugf("Waiting for release %s: %s", name, strings.Join(pending, "; "))
+		time.Sleep(PollInterval)
+	}
+}
+
+// The workloads of the release still rolling out, and those that failed
+func check(k kubectl.KubectlService, release *helm.HelmRelease) ([]string, []string, error) {
+	var (
+		pending, failed []string
+		seen            = map[string]bool{}
+	)
+
+	for _, label := range releaseLabels {
+		listed, err := k.List(workloadKinds, release.Namespace, label+"="+release.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var list struct {
+			Items []workload
+		}
+		if err := yaml.Unmarshal(listed, &list); err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Cannot parse live objects: %v", err))
+		}
+
+		for _, w := range list.Items {
+			id := w.Kind + " " + w.Metadata.Namespace + "/" + w.Metadata.Name
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			if reason, ok := w.failed(); ok {
+				failed = append(failed, id+": "+reason)
+			} else if reason, ok := w.pending(); ok {
+				pending = append(pending, id+": "+reason)
+			}
+		}
+	}
+
+	return pending, failed, nil
+}
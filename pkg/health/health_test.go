@@ -0,0 +1,116 @@
+package health
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+)
+
+const (
+	deploymentReady = `
+kind: Deployment
+metadata: {name: web, namespace: app, generation: 2, labels: {release: web}}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 2, updatedReplicas: 2, availableReplicas: 2}
+`
+	deploymentRollingOut = `
+kind: Deployment
+metadata: {name: web, namespace: app, generation: 2, labels: {release: web}}
+spec: {replicas: 2}
+status: {observedGeneration: 2, replicas: 3, updatedReplicas: 2, availableReplicas: 1}
+`
+	deploymentStuck = `
+kind: Deployment
+metadata: {name: web, namespace: app, generation: 2, labels: {release: web}}
+spec: {replicas: 2}
+status:
+  observedGeneration: 2
+  conditions:
+  - {type: Progressing, status: "False", reason: ProgressDeadlineExceeded}
+`
+	jobFailed = `
+kind: Job
+metadata: {name: migrate, namespace: app, labels: {app.kubernetes.io/instance: web}}
+status:
+  conditions:
+  - {type: Failed, status: "True", message: BackoffLimitExceeded}
+`
+	jobComplete = `
+kind: Job
+metadata: {name: migrate, namespace: app, labels: {app.kubernetes.io/instance: web}}
+status:
+  conditions:
+  - {type: Complete, status: "True"}
+`
+	statefulSetUpdating = `
+kind: StatefulSet
+metadata: {name: db, namespace: app, generation: 1, labels: {release: web}}
+spec: {replicas: 3}
+status: {observedGeneration: 1, readyReplicas: 3, updatedReplicas: 1, currentRevision: db-1, updateRevision: db-2}
+`
+	daemonSetReady = `
+kind: DaemonSet
+metadata: {name: agent, namespace: app, generation: 1, labels: {release: web}}
+status: {observedGeneration: 1, desiredNumberScheduled: 3, updatedNumberScheduled: 3, numberAvailable: 3}
+`
+	// Same release name, another namespace
+	otherNamespace = `
+kind: Deployment
+metadata: {name: web, namespace: staging, generation: 2, labels: {release: web}}
+status: {observedGeneration: 1}
+`
+)
+
+var release = &helm.HelmRelease{Name: "web", Namespace: "app"}
+
+func TestWaitReady(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+
+	k := &kubectl.KubectlFake{Objects: map[string]string{
+		"Deployment/app/web":     deploymentReady,
+		"Job/app/migrate":        jobComplete,
+		"DaemonSet/app/agent":    daemonSetReady,
+		"Deployment/staging/web": otherNamespace,
+	}}
+	if err := WaitReady(k, release, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// No workloads at all
+	if err := WaitReady(&kubectl.KubectlFake{}, release, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitReadyFails(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		Objects  map[string]string
+		TimedOut bool
+		Reason   string
+	}{
+		{map[string]string{"Deployment/app/web": deploymentRollingOut}, true, "Deployment app/web: 1 old replicas pending termination"},
+		{map[string]string{"StatefulSet/app/db": statefulSetUpdating}, true, "StatefulSet app/db: 1 of 3 replicas updated"},
+		{map[string]string{"Deployment/app/web": deploymentStuck}, false, "Deployment app/web: progress deadline exceeded"},
+		{map[string]string{"Job/app/migrate": jobFailed}, false, "Job app/migrate: failed: BackoffLimitExceeded"},
+	}
+
+	for _, test := range tests {
+		err := WaitReady(&kubectl.KubectlFake{Objects: test.Objects}, release, 50*time.Millisecond)
+
+		notReady, ok := err.(*NotReadyError)
+		if !ok {
+			t.Fatalf("Expected a NotReadyError, got %v", err)
+		}
+		if notReady.TimedOut != test.TimedOut || len(notReady.Reasons) != 1 || notReady.Reasons[0] != test.Reason {
+			t.Fatalf("Expected '%s' (timed out: %v), got %+v", test.Reason, test.TimedOut, notReady)
+		}
+		if !strings.HasPrefix(err.Error(), "Release app/web not ready") {
+			t.Fatalf("Unexpected error message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package health
+
+import (
+	"fmt"
+)
+
+// The fields of the workload kinds telling whether they rolled out
+type workload struct {
+	Kind     string
+	Metadata struct {
+		Name       string
+		Namespace  string
+		Generation int64
+	}
+	Spec struct {
+		Replicas       *int32
+		UpdateStrategy struct {
+			Type          string
+			RollingUpdate struct {
+				Partition *int32
+			} `yaml:"rollingUpdate"`
+		} `yaml:"updateStrategy"`
+	}
+	Status struct {
+		ObservedGeneration int64 `yaml:"observedGeneration"`
+
+		// Deployments, StatefulSets
+		Replicas          int32
+		UpdatedReplicas   int32  `yaml:"updatedReplicas"`
+		ReadyReplicas     int32  `yaml:"readyReplicas"`
+		AvailableReplicas int32  `yaml:"availableReplicas"`
+		CurrentRevision   string `yaml:"currentRevision"`
+		UpdateRevision    string `yaml:"updateRevision"`
+
+		// DaemonSets
+		DesiredNumberScheduled int32 `yaml:"desiredNumberScheduled"`
+		UpdatedNumberScheduled int32 `yaml:"updatedNumberScheduled"`
+		NumberAvailable        int32 `yaml:"numberAvailable"`
+
+		// Deployments, Jobs
+		Conditions []condition
+	}
+}
+
+type condition struct {
+	Type    string
+	Status  string
+	Reason  string
+	Message string
+}
+
+// Why the workload won't roll out, if it won't
+func (w *workload) failed() (string, bool) {
+	switch w.Kind {
+	case "Deployment":
+		if c := w.findCondition("Progressing"); c != nil && c.Status == "False" && c.Reason == "ProgressDeadlineExceeded" {
+			return "progress deadline exceeded", true
+		}
+	case "Job":
+		if c := w.findCondition("Failed"); c != nil && c.Status == "True" {
+			return fmt.Sprintf("failed: %s", c.Message), true
+		}
+	}
+	return "", false
+}
+
+// What the workload is still waiting for, if anything. Same checks as
+// `kubectl rollout status`
+func (w *workload) pending() (string, bool) {
+	if w.Kind == "Job" {
+		if c := w.findCondition("Complete"); c != nil && c.Status == "True" {
+			return "", false
+		}
+		return "not complete", true
+	}
+
+	if w.Status.ObservedGeneration < w.Metadata.Generation {
+		return "update not observed yet", true
+	}
+
+	// Pods are only replaced when deleted, there's nothing to wait for
+	if w.Spec.UpdateStrategy.Type == "OnDelete" {
+		return "", false
+	}
+
+	replicas := int32(1)
+	if w.Spec.Replicas != nil {
+		replicas = *w.Spec.Replicas
+	}
+
+	switch w.Kind {
+	case "Deployment":
+		if w.Status.UpdatedReplicas < replicas {
+			return fmt.Sprintf("%d of %d replicas updated", w.Status.UpdatedReplicas, replicas), true
+		}
+		if w.Status.Replicas > w.Status.UpdatedReplicas {
+			return fmt.Sprintf("%d old replicas pending termination", w.Status.Replicas-w.Status.UpdatedReplicas), true
+		}
+		if w.Status.AvailableReplicas < w.Status.UpdatedReplicas {
+			return fmt.Sprintf("%d of %d updated replicas available", w.Status.AvailableReplicas, w.Status.UpdatedReplicas), true
+		}
+
+	case "StatefulSet":
+		if w.Status.ReadyReplicas < replicas {
+			return fmt.Sprintf("%d of %d replicas ready", w.Status.ReadyReplicas, replicas), true
+		}
+		if partition := w.Spec.UpdateStrategy.RollingUpdate.Partition; partition != nil && *partition > 0 {
+			if w.Status.UpdatedReplicas < replicas-*partition {
+				return fmt.Sprintf("%d of %d replicas updated", w.Status.UpdatedReplicas, replicas-*partition), true
+			}
+			return "", false
+		}
+		if w.Status.UpdateRevision != w.Status.CurrentRevision {
+			return fmt.Sprintf("%d of %d replicas updated", w.Status.UpdatedReplicas, replicas), true
+		}
+
+	case "DaemonSet":
+		if w.Status.UpdatedNumberScheduled < w.Status.DesiredNumberScheduled {
+			return fmt.Sprintf("%d of %d pods updated", w.Status.UpdatedNumberScheduled, w.Status.DesiredNumberScheduled), true
+		}
+		if w.Status.NumberAvailable < w.Status.DesiredNumberScheduled {
+			return fmt.Sprintf("%d of %d updated pods available", w.Status.NumberAvailable, w.Status.DesiredNumberScheduled), true
+		}
+	}
+
+	return "", false
+}
+
+func (w *workload) findCondition(conditionType string) *condition {
+	for idx := range w.Status.Conditions {
+		if w.Status.Conditions[idx].Type == conditionType {
+			return &w.Status.Conditions[idx]
+		}
+	}
+	return nil
+}
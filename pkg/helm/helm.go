@@ -1,14 +1,25 @@
 package helm
 
 import (
+	"errors"
+	"fmt"
 	"io"
 )
 
+// HelmService implementations, selected with `helm.backend` in `mygitops.yaml`
+const (
+	// Exec the helm binary (default)
+	BACKEND_CMD = "cmd"
+	// In-process, with the helm libraries
+	BACKEND_SDK = "sdk"
+)
+
 // Abstraction providing helm services
 type HelmService interface {
 	Init() error
 	SyncRelease(*HelmRelease, []string) error
 	DiffRelease(*HelmRelease, []string) error
+	TemplateRelease(*HelmRelease, []string) ([]byte, error)
 	AddRepo(*HelmRepo) error
 	ListRepos() ([]HelmRepo, error)
 	UpdateRepos() error
@@ -36,3 +47,38 @@ type HelmRepo struct {
 	Name string
 	Url  string
 }
+
+type HelmServiceOptions struct {
+	// `cmd` or `sdk`. Empty means `cmd`
+	Backend string
+
+	Repositories []HelmRepo
+	HelmHome     string
+	Kubeconfig   string
+	Kubecontext  string
+	DryRun       bool
+}
+
+// Create the HelmService of the selected backend. Init() is left to the caller
+func NewHelmService(options *HelmServiceOptions) (HelmService, error) {
+	switch options.Backend {
+	case "", BACKEND_CMD:
+		return NewHelmCmd(&HelmCmdOptions{
+			Repositories: options.Repositories,
+			HelmHome:     options.HelmHome,
+			Kubeconfig:   options.Kubeconfig,
+			Kubecontext:  options.Kubecontext,
+			DryRun:       options.DryRun,
+		}), nil
+	case BACKEND_SDK:
+		return NewHelmSDK(&HelmSDKOptions{
+			Repositories: options.Repositories,
+			HelmHome:     options.HelmHome,
+			Kubeconfig:   options.Kubeconfig,
+			Kubecontext:  options.Kubecontext,
+			DryRun:       options.DryRun,
+		}), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown helm backend '%s'. Use '%s' or '%s'", options.Backend, BACKEND_CMD, BACKEND_SDK))
+	}
+}
@@ -220,6 +220,43 @@ func (h *HelmCmd) DiffRelease(release *HelmRelease, valueFiles []string) error {
 	return nil
 }
 
+// Render the release's manifests locally, without talking to tiller.
+// `helm template` only works with local charts, so the chart is fetched into a
+// temp dir first
+func (h *HelmCmd) TemplateRelease(release *HelmRelease, valueFiles []string) ([]byte, error) {
+	dir, err := ioutil.TempDir("/tmp", "mygitops-chart-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	output, err := h.execer.Exec(
+		"fetch", release.Chart,
+		"--version", release.Version,
+		"--untar", "--untardir", dir,
+	)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot fetch chart %s-%s: %v: %s", release.Chart, release.Version, err, string(output)))
+	}
+
+	cmd := []string{
+		"template", path.Join(dir, path.Base(release.Chart)),
+		"--name", release.Name,
+		"--namespace", release.Namespace,
+	}
+
+	for _, valueFile := range valueFiles {
+		cmd = append(cmd, "--values="+valueFile)
+	}
+
+	output, err = h.execer.Exec(cmd...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot render release %s: %v: %s", release.Name, err, string(output)))
+	}
+
+	return output, nil
+}
+
 func (h *HelmCmd) UpdateRepos() error {
 	_, err := h.execer.Exec("repo", "update")
 
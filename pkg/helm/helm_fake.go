@@ -3,19 +3,30 @@ package helm
 import (
 	"errors"
 	"io"
+	"sync"
 )
 
 // Mock Helm Service
 // Set the `FailOn*` values to return an error with that message. If not
 // set/empty, the corresponding calls will succeseed
 type HelmFake struct {
-	FailOnInit        string
-	FailOnSyncRelease string
-	FailOnDiffRelease string
-	FailOnAddRepo     string
-	FailOnListRepos   string
-	FailOnUpdateRepos string
-	Repos             []HelmRepo
+	FailOnInit            string
+	FailOnSyncRelease     string
+	FailOnDiffRelease     string
+	FailOnTemplateRelease string
+	FailOnAddRepo         string
+	FailOnListRepos       string
+	FailOnUpdateRepos     string
+	Repos                 []HelmRepo
+
+	// Rendered manifests returned by TemplateRelease(), by release name
+	Manifests map[string]string
+
+	// Error messages returned by SyncRelease() for specific releases, by name
+	FailOnSyncReleases map[string]string
+	// Releases passed to SyncRelease(), in order
+	Synced []string
+	mu     sync.Mutex
 }
 
 func (h *HelmFake) Init() error {
@@ -24,10 +35,17 @@ func (h *HelmFake) Init() error {
 	}
 	return nil
 }
-func (h *HelmFake) SyncRelease(*HelmRelease, []string) error {
+func (h *HelmFake) SyncRelease(release *HelmRelease, valueFiles []string) error {
+	h.mu.Lock()
+	h.Synced = append(h.Synced, release.Name)
+	h.mu.Unlock()
+
 	if h.FailOnSyncRelease != "" {
 		return errors.New(h.FailOnSyncRelease)
 	}
+	if msg := h.FailOnSyncReleases[release.Name]; msg != "" {
+		return errors.New(msg)
+	}
 	return nil
 }
 func (h *HelmFake) DiffRelease(*HelmRelease, []string) error {
@@ -36,6 +54,12 @@ func (h *HelmFake) DiffRelease(*HelmRelease, []string) error {
 	}
 	return nil
 }
+func (h *HelmFake) TemplateRelease(release *HelmRelease, valueFiles []string) ([]byte, error) {
+	if h.FailOnTemplateRelease != "" {
+		return nil, errors.New(h.FailOnTemplateRelease)
+	}
+	return []byte(h.Manifests[release.Name]), nil
+}
 func (h *HelmFake) AddRepo(*HelmRepo) error {
 	if h.FailOnInit != "" {
 		return errors.New(h.FailOnInit)
@@ -0,0 +1,366 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// Implements HelmService interface in-process, on top of the helm (v3) libraries.
+// No helm binary or plugins are needed.
+//
+// Releases are stored the helm v3 way (as secrets in the release namespace), so
+// releases previously installed through tiller are not seen by this backend.
+type HelmSDK struct {
+	helmHome    string
+	kubeconfig  string
+	kubecontext string
+	dryRun      bool
+	writer      io.Writer
+	options     *HelmSDKOptions
+
+	// Builds the helm action configuration for a namespace. Replaced in tests
+	// to run against an in-memory release store and a fake kubernetes client
+	newActionConfig func(namespace string) (*action.Configuration, error)
+}
+
+type HelmSDKOptions struct {
+	Repositories []HelmRepo
+	HelmHome     string
+	Kubeconfig   string
+	Kubecontext  string
+	DryRun       bool
+}
+
+func NewHelmSDK(options *HelmSDKOptions) *HelmSDK {
+	h := &HelmSDK{
+		// Same as for HelmCmd, an empty helmHome means a fresh temp one.
+		// Repositories live in the same place as they did for helm v2:
+		// $HELM_HOME/repository/repositories.yaml
+		helmHome:    options.HelmHome,
+		kubeconfig:  options.Kubeconfig,
+		kubecontext: options.Kubecontext,
+		dryRun:      options.DryRun,
+		options:     options,
+	}
+	h.newActionConfig = h.clusterActionConfig
+
+	return h
+}
+
+func (h *HelmSDK) String() string {
+	return fmt.Sprintf("HelmSDK(helm_home: %s)", h.helmHome)
+}
+
+func (h *HelmSDK) Init() error {
+	if h.helmHome == "" {
+		dir, err := ioutil.TempDir("/tmp", "mygitops-helmhome-")
+		if err != nil {
+			return errors.New("Cannot create helmHome dir. " + err.Error())
+		}
+
+		log.Printf("No HELM_HOME specified. Using temp dir '%s' as helm home.", dir)
+		h.helmHome = dir
+	}
+
+	if err := os.MkdirAll(h.repositoryCache(), 0755); err != nil {
+		return errors.New(fmt.Sprintf("Cannot initialize helm home (%s). %v", h, err))
+	}
+
+	repos, err := h.loadRepoFile()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot initialize helm home (%s). %v", h, err))
+	}
+
+	for _, repo := range h.options.Repositories {
+		existing := repos.Get(repo.Name)
+		if existing != nil && existing.URL == repo.Url {
+			continue
+		}
+		if err := h.AddRepo(&repo); err != nil {
+			return errors.New(fmt.Sprintf("Cannot add repo (%s). %s", h, err.Error()))
+		}
+	}
+
+	return nil
+}
+
+// Downloads the repo's index, then records it in the repositories file
+func (h *HelmSDK) AddRepo(helmRepo *HelmRepo) error {
+	entry := &repo.Entry{
+		Name: helmRepo.Name,
+		URL:  helmRepo.Url,
+	}
+
+	if err := h.downloadIndex(entry); err != nil {
+		return err
+	}
+
+	repos, err := h.loadRepoFile()
+	if err != nil {
+		return err
+	}
+
+	repos.Update(entry)
+
+	return repos.WriteFile(h.repositoryConfig(), 0644)
+}
+
+func (h *HelmSDK) ListRepos() ([]HelmRepo, error) {
+	repos, err := h.loadRepoFile()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []HelmRepo{}
+	for _, entry := range repos.Repositories {
+		result = append(result, HelmRepo{Name: entry.Name, Url: entry.URL})
+	}
+
+	return result, nil
+}
+
+func (h *HelmSDK) UpdateRepos() error {
+	repos, err := h.loadRepoFile()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range repos.Repositories {
+		if err := h.downloadIndex(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Same as `helm upgrade --install`. The namespace is created if missing, the
+// way tiller used to do it
+func (h *HelmSDK) SyncRelease(release *HelmRelease, valueFiles []string) error {
+	cfg, chrt, vals, err := h.prepare(release, valueFiles)
+	if err != nil {
+		return err
+	}
+
+	history := action.NewHistory(cfg)
+	history.Max = 1
+
+	if _, err := history.Run(release.Name); err == driver.ErrReleaseNotFound {
+		install := action.NewInstall(cfg)
+		install.ReleaseName = release.Name
+		install.Namespace = release.Namespace
+		install.CreateNamespace = true
+		install.DryRun = h.dryRun
+
+		rel, err := install.Run(chrt, vals)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot install release %s: %v", release.Name, err))
+		}
+
+		h.write(fmt.Sprintf("Release \"%s\" has been installed. Revision: %d\n", rel.Name, rel.Version))
+		return nil
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("Cannot get history of release %s: %v", release.Name, err))
+	}
+
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = release.Namespace
+	upgrade.DryRun = h.dryRun
+
+	rel, err := upgrade.Run(release.Name, chrt, vals)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot upgrade release %s: %v", release.Name, err))
+	}
+
+	h.write(fmt.Sprintf("Release \"%s\" has been upgraded. Revision: %d\n", rel.Name, rel.Version))
+	return nil
+}
+
+// Writes a unified diff between the deployed release's manifests and the
+// manifests it would have after an upgrade. A release that isn't installed yet
+// diffs against nothing, like `helm diff upgrade --allow-unreleased`
+func (h *HelmSDK) DiffRelease(release *HelmRelease, valueFiles []string) error {
+	cfg, chrt, vals, err := h.prepare(release, valueFiles)
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	desired := ""
+
+	deployed, err := action.NewGet(cfg).Run(release.Name)
+	if err == driver.ErrReleaseNotFound {
+		rel, err := h.render(cfg, release, chrt, vals)
+		if err != nil {
+			return err
+		}
+		desired = rel
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("Cannot get release %s: %v", release.Name, err))
+	} else {
+		upgrade := action.NewUpgrade(cfg)
+		upgrade.Namespace = release.Namespace
+		upgrade.DryRun = true
+
+		rel, err := upgrade.Run(release.Name, chrt, vals)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot render release %s: %v", release.Name, err))
+		}
+
+		current = deployed.Manifest
+		desired = rel.Manifest
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(desired),
+		FromFile: release.Name + " (deployed)",
+		ToFile:   release.Name + " (desired)",
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+
+	h.write(diff)
+	return nil
+}
+
+// Render the release's manifests locally, without talking to the cluster
+func (h *HelmSDK) TemplateRelease(release *HelmRelease, valueFiles []string) ([]byte, error) {
+	cfg, chrt, vals, err := h.prepare(release, valueFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := h.render(cfg, release, chrt, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(manifests), nil
+}
+
+func (h *HelmSDK) SetOutput(w io.Writer) {
+	h.writer = w
+}
+
+func (h *HelmSDK) render(cfg *action.Configuration, release *HelmRelease, chrt *chart.Chart, vals map[string]interface{}) (string, error) {
+	install := action.NewInstall(cfg)
+	install.ReleaseName = release.Name
+	install.Namespace = release.Namespace
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+
+	rel, err := install.Run(chrt, vals)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Cannot render release %s: %v", release.Name, err))
+	}
+
+	return rel.Manifest, nil
+}
+
+// Everything an action needs: the action config for the release's namespace,
+// the loaded chart and the merged values
+func (h *HelmSDK) prepare(release *HelmRelease, valueFiles []string) (*action.Configuration, *chart.Chart, map[string]interface{}, error) {
+	settings := h.settings(release.Namespace)
+
+	chartPath, err := (&action.ChartPathOptions{Version: release.Version}).LocateChart(release.Chart, settings)
+	if err != nil {
+		return nil, nil, nil, errors.New(fmt.Sprintf("Cannot fetch chart %s-%s: %v", release.Chart, release.Version, err))
+	}
+
+	chrt, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, nil, nil, errors.New(fmt.Sprintf("Cannot load chart %s-%s: %v", release.Chart, release.Version, err))
+	}
+
+	vals, err := (&values.Options{ValueFiles: valueFiles}).MergeValues(getter.All(settings))
+	if err != nil {
+		return nil, nil, nil, errors.New(fmt.Sprintf("Cannot load values of release %s: %v", release.Name, err))
+	}
+
+	cfg, err := h.newActionConfig(release.Namespace)
+	if err != nil {
+		return nil, nil, nil, errors.New(fmt.Sprintf("Cannot initialize helm for namespace %s: %v", release.Namespace, err))
+	}
+
+	return cfg, chrt, vals, nil
+}
+
+func (h *HelmSDK) clusterActionConfig(namespace string) (*action.Configuration, error) {
+	cfg := &action.Configuration{}
+
+	err := cfg.Init(h.settings(namespace).RESTClientGetter(), namespace, "secret", log.Debugf)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Settings are built per call: releases are synced in parallel, each in its
+// own namespace
+func (h *HelmSDK) settings(namespace string) *cli.EnvSettings {
+	settings := cli.New()
+	settings.KubeConfig = h.kubeconfig
+	settings.KubeContext = h.kubecontext
+	settings.RepositoryConfig = h.repositoryConfig()
+	settings.RepositoryCache = h.repositoryCache()
+	settings.SetNamespace(namespace)
+
+	return settings
+}
+
+func (h *HelmSDK) downloadIndex(entry *repo.Entry) error {
+	chartRepo, err := repo.NewChartRepository(entry, getter.All(h.settings("")))
+	if err != nil {
+		return err
+	}
+	chartRepo.CachePath = h.repositoryCache()
+
+	if _, err := chartRepo.DownloadIndexFile(); err != nil {
+		return errors.New(fmt.Sprintf("Cannot download index of repo %s (%s): %v", entry.Name, entry.URL, err))
+	}
+
+	return nil
+}
+
+func (h *HelmSDK) loadRepoFile() (*repo.File, error) {
+	if _, err := os.Stat(h.repositoryConfig()); os.IsNotExist(err) {
+		return repo.NewFile(), nil
+	}
+
+	return repo.LoadFile(h.repositoryConfig())
+}
+
+func (h *HelmSDK) repositoryConfig() string {
+	return path.Join(h.helmHome, "repository/repositories.yaml")
+}
+
+func (h *HelmSDK) repositoryCache() string {
+	return path.Join(h.helmHome, "repository/cache")
+}
+
+func (h *HelmSDK) write(output string) {
+	if h.writer != nil {
+		h.writer.Write([]byte(output))
+	}
+}
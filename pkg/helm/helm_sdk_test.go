@@ -0,0 +1,168 @@
+package helm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+var testRelease = &HelmRelease{
+	Chart:     "testdata/mychart",
+	Version:   "0.1.0",
+	Name:      "web",
+	Namespace: "apps",
+}
+
+// A HelmSDK storing releases in memory and talking to a fake kubernetes client
+func newTestHelmSDK(t *testing.T) (*HelmSDK, *storage.Storage) {
+	h := NewHelmSDK(&HelmSDKOptions{HelmHome: t.TempDir()})
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.Init(driver.NewMemory())
+	h.newActionConfig = func(namespace string) (*action.Configuration, error) {
+		return &action.Configuration{
+			Releases:     store,
+			KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+			Capabilities: chartutil.DefaultCapabilities,
+			Log:          t.Logf,
+		}, nil
+	}
+
+	return h, store
+}
+
+func TestHelmSDKSyncRelease(t *testing.T) {
+	h, store := newTestHelmSDK(t)
+
+	if err := h.SyncRelease(testRelease, nil); err != nil {
+		t.Fatal("install:", err)
+	}
+	if err := h.SyncRelease(testRelease, []string{"testdata/mychart-values.yaml"}); err != nil {
+		t.Fatal("upgrade:", err)
+	}
+
+	rel, err := store.Last("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rel.Version != 2 || rel.Namespace != "apps" {
+		t.Fatalf("Expected revision 2 in namespace apps, got revision %d in %s", rel.Version, rel.Namespace)
+	}
+	if !strings.Contains(rel.Manifest, `image: "a/repo1:1.1.0"`) {
+		t.Fatalf("Expected the value files to be applied, got:\n%s", rel.Manifest)
+	}
+}
+
+func TestHelmSDKSyncReleaseDryRun(t *testing.T) {
+	h, store := newTestHelmSDK(t)
+	h.dryRun = true
+
+	if err := h.SyncRelease(testRelease, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Last("web"); err == nil {
+		t.Fatal("Expected no release to be stored on dry run")
+	}
+}
+
+func TestHelmSDKDiffRelease(t *testing.T) {
+	h, _ := newTestHelmSDK(t)
+
+	if err := h.SyncRelease(testRelease, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	h.SetOutput(&out)
+
+	if err := h.DiffRelease(testRelease, []string{"testdata/mychart-values.yaml"}); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := out.String()
+	if !strings.Contains(diff, `-        image: "a/repo1:1.0.0"`) || !strings.Contains(diff, `+        image: "a/repo1:1.1.0"`) {
+		t.Fatalf("Expected the image change in the diff, got:\n%s", diff)
+	}
+}
+
+func TestHelmSDKTemplateRelease(t *testing.T) {
+	h, store := newTestHelmSDK(t)
+
+	manifests, err := h.TemplateRelease(testRelease, []string{"testdata/mychart-values.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(manifests, []byte("namespace: apps")) || !bytes.Contains(manifests, []byte(`image: "a/repo1:1.1.0"`)) {
+		t.Fatalf("Unexpected manifests:\n%s", string(manifests))
+	}
+	if _, err := store.Last("web"); err == nil {
+		t.Fatal("Expected templating to leave the release store alone")
+	}
+}
+
+func TestHelmSDKRepos(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata/repo")))
+	defer server.Close()
+
+	h := NewHelmSDK(&HelmSDKOptions{
+		HelmHome: t.TempDir(),
+		Repositories: []HelmRepo{
+			{Name: "foobar", Url: server.URL},
+			{Name: "bazbar", Url: server.URL},
+		},
+	})
+	if err := h.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.AddRepo(&HelmRepo{Name: "cool-repo", Url: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.UpdateRepos(); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := h.ListRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 3 {
+		t.Fatal("Expected the preset repos and 'cool-repo', found", repos)
+	}
+
+	if err := h.AddRepo(&HelmRepo{Name: "broken", Url: server.URL + "/nope"}); err == nil {
+		t.Fatal("Expected an error adding a repo without an index")
+	}
+}
+
+func TestNewHelmService(t *testing.T) {
+	if h, err := NewHelmService(&HelmServiceOptions{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := h.(*HelmCmd); !ok {
+		t.Fatalf("Expected the helm binary to be used by default, got %T", h)
+	}
+
+	if h, err := NewHelmService(&HelmServiceOptions{Backend: BACKEND_SDK}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := h.(*HelmSDK); !ok {
+		t.Fatalf("Expected the sdk backend, got %T", h)
+	}
+
+	if _, err := NewHelmService(&HelmServiceOptions{Backend: "tiller"}); err == nil {
+		t.Fatal("Expected an error for an unknown backend")
+	}
+}
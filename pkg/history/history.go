@@ -0,0 +1,163 @@
+/*
+ * History: the deploys recorded in the gitops repo, read back from the
+ * commit log.
+ */
+package history
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/git"
+)
+
+type Record struct {
+	Commit string                `json:"commit"`
+	Date   time.Time             `json:"date"`
+	Deploy *deploy.DeployOptions `json:"deploy"`
+}
+
+type Filter struct {
+	// Empty matches any
+	Cluster     string
+	TriggerRepo string
+
+	// Max records returned. 0 means all
+	Limit int
+}
+
+func (f *Filter) Matches(d *deploy.DeployOptions) bool {
+	if f.Cluster != "" && f.Cluster != d.Cluster {
+		return false
+	}
+	if f.TriggerRepo != "" && f.TriggerRepo != d.TriggerRepo {
+		return false
+	}
+	return true
+}
+
+// The deploys reachable from `ref`, newest first. Deploys reverted since,
+// see deploy.RevertMessage(), are left out
+func List(gitService *git.Git, ref string, filter *Filter) ([]*Record, error) {
+	commits, err := gitService.Log(ref, deploy.SUBJECT_DEPLOY, deploy.SUBJECT_ROLLBACK, deploy.SUBJECT_PROMOTE, deploy.SUBJECT_REVERT)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*Record{}
+	reverted := map[string]bool{}
+
+	for _, commit := range commits {
+		if ids, ok := deploy.ParseRevertMessage(commit.Message); ok {
+			for _, id := range ids {
+				reverted[id] = true
+			}
+			continue
+		}
+
+		dopts, ok := deploy.ParseCommitMessage(commit.Message)
+		if !ok || !filter.Matches(dopts) || (dopts.ID != "" && reverted[dopts.ID]) {
+			continue
+		}
+
+		records = append(records, &Record{
+			Commit: commit.Sha,
+			Date:   commit.Date,
+			Deploy: dopts,
+		})
+
+		if filter.Limit > 0 && len(records) == filter.Limit {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// Turn a rollback request into a deploy of the image it goes back to: the
+// image deployed before the current one, or the one deployed by the record
+// matching `RollbackTo` (a tag or a commit sha prefix). Only deploys of the
+// same trigger repo to the same cluster are considered. Earlier rollbacks, and
+// the deploys they rolled back, don't count as deploys before the current one
+func ResolveRollback(gitService *git.Git, ref string, dopts *deploy.DeployOptions) error {
+	records, err := List(gitService, ref, &Filter{
+		Cluster:     dopts.Cluster,
+		TriggerRepo: dopts.TriggerRepo,
+	})
+	if err != nil {
+		return err
+	}
+
+	target, err := rollbackTarget(records, dopts.RollbackTo)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot roll back %s on %s: %v", dopts.TriggerRepo, dopts.Cluster, err))
+	}
+
+	dopts.Image = target.Deploy.Image
+	dopts.RollbackTo = target.Commit
+	return nil
+}
+
+func rollbackTarget(records []*Record, to string) (*Record, error) {
+	if len(records) == 0 {
+		return nil, errors.New("no deploys found")
+	}
+	current := records[0].Deploy.Image
+	// Commit of the record an earlier rollback went back to
+	skipUntil := ""
+
+	for _, record := range records {
+		if to == "" {
+			if skipUntil != "" && !strings.HasPrefix(record.Commit, skipUntil) {
+				continue
+			}
+			skipUntil = ""
+
+			if record.Deploy.Rollback {
+				skipUntil = record.Deploy.RollbackTo
+				continue
+			}
+			if record.Deploy.Image != current {
+				return record, nil
+			}
+			continue
+		}
+
+		if record.Deploy.Image.Tag == to || (len(to) >= 4 && strings.HasPrefix(record.Commit, to)) {
+			if record.Deploy.Image == current {
+				return nil, errors.New(fmt.Sprintf("%s:%s is the current image", current.Repository, current.Tag))
+			}
+			return record, nil
+		}
+	}
+
+	if to == "" {
+		return nil, errors.New("no earlier image found")
+	}
+	return nil, errors.New(fmt.Sprintf("no deploy matching '%s' found", to))
+}
+
+// One line per record
+func Write(w io.Writer, records []*Record) {
+	for _, record := range records {
+		fmt.Fprintf(w, "%s  %s  %-20s %s:%s by %s\n",
+			shortSha(record.Commit),
+			record.Date.Format(time.RFC3339),
+			record.Deploy.Cluster,
+			record.Deploy.Image.Repository,
+			record.Deploy.Image.Tag,
+			record.Deploy.Author,
+		)
+	}
+}
+
+func shortSha(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
@@ -0,0 +1,197 @@
+package history
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/testutils"
+)
+
+func TestList(t *testing.T) {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deploys := []*deploy.DeployOptions{
+		{ID: "1", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Freddie", Image: deploy.DeployOptionsImage{Repository: "a/repo1", Tag: "1.0"}},
+		{ID: "2", TriggerRepo: "github.com/a/repo2", Cluster: "prod", Author: "Freddie", Image: deploy.DeployOptionsImage{Repository: "a/repo2", Tag: "2.0"}},
+		{ID: "3", TriggerRepo: "github.com/a/repo1", Cluster: "staging", Author: "Brian", Image: deploy.DeployOptionsImage{Repository: "a/repo1", Tag: "1.1"}},
+		{ID: "4", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Brian", Image: deploy.DeployOptionsImage{Repository: "a/repo1", Tag: "1.1"}},
+	}
+	for _, dopts := range deploys {
+		if err := gitService.Commit(deploy.CommitMessage(dopts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gitService.Commit("Unrelated change"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Filter   Filter
+		Expected []string
+	}{
+		{Filter{}, []string{"4", "3", "2", "1"}},
+		{Filter{Cluster: "prod"}, []string{"4", "2", "1"}},
+		{Filter{Cluster: "prod", TriggerRepo: "github.com/a/repo1"}, []string{"4", "1"}},
+		{Filter{TriggerRepo: "github.com/a/repo1", Limit: 2}, []string{"4", "3"}},
+		{Filter{Cluster: "nope"}, []string{}},
+	}
+
+	for testIdx, test := range tests {
+		records, err := List(gitService, "HEAD", &test.Filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []string{}
+		for _, record := range records {
+			ids = append(ids, record.Deploy.ID)
+			if record.Commit == "" || record.Date.IsZero() {
+				t.Fatalf("[test %d] Expected commit sha and date, got %+v", testIdx, record)
+			}
+		}
+
+		if len(ids) != len(test.Expected) {
+			t.Fatalf("[test %d] Expected %v, got %v", testIdx, test.Expected, ids)
+		}
+		for i := range ids {
+			if ids[i] != test.Expected[i] {
+				t.Fatalf("[test %d] Expected %v, got %v", testIdx, test.Expected, ids)
+			}
+		}
+	}
+
+	// Reverted deploys are left out
+	if err := gitService.Commit(deploy.RevertMessage("prod", []string{"4"}, errors.New("sync failed"))); err != nil {
+		t.Fatal(err)
+	}
+	records, err := List(gitService, "HEAD", &Filter{Cluster: "prod", TriggerRepo: "github.com/a/repo1"})
+	if err != nil || len(records) != 1 || records[0].Deploy.ID != "1" {
+		t.Fatalf("Expected only deploy 1, got %v (%v)", records, err)
+	}
+}
+
+func TestResolveRollback(t *testing.T) {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := func(tag string) deploy.DeployOptionsImage {
+		return deploy.DeployOptionsImage{Repository: "a/repo1", Tag: tag}
+	}
+	for _, dopts := range []*deploy.DeployOptions{
+		{ID: "1", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Freddie", Image: image("1.0")},
+		{ID: "2", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Freddie", Image: image("1.1")},
+		{ID: "3", TriggerRepo: "github.com/a/repo1", Cluster: "staging", Author: "Brian", Image: image("1.3")},
+		{ID: "4", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Brian", Image: image("1.2")},
+		{ID: "5", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Brian", Image: image("1.2")},
+	} {
+		if err := gitService.Commit(deploy.CommitMessage(dopts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := List(gitService, "HEAD", &Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitOf := map[string]string{}
+	for _, record := range records {
+		commitOf[record.Deploy.ID] = record.Commit
+	}
+
+	tests := []struct {
+		Cluster  string
+		To       string
+		Expected string
+		Error    bool
+	}{
+		{"prod", "", "1.1", false},
+		{"prod", "1.0", "1.0", false},
+		{"prod", commitOf["1"][:8], "1.0", false},
+		{"prod", "1.2", "", true},
+		{"prod", "1.3", "", true},
+		{"prod", "9.9", "", true},
+		{"staging", "", "", true},
+		{"dev", "", "", true},
+	}
+
+	for testIdx, test := range tests {
+		dopts := &deploy.DeployOptions{
+			TriggerRepo: "github.com/a/repo1",
+			Cluster:     test.Cluster,
+			Rollback:    true,
+			RollbackTo:  test.To,
+		}
+
+		err := ResolveRollback(gitService, "HEAD", dopts)
+		if test.Error {
+			if err == nil {
+				t.Fatalf("[test %d] Expected an error, got %+v", testIdx, dopts.Image)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("[test %d] %v", testIdx, err)
+		}
+
+		if dopts.Image != image(test.Expected) {
+			t.Fatalf("[test %d] Expected image tag %s, got %+v", testIdx, test.Expected, dopts.Image)
+		}
+		if len(dopts.RollbackTo) != 40 {
+			t.Fatalf("[test %d] Expected the commit rolled back to, got '%s'", testIdx, dopts.RollbackTo)
+		}
+	}
+}
+
+func TestResolveRollbackTwice(t *testing.T) {
+	repo, _ := testutils.CreateTestRepoWithOrigin(t)
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := func(tag string) deploy.DeployOptionsImage {
+		return deploy.DeployOptionsImage{Repository: "a/repo1", Tag: tag}
+	}
+	for _, dopts := range []*deploy.DeployOptions{
+		{ID: "1", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Freddie", Image: image("1.0")},
+		{ID: "2", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Freddie", Image: image("1.1")},
+		{ID: "3", TriggerRepo: "github.com/a/repo1", Cluster: "prod", Author: "Brian", Image: image("1.2")},
+	} {
+		if err := gitService.Commit(deploy.CommitMessage(dopts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1.2 -> 1.1 -> 1.0, never back to the image rolled back from
+	for _, expected := range []string{"1.1", "1.0"} {
+		dopts := &deploy.DeployOptions{
+			TriggerRepo: "github.com/a/repo1",
+			Cluster:     "prod",
+			Author:      "Roger",
+			Rollback:    true,
+		}
+		if err := ResolveRollback(gitService, "HEAD", dopts); err != nil {
+			t.Fatal(err)
+		}
+		if dopts.Image != image(expected) {
+			t.Fatalf("Expected image tag %s, got %+v", expected, dopts.Image)
+		}
+		if err := gitService.Commit(deploy.CommitMessage(dopts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing deployed before 1.0
+	dopts := &deploy.DeployOptions{TriggerRepo: "github.com/a/repo1", Cluster: "prod", Rollback: true}
+	if err := ResolveRollback(gitService, "HEAD", dopts); err == nil {
+		t.Fatalf("Expected an error, got %+v", dopts.Image)
+	}
+}
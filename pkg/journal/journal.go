@@ -0,0 +1,380 @@
+/*
+ * Journal: keeps track of deploy requests and the stage each one reached
+ * (queued -> committed -> pushed -> syncing -> done/failed). Deploys going
+ * through a pull request are in review between committed and pushed.
+ *
+ * When backed by a file, every change is appended to it as a JSON line, so
+ * the requests that were queued or in flight when `mgo serve` stopped can be
+ * replayed on startup. The file is compacted on startup and as it grows.
+ */
+package journal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/forge"
+)
+
+const (
+	STAGE_QUEUED    = "queued"
+	STAGE_COMMITTED = "committed"
+	STAGE_REVIEW    = "review"
+	STAGE_PUSHED    = "pushed"
+	STAGE_SYNCING   = "syncing"
+	STAGE_DONE      = "done"
+	STAGE_FAILED    = "failed"
+)
+
+const journalFile = "journal.log"
+
+// Finished entries kept around, most recent first
+const maxFinishedEntries = 1000
+
+// The file is rewritten once that many lines were appended since it last was
+const compactAfterLines = 10 * maxFinishedEntries
+
+type Entry struct {
+	ID     string                `json:"id"`
+	Deploy *deploy.DeployOptions `json:"deploy"`
+	Stage  string                `json:"stage"`
+
+	// Sha of the deploy commit, once committed
+	Commit string `json:"commit,omitempty"`
+	// Set for deploys going through a pull request, once opened
+	PullRequest *forge.PullRequest `json:"pullRequest,omitempty"`
+
+	// Set when failed
+	Error string `json:"error,omitempty"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Whether the deploy reached a final stage
+func (e *Entry) Finished() bool {
+	return e.Stage == STAGE_DONE || e.Stage == STAGE_FAILED
+}
+
+type Journal struct {
+	entries map[string]*Entry
+	// IDs, in the order they were queued
+	order []string
+
+	file     *os.File
+	filePath string
+	// Lines appended since the file was last compacted
+	appended int
+	// Overridden in tests
+	compactAfter int
+
+	mutex *sync.Mutex
+}
+
+// Open the journal kept in `stateDir`, creating it if needed. An empty
+// `stateDir` gives an in-memory journal, lost on restart
+func NewJournal(stateDir string) (*Journal, error) {
+	j := &Journal{
+		entries: make(map[string]*Entry),
+		order:   []string{},
+		mutex:   &sync.Mutex{},
+
+		compactAfter: compactAfterLines,
+	}
+
+	if stateDir == "" {
+		return j, nil
+	}
+
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot create state dir %s: %v", stateDir, err))
+	}
+
+	filePath := path.Join(stateDir, journalFile)
+	if err := j.load(filePath); err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot load journal %s: %v", filePath, err))
+	}
+
+	file, err := j.compact(filePath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot compact journal %s: %v", filePath, err))
+	}
+	j.file = file
+	j.filePath = filePath
+
+	return j, nil
+}
+
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Record a new deploy request as queued. An ID is assigned if it has none
+func (j *Journal) Queue(dopts *deploy.DeployOptions) (*Entry, error) {
+	if dopts.ID == "" {
+		dopts.ID = NewID()
+	}
+
+	now := time.Now().UTC()
+	stored := *dopts
+	entry := &Entry{
+		ID:      dopts.ID,
+		Deploy:  &stored,
+		Stage:   STAGE_QUEUED,
+		Created: now,
+		Updated: now,
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, exists := j.entries[entry.ID]; exists {
+		return nil, errors.New(fmt.Sprintf("Deploy %s already queued", entry.ID))
+	}
+
+	if err := j.write(entry); err != nil {
+		return nil, err
+	}
+
+	j.entries[entry.ID] = entry
+	j.order = append(j.order, entry.ID)
+	j.maybeCompact()
+
+	return entry.copy(), nil
+}
+
+// Apply `update` to the entry and persist it
+func (j *Journal) Update(id string, update func(*Entry)) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entry, ok := j.entries[id]
+	if !ok {
+		return errors.New(fmt.Sprintf("No such deploy %s", id))
+	}
+
+	updated := entry.copy()
+	update(updated)
+	updated.Updated = time.Now().UTC()
+
+	if err := j.write(updated); err != nil {
+		return err
+	}
+
+	j.entries[id] = updated
+	if updated.Finished() && !entry.Finished() {
+		j.trim()
+	}
+	j.maybeCompact()
+
+	return nil
+}
+
+// Move the entry to a new stage. A non-nil `err` marks it failed
+func (j *Journal) SetStage(id, stage string, err error) error {
+	return j.Update(id, func(e *Entry) {
+		e.Stage = stage
+		if err != nil {
+			e.Stage = STAGE_FAILED
+			e.Error = err.Error()
+		}
+	})
+}
+
+func (j *Journal) Get(id string) (*Entry, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entry, ok := j.entries[id]
+	if !ok {
+		return nil, false
+	}
+	return entry.copy(), true
+}
+
+// All entries, in the order they were queued
+func (j *Journal) List() []*Entry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entries := []*Entry{}
+	for _, id := range j.order {
+		entries = append(entries, j.entries[id].copy())
+	}
+	return entries
+}
+
+// Entries that didn't reach a final stage, in the order they were queued
+func (j *Journal) Pending() []*Entry {
+	pending := []*Entry{}
+	for _, entry := range j.List() {
+		if !entry.Finished() {
+			pending = append(pending, entry)
+		}
+	}
+	return pending
+}
+
+func (j *Journal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+func (j *Journal) write(entry *Entry) error {
+	if j.file == nil {
+		return nil
+	}
+
+	if err := writeEntry(j.file, entry); err != nil {
+		return err
+	}
+	j.appended++
+
+	return j.file.Sync()
+}
+
+func writeEntry(file *os.File, entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.New(fmt.Sprintf("Cannot write journal: %v", err))
+	}
+	return nil
+}
+
+// Read back the journal. The last line of an entry wins
+func (j *Journal) load(filePath string) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		entry := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil || entry.ID == "" {
+			// Most likely a write cut short by a crash
+			log.Warnf("Journal %s: skipping unreadable line %d", filePath, lineNo)
+			continue
+		}
+
+		if _, exists := j.entries[entry.ID]; !exists {
+			j.order = append(j.order, entry.ID)
+		}
+		j.entries[entry.ID] = entry
+	}
+
+	return scanner.Err()
+}
+
+// Drop the oldest finished entries past maxFinishedEntries
+func (j *Journal) trim() {
+	finished := 0
+	for _, id := range j.order {
+		if j.entries[id].Finished() {
+			finished++
+		}
+	}
+	if finished <= maxFinishedEntries {
+		return
+	}
+
+	kept := make([]string, 0, len(j.order))
+	for _, id := range j.order {
+		if finished > maxFinishedEntries && j.entries[id].Finished() {
+			delete(j.entries, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	j.order = kept
+}
+
+// Compact the file once enough lines were appended to it. Failing to is
+// only logged, the journal keeps being appended to
+func (j *Journal) maybeCompact() {
+	if j.file == nil || j.appended < j.compactAfter {
+		return
+	}
+
+	file, err := j.compact(j.filePath)
+	if err != nil {
+		log.Errorf("Cannot compact journal %s: %v", j.filePath, err)
+		j.appended = 0
+		return
+	}
+
+	j.file.Close()
+	j.file = file
+}
+
+// Rewrite the journal with a single line per entry, dropping the oldest
+// finished entries. Returns the new file, open for appending
+func (j *Journal) compact(filePath string) (*os.File, error) {
+	j.trim()
+
+	tmpPath := filePath + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range j.order {
+		if err := writeEntry(file, j.entries[id]); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	j.appended = 0
+	return file, nil
+}
+
+func (e *Entry) copy() *Entry {
+	c := *e
+	if e.Deploy != nil {
+		dopts := *e.Deploy
+		c.Deploy = &dopts
+	}
+	if e.PullRequest != nil {
+		pr := *e.PullRequest
+		c.PullRequest = &pr
+	}
+	return &c
+}
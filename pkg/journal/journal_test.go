@@ -0,0 +1,171 @@
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+func newDeployOptions(tag string) *deploy.DeployOptions {
+	return &deploy.DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Author:      "Ronaldo",
+		Cluster:     "myprodcluster",
+		Image:       deploy.DeployOptionsImage{Repository: "a/repo1", Tag: tag},
+	}
+}
+
+func TestJournalSurvivesRestart(t *testing.T) {
+	stateDir := t.TempDir()
+
+	j, err := NewJournal(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := j.Queue(newDeployOptions("1.0"))
+	second, _ := j.Queue(newDeployOptions("1.1"))
+	third, _ := j.Queue(newDeployOptions("1.2"))
+
+	j.Update(first.ID, func(e *Entry) {
+		e.Stage = STAGE_COMMITTED
+		e.Commit = "abc"
+	})
+	j.SetStage(first.ID, STAGE_DONE, nil)
+	j.SetStage(second.ID, STAGE_PUSHED, nil)
+	j.SetStage(third.ID, STAGE_SYNCING, errors.New("boom"))
+	j.Close()
+
+	j, err = NewJournal(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	entry, ok := j.Get(first.ID)
+	if !ok || entry.Stage != STAGE_DONE || entry.Commit != "abc" || entry.Deploy.Image.Tag != "1.0" {
+		t.Fatalf("Unexpected entry after restart: %+v", entry)
+	}
+
+	entry, _ = j.Get(third.ID)
+	if entry.Stage != STAGE_FAILED || entry.Error != "boom" {
+		t.Fatalf("Expected a failed entry, got %+v", entry)
+	}
+
+	pending := j.Pending()
+	if len(pending) != 1 || pending[0].ID != second.ID || pending[0].Stage != STAGE_PUSHED {
+		t.Fatalf("Expected only the pushed deploy to be pending, got %+v", pending)
+	}
+
+	// Compacted to one line per entry
+	content, _ := ioutil.ReadFile(path.Join(stateDir, journalFile))
+	if lines := bytes.Count(content, []byte("\n")); lines != 3 {
+		t.Fatalf("Expected 3 lines after compaction, got %d", lines)
+	}
+}
+
+func TestJournalSkipsTruncatedLines(t *testing.T) {
+	stateDir := t.TempDir()
+
+	j, _ := NewJournal(stateDir)
+	entry, _ := j.Queue(newDeployOptions("1.0"))
+	j.Close()
+
+	file, _ := os.OpenFile(path.Join(stateDir, journalFile), os.O_APPEND|os.O_WRONLY, 0600)
+	file.Write([]byte(`{"id":"` + entry.ID + `","stage":"comm`))
+	file.Close()
+
+	j, err := NewJournal(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if got, _ := j.Get(entry.ID); got.Stage != STAGE_QUEUED {
+		t.Fatalf("Expected the truncated update to be ignored, got %+v", got)
+	}
+}
+
+func TestJournalAssignsIDs(t *testing.T) {
+	j, _ := NewJournal("")
+
+	dopts := newDeployOptions("1.0")
+	entry, err := j.Queue(dopts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID == "" || dopts.ID != entry.ID {
+		t.Fatalf("Expected an ID to be assigned, got '%s' / '%s'", entry.ID, dopts.ID)
+	}
+
+	if _, err := j.Queue(dopts); err == nil {
+		t.Fatal("Expected an error queueing the same deploy twice")
+	}
+
+	if err := j.SetStage("nope", STAGE_DONE, nil); err == nil {
+		t.Fatal("Expected an error updating an unknown deploy")
+	}
+}
+
+func TestJournalTrimsFinished(t *testing.T) {
+	j, _ := NewJournal("")
+
+	pending, _ := j.Queue(newDeployOptions("0.0"))
+	first, _ := j.Queue(newDeployOptions("1.0"))
+	j.SetStage(first.ID, STAGE_DONE, nil)
+	for i := 0; i < maxFinishedEntries; i++ {
+		entry, _ := j.Queue(newDeployOptions("1.1"))
+		j.SetStage(entry.ID, STAGE_FAILED, errors.New("boom"))
+	}
+
+	if _, ok := j.Get(first.ID); ok {
+		t.Fatal("Expected the oldest finished deploy to be dropped")
+	}
+	if _, ok := j.Get(pending.ID); !ok {
+		t.Fatal("Expected the pending deploy to be kept")
+	}
+	if entries := j.List(); len(entries) != maxFinishedEntries+1 {
+		t.Fatalf("Expected %d entries, got %d", maxFinishedEntries+1, len(entries))
+	}
+}
+
+func TestJournalCompactsAsItGrows(t *testing.T) {
+	stateDir := t.TempDir()
+
+	j, _ := NewJournal(stateDir)
+	j.compactAfter = 10
+
+	entries := []*Entry{}
+	for i := 0; i < 4; i++ {
+		entry, _ := j.Queue(newDeployOptions("1.0"))
+		entries = append(entries, entry)
+	}
+	for _, stage := range []string{STAGE_COMMITTED, STAGE_PUSHED} {
+		for _, entry := range entries {
+			j.SetStage(entry.ID, stage, nil)
+		}
+	}
+
+	// Compacted on the 10th line, then 2 more appended
+	content, _ := ioutil.ReadFile(path.Join(stateDir, journalFile))
+	if lines := bytes.Count(content, []byte("\n")); lines != 6 {
+		t.Fatalf("Expected 6 lines after compaction, got %d", lines)
+	}
+
+	j.Close()
+	j, err := NewJournal(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	pending := j.Pending()
+	if len(pending) != 4 || pending[3].ID != entries[3].ID || pending[3].Stage != STAGE_PUSHED {
+		t.Fatalf("Expected the pushed deploys back, got %+v", pending)
+	}
+}
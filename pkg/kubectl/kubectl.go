@@ -0,0 +1,30 @@
+package kubectl
+
+import (
+	"errors"
+	"io"
+)
+
+// Returned by Get() when the requested object doesn't exist in the cluster
+var ErrNotFound = errors.New("object not found")
+
+// Abstraction providing kubectl services, used for the manifests that are not
+// managed by helm (the `*-raw.yaml` files, kustomizations) and for inspecting
+// live objects
+type KubectlService interface {
+	Init() error
+	Apply(string) error
+	// Show what applying a manifest file would change
+	Diff(string) error
+	// Build a kustomization directory, returns the resulting manifests
+	Kustomize(string) ([]byte, error)
+
+	// Get a single live object as yaml: kind, namespace, name
+	Get(string, string, string) ([]byte, error)
+	// List live objects of the given kinds in a namespace (all of them when
+	// empty) matching a label selector, as a yaml `List`: kinds, namespace,
+	// selector
+	List([]string, string, string) ([]byte, error)
+
+	SetOutput(io.Writer)
+}
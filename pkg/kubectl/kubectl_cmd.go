@@ -0,0 +1,159 @@
+package kubectl
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/valer-cara/mgo/pkg/util"
+)
+
+// Implements KubectlService interface based on the actual kubectl executable command
+type KubectlCmd struct {
+	kubeconfig  string
+	kubecontext string
+	dryRun      bool
+	env         []string
+	writer      io.Writer
+}
+
+type KubectlCmdOptions struct {
+	Kubeconfig  string
+	Kubecontext string
+	DryRun      bool
+}
+
+func NewKubectlCmd(options *KubectlCmdOptions) *KubectlCmd {
+	return &KubectlCmd{
+		kubeconfig:  options.Kubeconfig,
+		kubecontext: options.Kubecontext,
+		dryRun:      options.DryRun,
+	}
+}
+
+func (k *KubectlCmd) String() string {
+	return fmt.Sprintf("KubectlCmd(context: %s)", k.kubecontext)
+}
+
+func (k *KubectlCmd) Init() error {
+	// XXX: same trick as for helm, make sure kubectl is found by the exec'ed
+	// command even when it's installed in nonstandard locations
+	kubectlBinPath, err := exec.LookPath("kubectl")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Cannot initialize kubectl client (%s). %v", k, err))
+	}
+
+	k.env = []string{
+		"KUBECONFIG=" + k.kubeconfig,
+		"PATH=/bin:/sbin:/usr/bin:/usr/sbin:/usr/local/bin:/usr/local/sbin:" + filepath.Dir(kubectlBinPath),
+	}
+
+	return nil
+}
+
+// Apply a raw kubernetes manifest file
+func (k *KubectlCmd) Apply(file string) error {
+	args := []string{"apply", "--filename", file}
+
+	if k.dryRun {
+		args = append(args, "--dry-run=client")
+	}
+
+	output, err := k.exec(args...)
+	if err != nil {
+		return errors.New(fmt.Sprintf("kubectl apply %s: %v: %s", file, err, strings.TrimSpace(string(output))))
+	}
+
+	return nil
+}
+
+// Diff a manifest file against the cluster. The diff goes to the writer set
+// via SetOutput()
+func (k *KubectlCmd) Diff(file string) error {
+	output, err := k.exec("diff", "--filename", file)
+	if err != nil {
+		// Exit status 1 only means differences were found
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil
+		}
+		return errors.New(fmt.Sprintf("kubectl diff %s: %v: %s", file, err, strings.TrimSpace(string(output))))
+	}
+
+	return nil
+}
+
+func (k *KubectlCmd) Kustomize(dir string) ([]byte, error) {
+	output, err := k.execQuiet("kustomize", dir)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("kubectl kustomize %s: %v: %s", dir, err, strings.TrimSpace(string(output))))
+	}
+
+	return output, nil
+}
+
+func (k *KubectlCmd) Get(kind, namespace, name string) ([]byte, error) {
+	args := []string{"get", kind, name, "--output", "yaml"}
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	}
+
+	output, err := k.execQuiet(args...)
+	if err != nil {
+		if strings.Contains(string(output), "(NotFound)") {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New(fmt.Sprintf("kubectl get %s/%s: %v: %s", kind, name, err, strings.TrimSpace(string(output))))
+	}
+
+	return output, nil
+}
+
+func (k *KubectlCmd) List(kinds []string, namespace, selector string) ([]byte, error) {
+	args := []string{"get", strings.Join(kinds, ","), "--selector", selector, "--output", "yaml"}
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	} else {
+		args = append(args, "--all-namespaces")
+	}
+
+	output, err := k.execQuiet(args...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("kubectl get %s -l %s: %v: %s", strings.Join(kinds, ","), selector, err, strings.TrimSpace(string(output))))
+	}
+
+	return output, nil
+}
+
+func (k *KubectlCmd) SetOutput(w io.Writer) {
+	k.writer = w
+}
+
+// Exec kubectl against the configured context. Output is also written to the
+// writer set via SetOutput()
+func (k *KubectlCmd) exec(args ...string) ([]byte, error) {
+	out, err := k.execQuiet(args...)
+
+	if k.writer != nil {
+		k.writer.Write(out)
+	}
+
+	return out, err
+}
+
+// Same as exec(), without copying the output to the writer. Used for commands
+// whose output is parsed rather than shown
+func (k *KubectlCmd) execQuiet(args ...string) ([]byte, error) {
+	if k.kubecontext != "" {
+		args = append([]string{
+			"--context=" + k.kubecontext,
+		}, args...)
+	}
+
+	log.Debugf("  - running: kubectl %s", strings.Join(args, " "))
+
+	return util.Exec("kubectl", args, k.env)
+}
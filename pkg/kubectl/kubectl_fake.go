@@ -0,0 +1,128 @@
+package kubectl
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Mock Kubectl Service
+// Set the `FailOn*` values to return an error with that message. If not
+// set/empty, the corresponding calls will succeseed
+type KubectlFake struct {
+	FailOnInit      string
+	FailOnApply     string
+	FailOnDiff      string
+	FailOnKustomize string
+	FailOnGet       string
+	FailOnList      string
+
+	// Files passed to Apply() and Diff(), in order
+	Applied []string
+	Diffed  []string
+
+	// Output of Kustomize(), by directory. Directories not listed here build
+	// to an empty output
+	Kustomizations map[string]string
+
+	// Live objects, as yaml, keyed by "kind/namespace/name". Kinds are
+	// matched case insensitively, cluster scoped objects have an empty namespace
+	Objects map[string]string
+}
+
+func (k *KubectlFake) Init() error {
+	if k.FailOnInit != "" {
+		return errors.New(k.FailOnInit)
+	}
+	return nil
+}
+func (k *KubectlFake) Apply(file string) error {
+	if k.FailOnApply != "" {
+		return errors.New(k.FailOnApply)
+	}
+	k.Applied = append(k.Applied, file)
+	return nil
+}
+func (k *KubectlFake) Diff(file string) error {
+	if k.FailOnDiff != "" {
+		return errors.New(k.FailOnDiff)
+	}
+	k.Diffed = append(k.Diffed, file)
+	return nil
+}
+func (k *KubectlFake) Kustomize(dir string) ([]byte, error) {
+	if k.FailOnKustomize != "" {
+		return nil, errors.New(k.FailOnKustomize)
+	}
+	return []byte(k.Kustomizations[dir]), nil
+}
+func (k *KubectlFake) Get(kind, namespace, name string) ([]byte, error) {
+	if k.FailOnGet != "" {
+		return nil, errors.New(k.FailOnGet)
+	}
+	for key, object := range k.Objects {
+		if strings.EqualFold(key, kind+"/"+namespace+"/"+name) {
+			return []byte(object), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Only equality based selectors (`a=b,c=d`) are supported
+func (k *KubectlFake) List(kinds []string, namespace, selector string) ([]byte, error) {
+	if k.FailOnList != "" {
+		return nil, errors.New(k.FailOnList)
+	}
+
+	items := []interface{}{}
+	for key, object := range k.Objects {
+		var parsed struct {
+			Metadata struct {
+				Labels map[string]string
+			}
+		}
+		if err := yaml.Unmarshal([]byte(object), &parsed); err != nil {
+			return nil, err
+		}
+		keyParts := strings.SplitN(key, "/", 3)
+		if !fakeKindIn(keyParts[0], kinds) || !fakeSelectorMatches(selector, parsed.Metadata.Labels) {
+			continue
+		}
+		if namespace != "" && (len(keyParts) < 3 || keyParts[1] != namespace) {
+			continue
+		}
+
+		var item interface{}
+		yaml.Unmarshal([]byte(object), &item)
+		items = append(items, item)
+	}
+
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+}
+func (k *KubectlFake) SetOutput(io.Writer) {
+}
+
+func fakeKindIn(kind string, kinds []string) bool {
+	for _, k := range kinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func fakeSelectorMatches(selector string, labels map[string]string) bool {
+	for _, requirement := range strings.Split(selector, ",") {
+		kv := strings.SplitN(requirement, "=", 2)
+		if len(kv) != 2 || labels[kv[0]] != kv[1] {
+			return false
+		}
+	}
+	return true
+}
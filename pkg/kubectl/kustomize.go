@@ -0,0 +1,29 @@
+package kubectl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Build a kustomization directory into a temp file and hand the file over to
+// fn (eg: Apply, Diff). The file is removed once fn returns
+func WithKustomization(k KubectlService, dir string, fn func(string) error) error {
+	built, err := k.Kustomize(dir)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "mygitops-kustomize-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, filepath.Base(dir)+".yaml")
+	if err := ioutil.WriteFile(file, built, 0600); err != nil {
+		return err
+	}
+
+	return fn(file)
+}
@@ -1,29 +1,32 @@
 package manifest
 
-import (
-	"path"
-	"path/filepath"
-)
-
 type ManifestFileList struct {
 	Helm, Raw []string
+
+	// Directories, not files. Kustomizations included by other ones are left
+	// out, they're applied through those
+	Kustomize []string
 }
 
-func FindManifests(gitopsRepoRoot, cluster string) (*ManifestFileList, error) {
-	globValues := path.Join(gitopsRepoRoot, "/installations/", cluster, "/*-values.yaml")
-	valueFiles, err := filepath.Glob(globValues)
+func FindManifests(layout Layout, gitopsRepoRoot, cluster string) (*ManifestFileList, error) {
+	valueFiles, err := layout.ValueFiles(gitopsRepoRoot, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	rawFiles, err := layout.RawFiles(gitopsRepoRoot, cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	globRaw := path.Join(gitopsRepoRoot, "/installations/", cluster, "/*-raw.yaml")
-	rawFiles, err := filepath.Glob(globRaw)
+	kustomizeDirs, err := layout.KustomizeDirs(gitopsRepoRoot, cluster)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ManifestFileList{
-		Helm: valueFiles,
-		Raw:  rawFiles,
+		Helm:      valueFiles,
+		Raw:       rawFiles,
+		Kustomize: kustomizationRoots(kustomizeDirs),
 	}, nil
 }
@@ -5,8 +5,16 @@ import (
 	"fmt"
 	yaml "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
 
 	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/secrets"
 )
 
 // This handles yaml manifests, specifically the files containing helm values
@@ -25,6 +33,36 @@ type Header struct {
 	// Images map.
 	// XXX: needs documentation
 	Images map[string]HeaderImage
+
+	// Values files shared with other releases, passed to helm before the
+	// release's own values and secrets files, in order. Relative to the
+	// gitops repo root, globs allowed. Eg:
+	//
+	//	valueFiles:
+	//	- installations/common/*.yaml
+	//	- installations/myprodcluster/defaults.yaml
+	ValueFiles []string `yaml:"valueFiles,omitempty"`
+
+	// Optional sync ordering. Releases are synced by ascending wave, 0 by
+	// default, and each only after the releases it depends on, by name or
+	// `namespace/name`. Eg:
+	//
+	//	wave: 1
+	//	dependsOn:
+	//	- cert-manager
+	//	- databases/postgres
+	Wave      int      `yaml:"wave,omitempty"`
+	DependsOn []string `yaml:"dependsOn,omitempty"`
+
+	// Optional. After each sync, wait up to this long for the release's
+	// Deployments, StatefulSets, DaemonSets and Jobs to roll out, eg: `5m`.
+	// The sync fails if they don't
+	ReadyTimeout string `yaml:"readyTimeout,omitempty"`
+
+	// Revert the deploys of the release when syncing its cluster fails, then
+	// sync again. Also set for whole clusters by `autoRollback` in
+	// `mygitops.yaml`
+	AutoRollback bool `yaml:"autoRollback,omitempty"`
 }
 
 type HeaderImage struct {
@@ -34,6 +72,72 @@ type HeaderImage struct {
 
 	// Or image
 	Image string `yaml:"image,omitempty"`
+
+	// Optional, the digest the tag pointed to when deployed
+	Digest string `yaml:"digest,omitempty"`
+
+	// Optional. Keep the image up to date with its registry, see `mgo serve`
+	AutoUpdate *AutoUpdate `yaml:"autoUpdate,omitempty"`
+
+	// Optional policy on the tags deployed, see CheckTag()
+	//
+	// Version constraint the tags should satisfy, eg: `^1.2`
+	Semver string `yaml:"semver,omitempty"`
+	// Regular expressions, tags should match one of them
+	AllowTags []string `yaml:"allowTags,omitempty"`
+	// Refuse tags lower than the current one. Needs semver tags
+	DenyDowngrade bool `yaml:"denyDowngrade,omitempty"`
+	// Refuse any new tag, eg: during an incident
+	Frozen bool `yaml:"frozen,omitempty"`
+}
+
+const (
+	AUTO_UPDATE_SEMVER = "semver"
+	AUTO_UPDATE_REGEX  = "regex"
+	AUTO_UPDATE_LATEST = "latest"
+)
+
+// Which tag an image moves to when new ones are pushed. Eg:
+//
+//	autoUpdate:
+//	  policy: semver
+//	  semver: "~1.2"
+type AutoUpdate struct {
+	// `semver`: the highest version satisfying `semver`
+	// `regex`: the greatest tag matching `pattern`, comparing the first
+	// capture group as a number when there's one, eg: `^main-(\d+)-`
+	// `latest`: the most recently built tag, among those matching `pattern`
+	// if set. The `latest` tag itself is never picked
+	Policy string
+
+	// Version constraint, eg: `>=1.0 <2`. Empty means any release
+	Semver string
+	// Tags considered, a regular expression
+	Pattern string
+}
+
+func (a *AutoUpdate) Validate() error {
+	switch a.Policy {
+	case AUTO_UPDATE_SEMVER:
+		if a.Semver != "" {
+			if _, err := semver.NewConstraint(a.Semver); err != nil {
+				return errors.New(fmt.Sprintf("bad `semver` constraint `%s`: %v", a.Semver, err))
+			}
+		}
+	case AUTO_UPDATE_REGEX:
+		if a.Pattern == "" {
+			return errors.New("the `regex` policy needs a `pattern`")
+		}
+	case AUTO_UPDATE_LATEST:
+	default:
+		return errors.New(fmt.Sprintf("unknown policy `%s`. Should be `%s`, `%s` or `%s`", a.Policy, AUTO_UPDATE_SEMVER, AUTO_UPDATE_REGEX, AUTO_UPDATE_LATEST))
+	}
+
+	if _, err := regexp.Compile(a.Pattern); err != nil {
+		return errors.New(fmt.Sprintf("bad `pattern`: %v", err))
+	}
+
+	return nil
 }
 
 func (h *Header) Validate() error {
@@ -58,20 +162,123 @@ func (h *Header) Validate() error {
 		return errors.New(pre + ": `namespace` is empty/missing. Should be the release namespace")
 	}
 
+	for triggerRepo, image := range h.Images {
+		if err := image.ValidatePolicy(); err != nil {
+			return errors.New(fmt.Sprintf("%s: `images.%s`: %v", pre, triggerRepo, err))
+		}
+		if image.AutoUpdate == nil {
+			continue
+		}
+		if err := image.AutoUpdate.Validate(); err != nil {
+			return errors.New(fmt.Sprintf("%s: `images.%s.autoUpdate`: %v", pre, triggerRepo, err))
+		}
+	}
+
+	if _, err := h.ReadyWait(); err != nil {
+		return errors.New(fmt.Sprintf("%s: %v", pre, err))
+	}
+
+	for _, dependency := range h.DependsOn {
+		if dependency == "" || strings.Count(dependency, "/") > 1 {
+			return errors.New(fmt.Sprintf("%s: `dependsOn`: bad release `%s`. Should be `name` or `namespace/name`", pre, dependency))
+		}
+	}
+
+	for _, pattern := range h.ValueFiles {
+		if err := validateValueFile(pattern); err != nil {
+			return errors.New(fmt.Sprintf("%s: `valueFiles`: `%s` %v", pre, pattern, err))
+		}
+	}
+
 	return nil
 }
 
-func ParseHeader(path string) (*Header, error) {
-	var parsed HelmBasic
+// How long to wait for the release to roll out after a sync, 0 when it isn't
+// waited for
+func (h *Header) ReadyWait() (time.Duration, error) {
+	if h.ReadyTimeout == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(h.ReadyTimeout)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New(fmt.Sprintf("bad `readyTimeout` `%s`. Should be a duration, eg: `5m`", h.ReadyTimeout))
+	}
+	return timeout, nil
+}
+
+func validateValueFile(pattern string) error {
+	if pattern == "" {
+		return errors.New("is empty")
+	}
+	if filepath.IsAbs(pattern) {
+		return errors.New("should be relative to the gitops repo root")
+	}
+	if clean := filepath.Clean(pattern); clean == ".." || strings.HasPrefix(clean, "../") {
+		return errors.New("is outside the gitops repo")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return err
+	}
+	return nil
+}
 
+// The value files helm gets for the release in valuesFile: the `valueFiles`
+// of the header, then valuesFile itself and its secrets file if there's one.
+// Each file is listed once, the first time it's matched
+func (h *Header) ResolveValueFiles(gitopsRepoRoot, valuesFile string) ([]string, error) {
+	var (
+		files []string
+		seen  = map[string]bool{}
+		own   = []string{valuesFile}
+	)
+
+	if secretsFile := secrets.SecretsFile(valuesFile); secretsFile != "" {
+		if _, err := os.Stat(secretsFile); err == nil {
+			own = append(own, secretsFile)
+		}
+	}
+	for _, file := range own {
+		seen[file] = true
+	}
+
+	for _, pattern := range h.ValueFiles {
+		matches, err := filepath.Glob(filepath.Join(gitopsRepoRoot, pattern))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, errors.New(fmt.Sprintf("`valueFiles`: no file matches `%s`", pattern))
+		}
+
+		for _, file := range matches {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	return append(files, own...), nil
+}
+
+func ParseHeader(path string) (*Header, error) {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = yaml.Unmarshal(file, &parsed)
+	return ParseHeaderContent(path, file)
+}
+
+// Same as ParseHeader, for content read elsewhere (eg: from git). `name` is
+// only used in errors
+func ParseHeaderContent(name string, content []byte) (*Header, error) {
+	var parsed HelmBasic
+
+	err := yaml.Unmarshal(content, &parsed)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("YAML Unmarshal error: %s: %v", path, err))
+		return nil, errors.New(fmt.Sprintf("YAML Unmarshal error: %s: %v", name, err))
 	}
 
 	return parsed.Chart, nil
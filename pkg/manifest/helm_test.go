@@ -0,0 +1,74 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/helm"
+)
+
+func TestResolveValueFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{
+		"installations/common/a.yaml",
+		"installations/common/b.yaml",
+		"installations/prod/defaults.yaml",
+		"installations/prod/redis-values.yaml",
+		"installations/prod/redis-secrets.yaml",
+	} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755)
+		ioutil.WriteFile(filepath.Join(root, file), []byte("foo: bar\n"), 0644)
+	}
+	valuesFile := filepath.Join(root, "installations/prod/redis-values.yaml")
+
+	header := &Header{ValueFiles: []string{
+		"installations/common/*.yaml",
+		"installations/prod/defaults.yaml",
+		// Already listed
+		"installations/common/b.yaml",
+		"installations/prod/redis-*.yaml",
+	}}
+
+	files, err := header.ResolveValueFiles(root, valuesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(root, "installations/common/a.yaml"),
+		filepath.Join(root, "installations/common/b.yaml"),
+		filepath.Join(root, "installations/prod/defaults.yaml"),
+		valuesFile,
+		filepath.Join(root, "installations/prod/redis-secrets.yaml"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected %v, got %v", expected, files)
+	}
+
+	if files, err := (&Header{}).ResolveValueFiles(root, valuesFile); err != nil || len(files) != 2 {
+		t.Fatalf("Expected the values and secrets files only, got %v (%v)", files, err)
+	}
+
+	header = &Header{ValueFiles: []string{"installations/nope/*.yaml"}}
+	if _, err := header.ResolveValueFiles(root, valuesFile); err == nil {
+		t.Fatal("Expected an error when no file matches")
+	}
+}
+
+func TestHeaderValidateValueFiles(t *testing.T) {
+	release := helm.HelmRelease{Chart: "stable/redis", Version: "1.0.0", Name: "redis", Namespace: "default"}
+
+	if err := (&Header{HelmRelease: release, ValueFiles: []string{"installations/common/*.yaml"}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, valueFile := range []string{"", "/etc/passwd", "../other/values.yaml", "installations/../../values.yaml", "installations/[.yaml"} {
+		header := &Header{HelmRelease: release, ValueFiles: []string{valueFile}}
+		if err := header.Validate(); err == nil {
+			t.Fatalf("Expected `%s` to be refused", valueFile)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// File names kustomize recognizes, in its order of preference
+var kustomizationFileNames = []string{
+	"kustomization.yaml",
+	"kustomization.yml",
+	"Kustomization",
+}
+
+// Path to the kustomization file in dir, empty if dir is not a kustomization
+func KustomizationFile(dir string) string {
+	for _, name := range kustomizationFileNames {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// Keep only the paths that are kustomization directories
+func filterKustomizationDirs(paths []string) []string {
+	var dirs []string
+
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		if KustomizationFile(path) != "" {
+			dirs = append(dirs, path)
+		}
+	}
+
+	return dirs
+}
+
+// Kustomization directories below root, at any depth
+func walkKustomizationDirs(root string) ([]string, error) {
+	var dirs []string
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != root && KustomizationFile(path) != "" {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dirs, nil
+}
+
+// Leave out the kustomizations that other ones include. Those are built as
+// part of the including ones, applying them on their own would be redundant
+// at best
+func kustomizationRoots(dirs []string) []string {
+	included := map[string]bool{}
+
+	for _, dir := range dirs {
+		for _, resource := range kustomizationResources(dir) {
+			included[filepath.Join(dir, resource)] = true
+		}
+	}
+
+	var roots []string
+	for _, dir := range dirs {
+		if !included[filepath.Clean(dir)] {
+			roots = append(roots, dir)
+		}
+	}
+
+	return roots
+}
+
+// What a kustomization pulls in: files, directories and remote urls alike.
+// An unreadable kustomization is left for `kubectl kustomize` to complain about
+func kustomizationResources(dir string) []string {
+	var kustomization struct {
+		Resources  []string
+		Bases      []string
+		Components []string
+	}
+
+	content, err := ioutil.ReadFile(KustomizationFile(dir))
+	if err != nil {
+		return nil
+	}
+	if err := yaml.Unmarshal(content, &kustomization); err != nil {
+		return nil
+	}
+
+	resources := append(kustomization.Resources, kustomization.Bases...)
+	return append(resources, kustomization.Components...)
+}
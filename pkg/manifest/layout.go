@@ -0,0 +1,167 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+const (
+	LAYOUT_DEFAULT = "default"
+	LAYOUT_CUSTOM  = "custom"
+)
+
+// Layout knows where the files of each cluster live in a gitops repo. Both the
+// manifest finder and the deploy updater go through it, so adopting mgo
+// doesn't mean restructuring an existing repo.
+type Layout interface {
+	// Helm values files (those having the `__mygitops` header) of a cluster
+	ValueFiles(gitopsRepoRoot, cluster string) ([]string, error)
+	// Raw kubernetes manifests of a cluster
+	RawFiles(gitopsRepoRoot, cluster string) ([]string, error)
+	// Kustomization directories (bases or overlays) of a cluster
+	KustomizeDirs(gitopsRepoRoot, cluster string) ([]string, error)
+}
+
+// Layout section in `mygitops.yaml`. Eg:
+//
+//	layout:
+//	  type: custom
+//	  values: envs/*/{{.Cluster}}/*/values.yaml
+//	  raw: envs/*/{{.Cluster}}/*/raw.yaml
+//	  kustomize: envs/*/{{.Cluster}}/*/overlay
+type LayoutConfig struct {
+	// `default` or `custom`. Empty means `default`
+	Type string
+
+	// Used by the `custom` layout: glob patterns relative to the repo root.
+	// `{{.Cluster}}` is replaced by the cluster name
+	Values string
+	Raw    string
+
+	// Directories matching this pattern that hold a kustomization file
+	Kustomize string
+}
+
+func NewLayout(config *LayoutConfig) (Layout, error) {
+	switch config.Type {
+	case "", LAYOUT_DEFAULT:
+		return &DefaultLayout{}, nil
+	case LAYOUT_CUSTOM:
+		return NewCustomLayout(config.Values, config.Raw, config.Kustomize)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown layout type `%s`. Should be `%s` or `%s`", config.Type, LAYOUT_DEFAULT, LAYOUT_CUSTOM))
+	}
+}
+
+// The mygitops convention:
+//
+//	installations/<cluster>/*-values.yaml
+//	installations/<cluster>/*-raw.yaml
+//	installations/<cluster>/<name>/kustomization.yaml
+//
+// Kustomizations can be nested at any depth below the cluster directory
+type DefaultLayout struct{}
+
+func (l *DefaultLayout) ValueFiles(gitopsRepoRoot, cluster string) ([]string, error) {
+	return filepath.Glob(filepath.Join(gitopsRepoRoot, "installations", cluster, "*-values.yaml"))
+}
+
+func (l *DefaultLayout) RawFiles(gitopsRepoRoot, cluster string) ([]string, error) {
+	return filepath.Glob(filepath.Join(gitopsRepoRoot, "installations", cluster, "*-raw.yaml"))
+}
+
+func (l *DefaultLayout) KustomizeDirs(gitopsRepoRoot, cluster string) ([]string, error) {
+	return walkKustomizationDirs(filepath.Join(gitopsRepoRoot, "installations", cluster))
+}
+
+func (l *DefaultLayout) String() string {
+	return "installations/<cluster>/*-values.yaml"
+}
+
+// Layout defined by glob templates, see LayoutConfig
+type CustomLayout struct {
+	values    *template.Template
+	raw       *template.Template
+	kustomize *template.Template
+}
+
+func NewCustomLayout(values, raw, kustomize string) (*CustomLayout, error) {
+	var err error
+
+	if values == "" {
+		return nil, errors.New("Custom layout: `values` pattern is missing")
+	}
+
+	l := &CustomLayout{}
+
+	if l.values, err = template.New("values").Option("missingkey=error").Parse(values); err != nil {
+		return nil, errors.New(fmt.Sprintf("Custom layout: bad `values` pattern: %v", err))
+	}
+
+	// Raw manifests and kustomizations are optional
+	if raw != "" {
+		if l.raw, err = template.New("raw").Option("missingkey=error").Parse(raw); err != nil {
+			return nil, errors.New(fmt.Sprintf("Custom layout: bad `raw` pattern: %v", err))
+		}
+	}
+
+	if kustomize != "" {
+		if l.kustomize, err = template.New("kustomize").Option("missingkey=error").Parse(kustomize); err != nil {
+			return nil, errors.New(fmt.Sprintf("Custom layout: bad `kustomize` pattern: %v", err))
+		}
+	}
+
+	return l, nil
+}
+
+func (l *CustomLayout) ValueFiles(gitopsRepoRoot, cluster string) ([]string, error) {
+	return globTemplate(l.values, gitopsRepoRoot, cluster)
+}
+
+func (l *CustomLayout) RawFiles(gitopsRepoRoot, cluster string) ([]string, error) {
+	if l.raw == nil {
+		return nil, nil
+	}
+	return globTemplate(l.raw, gitopsRepoRoot, cluster)
+}
+
+func (l *CustomLayout) KustomizeDirs(gitopsRepoRoot, cluster string) ([]string, error) {
+	if l.kustomize == nil {
+		return nil, nil
+	}
+
+	dirs, err := globTemplate(l.kustomize, gitopsRepoRoot, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return filterKustomizationDirs(dirs), nil
+}
+
+func (l *CustomLayout) String() string {
+	return l.values.Root.String()
+}
+
+type layoutTemplateData struct {
+	Cluster string
+}
+
+func globTemplate(tpl *template.Template, gitopsRepoRoot, cluster string) ([]string, error) {
+	var pattern bytes.Buffer
+
+	if err := tpl.Execute(&pattern, layoutTemplateData{Cluster: cluster}); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(gitopsRepoRoot, pattern.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
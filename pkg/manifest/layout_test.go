@@ -0,0 +1,131 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultLayout(t *testing.T) {
+	root, _ := filepath.Abs("../../tests/minimal-gitops-repo")
+
+	layout, err := NewLayout(&LayoutConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := FindManifests(layout, root, "myprodcluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRaw := []string{filepath.Join(root, "installations/myprodcluster/one-configmap-raw.yaml")}
+	if !reflect.DeepEqual(manifests.Raw, expectedRaw) {
+		t.Fatalf("Expected raw files %v, got %v", expectedRaw, manifests.Raw)
+	}
+	if len(manifests.Helm) != 2 {
+		t.Fatalf("Expected 2 value files, got %v", manifests.Helm)
+	}
+}
+
+func TestDefaultLayoutKustomizations(t *testing.T) {
+	root := t.TempDir()
+	for file, content := range map[string]string{
+		"installations/prod/web/base/kustomization.yaml":    "resources:\n- deployment.yaml\n",
+		"installations/prod/web/base/deployment.yaml":       "kind: Deployment\n",
+		"installations/prod/web/overlay/kustomization.yaml": "resources:\n- ../base\n",
+		"installations/prod/api/envs/eu/kustomization.yaml": "resources:\n- service.yaml\n",
+		"installations/prod/api/envs/eu/service.yaml":       "kind: Service\n",
+		"installations/prod/legacy/kustomization.yaml":      "bases:\n- ../web/base\n",
+		"installations/prod/api/envs/notes.txt":             "not a kustomization\n",
+	} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755)
+		ioutil.WriteFile(filepath.Join(root, file), []byte(content), 0644)
+	}
+
+	layout, _ := NewLayout(&LayoutConfig{})
+
+	// The updater sees every kustomization, bases included
+	dirs, err := layout.KustomizeDirs(root, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(root, "installations/prod/api/envs/eu"),
+		filepath.Join(root, "installations/prod/legacy"),
+		filepath.Join(root, "installations/prod/web/base"),
+		filepath.Join(root, "installations/prod/web/overlay"),
+	}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, dirs)
+	}
+
+	// Only the roots get applied
+	manifests, err := FindManifests(layout, root, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		filepath.Join(root, "installations/prod/api/envs/eu"),
+		filepath.Join(root, "installations/prod/legacy"),
+		filepath.Join(root, "installations/prod/web/overlay"),
+	}
+	if !reflect.DeepEqual(manifests.Kustomize, expected) {
+		t.Fatalf("Expected %v, got %v", expected, manifests.Kustomize)
+	}
+
+	// No cluster directory, nothing to apply
+	if dirs, err := layout.KustomizeDirs(root, "dev"); err != nil || len(dirs) != 0 {
+		t.Fatalf("Expected no kustomizations, got %v (%v)", dirs, err)
+	}
+}
+
+func TestCustomLayout(t *testing.T) {
+	root, _ := filepath.Abs("../../tests/custom-layout-gitops-repo")
+
+	layout, err := NewLayout(&LayoutConfig{
+		Type:      LAYOUT_CUSTOM,
+		Values:    "envs/*/{{.Cluster}}/*/values.yaml",
+		Raw:       "envs/*/{{.Cluster}}/*/raw.yaml",
+		Kustomize: "envs/*/{{.Cluster}}/*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := FindManifests(layout, root, "myprodcluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &ManifestFileList{
+		Helm:      []string{filepath.Join(root, "envs/prod/myprodcluster/app/values.yaml")},
+		Raw:       []string{filepath.Join(root, "envs/prod/myprodcluster/app/raw.yaml")},
+		Kustomize: []string{filepath.Join(root, "envs/prod/myprodcluster/web")},
+	}
+	if !reflect.DeepEqual(manifests, expected) {
+		t.Fatalf("Expected %v, got %v", expected, manifests)
+	}
+}
+
+func TestLayoutConfigErrors(t *testing.T) {
+	configs := []*LayoutConfig{
+		{Type: "nope"},
+		{Type: LAYOUT_CUSTOM},
+		{Type: LAYOUT_CUSTOM, Values: "envs/{{.Cluster"},
+		{Type: LAYOUT_CUSTOM, Values: "envs/*/values.yaml", Raw: "{{end}}"},
+	}
+
+	for _, config := range configs {
+		if _, err := NewLayout(config); err == nil {
+			t.Errorf("Expected an error for layout config %+v", config)
+		}
+	}
+
+	layout, _ := NewLayout(&LayoutConfig{Type: LAYOUT_CUSTOM, Values: "envs/{{.Env}}/values.yaml"})
+	if _, err := layout.ValueFiles("/tmp", "foo"); err == nil {
+		t.Error("Expected an error for unknown template fields")
+	}
+}
@@ -0,0 +1,99 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/valer-cara/mgo/pkg/reference"
+)
+
+// The image currently deployed, from `repository`/`tag` or `image`
+func (i *HeaderImage) Current() (string, string) {
+	repository, tag := i.Repository, i.Tag
+
+	if i.Image != "" {
+		image := reference.Split(i.Image)
+		if repository == "" {
+			repository = image.Repository
+		}
+		if tag == "" {
+			tag = image.Tag
+		}
+	}
+
+	return repository, tag
+}
+
+func (i *HeaderImage) ValidatePolicy() error {
+	if i.Semver != "" {
+		if _, err := semver.NewConstraint(i.Semver); err != nil {
+			return errors.New(fmt.Sprintf("bad `semver` constraint `%s`: %v", i.Semver, err))
+		}
+	}
+
+	for _, pattern := range i.AllowTags {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.New(fmt.Sprintf("bad `allowTags` pattern `%s`: %v", pattern, err))
+		}
+	}
+
+	return nil
+}
+
+// Why the image can't be deployed with `tag`, nil when it can. Rollbacks may
+// go back to lower tags, but are held by the other rules
+func (i *HeaderImage) CheckTag(tag string, rollback bool) error {
+	_, current := i.Current()
+
+	if i.Frozen && tag != current {
+		return errors.New(fmt.Sprintf("the image is frozen at `%s`", current))
+	}
+
+	if i.Semver != "" {
+		constraint, err := semver.NewConstraint(i.Semver)
+		if err != nil {
+			return err
+		}
+		version, err := semver.NewVersion(tag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("`%s` is not a semantic version, as `semver: %s` requires", tag, i.Semver))
+		}
+		if !constraint.Check(version) {
+			return errors.New(fmt.Sprintf("`%s` doesn't satisfy `semver: %s`", tag, i.Semver))
+		}
+	}
+
+	if len(i.AllowTags) > 0 && !matchAny(i.AllowTags, tag) {
+		return errors.New(fmt.Sprintf("`%s` matches none of `allowTags`", tag))
+	}
+
+	if i.DenyDowngrade && !rollback && current != "" && tag != current {
+		currentVersion, err := semver.NewVersion(current)
+		if err != nil {
+			// Can't tell what's lower than a tag like `main-abcdef`
+			return nil
+		}
+
+		version, err := semver.NewVersion(tag)
+		if err != nil {
+			return errors.New(fmt.Sprintf("`%s` is not a semantic version, it may be older than `%s` (`denyDowngrade`)", tag, current))
+		}
+		if version.LessThan(currentVersion) {
+			return errors.New(fmt.Sprintf("`%s` is lower than the current `%s` (`denyDowngrade`)", tag, current))
+		}
+	}
+
+	return nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matched, err := regexp.MatchString(pattern, s); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package manifest
+
+import "testing"
+
+func TestCheckTag(t *testing.T) {
+	tests := []struct {
+		Image    HeaderImage
+		Tag      string
+		Rollback bool
+		Allowed  bool
+	}{
+		{HeaderImage{Tag: "1.0.0"}, "latest", false, true},
+		{HeaderImage{Tag: "1.0.0", Frozen: true}, "1.0.1", false, false},
+		{HeaderImage{Tag: "1.0.0", Frozen: true}, "1.0.0", false, true},
+		{HeaderImage{Image: "a/b:1.0.0", Frozen: true}, "0.9.0", true, false},
+		{HeaderImage{Tag: "1.0.0", Semver: "~1.0"}, "1.0.7", false, true},
+		{HeaderImage{Tag: "1.0.0", Semver: "~1.0"}, "1.1.0", false, false},
+		{HeaderImage{Tag: "1.0.0", Semver: "~1.0"}, "main", false, false},
+		{HeaderImage{Tag: "1.0.0", AllowTags: []string{`^v?\d`, `^hotfix-`}}, "hotfix-1", false, true},
+		{HeaderImage{Tag: "1.0.0", AllowTags: []string{`^v?\d`, `^hotfix-`}}, "latest", false, false},
+		{HeaderImage{Tag: "1.2.0", DenyDowngrade: true}, "1.10.0", false, true},
+		{HeaderImage{Tag: "1.2.0", DenyDowngrade: true}, "1.1.9", false, false},
+		{HeaderImage{Tag: "1.2.0", DenyDowngrade: true}, "1.1.9", true, true},
+		{HeaderImage{Tag: "1.2.0", DenyDowngrade: true}, "latest", false, false},
+		// Nothing to compare with
+		{HeaderImage{Tag: "main-abcdef", DenyDowngrade: true}, "main-012345", false, true},
+	}
+
+	for testIdx, test := range tests {
+		err := test.Image.CheckTag(test.Tag, test.Rollback)
+		if test.Allowed != (err == nil) {
+			t.Fatalf("[test %d] Expected allowed=%v, got %v", testIdx, test.Allowed, err)
+		}
+	}
+
+	if err := (&HeaderImage{AllowTags: []string{"("}}).ValidatePolicy(); err == nil {
+		t.Fatal("Expected an error for a bad pattern")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Reference: image references as the OCI distribution spec has them:
+ *
+ *   [host[:port]/]path[:tag][@algorithm:digest]
+ *
+ * eg: `registry:5000/foo/bar:1.0@sha256:0123...`. The tag is only looked for
+ * after the last slash, so a registry port is never taken for one.
+ */
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	hostPattern      = regexp.MustCompile(`^(localhost|[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)*)(:[0-9]+)?$`)
+	componentPattern = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*$`)
+	tagPattern       = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	digestPattern    = regexp.MustCompile(`^[a-z0-9]+([+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+)
+
+type Reference struct {
+	Repository string
+	// Either may be empty
+	Tag    string
+	Digest string
+}
+
+// Split a reference without checking it
+func Split(ref string) Reference {
+	r := Reference{Repository: ref}
+
+	if at := strings.Index(r.Repository, "@"); at >= 0 {
+		r.Repository, r.Digest = r.Repository[:at], r.Repository[at+1:]
+	}
+
+	if colon := strings.LastIndex(r.Repository, ":"); colon > strings.LastIndex(r.Repository, "/") {
+		r.Repository, r.Tag = r.Repository[:colon], r.Repository[colon+1:]
+	}
+
+	return r
+}
+
+// Split and check a reference
+func Parse(ref string) (Reference, error) {
+	r := Split(ref)
+
+	err := r.Validate()
+	if err == nil && r.String() != ref {
+		err = errors.New("empty tag or digest")
+	}
+	if err != nil {
+		return Reference{}, errors.New(fmt.Sprintf("invalid image reference `%s`: %v", ref, err))
+	}
+	return r, nil
+}
+
+func (r Reference) Validate() error {
+	if err := ValidateRepository(r.Repository); err != nil {
+		return err
+	}
+	if r.Tag != "" && !tagPattern.MatchString(r.Tag) {
+		return errors.New(fmt.Sprintf("bad tag `%s`", r.Tag))
+	}
+	if r.Digest != "" && !digestPattern.MatchString(r.Digest) {
+		return errors.New(fmt.Sprintf("bad digest `%s`", r.Digest))
+	}
+	return nil
+}
+
+// Check `[host[:port]/]path`
+func ValidateRepository(repository string) error {
+	if repository == "" {
+		return errors.New("empty repository")
+	}
+
+	components := strings.Split(repository, "/")
+	if len(components) > 1 && (strings.ContainsAny(components[0], ".:") || components[0] == "localhost") {
+		if !hostPattern.MatchString(components[0]) {
+			return errors.New(fmt.Sprintf("bad registry host `%s`", components[0]))
+		}
+		components = components[1:]
+	}
+
+	for _, component := range components {
+		if !componentPattern.MatchString(component) {
+			return errors.New(fmt.Sprintf("bad repository `%s`", repository))
+		}
+	}
+	return nil
+}
+
+// `repository[:tag][@digest]`
+func (r Reference) String() string {
+	s := r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package reference
+
+import "testing"
+
+const testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		Ref      string
+		Expected Reference
+	}{
+		{"redis", Reference{Repository: "redis"}},
+		{"foo/bar:1.0", Reference{Repository: "foo/bar", Tag: "1.0"}},
+		{"registry:5000/foo/bar", Reference{Repository: "registry:5000/foo/bar"}},
+		{"registry:5000/foo/bar:1.0", Reference{Repository: "registry:5000/foo/bar", Tag: "1.0"}},
+		{"localhost/foo_bar/web-app.v2:main-1", Reference{Repository: "localhost/foo_bar/web-app.v2", Tag: "main-1"}},
+		{"ghcr.io/foo/bar@" + testDigest, Reference{Repository: "ghcr.io/foo/bar", Digest: testDigest}},
+		{"registry:5000/foo/bar:1.0@" + testDigest, Reference{Repository: "registry:5000/foo/bar", Tag: "1.0", Digest: testDigest}},
+	}
+
+	for _, test := range tests {
+		ref, err := Parse(test.Ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref != test.Expected {
+			t.Fatalf("%s: expected %+v, got %+v", test.Ref, test.Expected, ref)
+		}
+		if ref.String() != test.Ref {
+			t.Fatalf("Expected %s back, got %s", test.Ref, ref.String())
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, ref := range []string{
+		"",
+		":1.0",
+		"Foo/bar",
+		"foo//bar",
+		"foo/bar:",
+		"foo/bar:-1",
+		"foo/bar:1.0@",
+		"foo/bar@sha256:short",
+		"registry:port/foo/bar",
+		"foo/bar:1.0 ",
+	} {
+		if _, err := Parse(ref); err == nil {
+			t.Fatalf("Expected `%s` to be invalid", ref)
+		}
+	}
+}
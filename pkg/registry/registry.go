@@ -0,0 +1,382 @@
+/*
+ * Registry: a small client for the OCI distribution (Docker registry v2) API,
+ * enough to list the tags of an image, tell when they were built and which
+ * digest they point to.
+ *
+ * Registries asking for a token (Docker Hub, GHCR, Harbor...) are handled
+ * through their `WWW-Authenticate` challenge, with the credentials set for the
+ * host, if any.
+ */
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where images without a registry host live
+const DOCKERHUB_HOST = "registry-1.docker.io"
+
+const (
+	MEDIA_TYPE_DOCKER_MANIFEST      = "application/vnd.docker.distribution.manifest.v2+json"
+	MEDIA_TYPE_DOCKER_MANIFEST_LIST = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MEDIA_TYPE_OCI_MANIFEST         = "application/vnd.oci.image.manifest.v1+json"
+	MEDIA_TYPE_OCI_INDEX            = "application/vnd.oci.image.index.v1+json"
+)
+
+// Accepted manifests, multi-platform ones included
+var manifestTypes = strings.Join([]string{
+	MEDIA_TYPE_DOCKER_MANIFEST,
+	MEDIA_TYPE_DOCKER_MANIFEST_LIST,
+	MEDIA_TYPE_OCI_MANIFEST,
+	MEDIA_TYPE_OCI_INDEX,
+}, ", ")
+
+// Largest response read
+const maxResponse = 10 << 20
+
+// Registries section in `mygitops.yaml`, eg:
+//
+//	registries:
+//	  - host: registry.example.com
+//	    username: mgo
+//	    password: ${MGO_REGISTRY_PASSWORD}
+type RegistryConfig struct {
+	Host string
+
+	// Optional. `${VAR}` is replaced by the environment variable
+	Username string
+	Password string
+
+	// Talk plain HTTP, eg: to a registry on localhost
+	Insecure bool
+}
+
+type Client struct {
+	httpClient *http.Client
+	registries map[string]RegistryConfig
+
+	// Bearer tokens by host and scope
+	tokensMu sync.Mutex
+	tokens   map[string]string
+}
+
+func NewClient(registries []RegistryConfig) *Client {
+	c := &Client{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		registries: make(map[string]RegistryConfig),
+		tokens:     make(map[string]string),
+	}
+
+	for _, registry := range registries {
+		registry.Username = os.ExpandEnv(registry.Username)
+		registry.Password = os.ExpandEnv(registry.Password)
+		c.registries[registry.Host] = registry
+	}
+
+	return c
+}
+
+// The registry host and the repository path on it, eg:
+// `ghcr.io/foo/bar` -> `ghcr.io`, `foo/bar`
+// `redis` -> `registry-1.docker.io`, `library/redis`
+func SplitRepository(repository string) (string, string) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		if parts[0] == "docker.io" || parts[0] == "index.docker.io" {
+			return DOCKERHUB_HOST, dockerhubPath(parts[1])
+		}
+		return parts[0], parts[1]
+	}
+
+	return DOCKERHUB_HOST, dockerhubPath(repository)
+}
+
+func dockerhubPath(path string) string {
+	if !strings.Contains(path, "/") {
+		return "library/" + path
+	}
+	return path
+}
+
+// All tags of the repository
+func (c *Client) Tags(repository string) ([]string, error) {
+	host, path := SplitRepository(repository)
+
+	tags := []string{}
+	next := fmt.Sprintf("/v2/%s/tags/list", path)
+
+	for next != "" {
+		resp, err := c.get(host, path, next, "application/json")
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot list tags of %s: %v", repository, err))
+		}
+
+		var page struct {
+			Tags []string
+		}
+		err = decode(resp, &page)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot list tags of %s: %v", repository, err))
+		}
+
+		tags = append(tags, page.Tags...)
+		next = nextPage(resp.Header.Get("Link"))
+	}
+
+	return tags, nil
+}
+
+// When the image was built, as recorded in its config
+func (c *Client) Created(repository, tag string) (time.Time, error) {
+	host, path := SplitRepository(repository)
+
+	manifest, err := c.manifest(host, path, tag)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Cannot get manifest of %s:%s: %v", repository, tag, err))
+	}
+
+	// Multi-platform images: the build dates of the platforms are about the same
+	if len(manifest.Manifests) > 0 {
+		digest := manifest.Manifests[0].Digest
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+				digest = m.Digest
+				break
+			}
+		}
+
+		manifest, err = c.manifest(host, path, digest)
+		if err != nil {
+			return time.Time{}, errors.New(fmt.Sprintf("Cannot get manifest of %s:%s: %v", repository, tag, err))
+		}
+	}
+
+	if manifest.Config.Digest == "" {
+		return time.Time{}, errors.New(fmt.Sprintf("%s:%s has no image config", repository, tag))
+	}
+
+	resp, err := c.get(host, path, fmt.Sprintf("/v2/%s/blobs/%s", path, manifest.Config.Digest), "*/*")
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Cannot get image config of %s:%s: %v", repository, tag, err))
+	}
+
+	var config struct {
+		Created time.Time
+	}
+	if err := decode(resp, &config); err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Cannot get image config of %s:%s: %v", repository, tag, err))
+	}
+
+	return config.Created, nil
+}
+
+// The digest of the manifest the tag points to, eg: `sha256:0123...`
+func (c *Client) Digest(repository, tag string) (string, error) {
+	host, path := SplitRepository(repository)
+
+	resp, err := c.get(host, path, fmt.Sprintf("/v2/%s/manifests/%s", path, tag), manifestTypes)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Cannot get digest of %s:%s: %v", repository, tag, err))
+	}
+	defer resp.Body.Close()
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	// Not all registries send the header: the digest is the manifest's hash
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxResponse))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Cannot get digest of %s:%s: %v", repository, tag, err))
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(body)), nil
+}
+
+// Image manifest or index
+type manifest struct {
+	MediaType string
+
+	Config struct {
+		Digest string
+	}
+
+	Manifests []struct {
+		Digest   string
+		Platform struct {
+			OS           string
+			Architecture string
+		}
+	}
+}
+
+func (c *Client) manifest(host, path, reference string) (*manifest, error) {
+	resp, err := c.get(host, path, fmt.Sprintf("/v2/%s/manifests/%s", path, reference), manifestTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := decode(resp, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GET on the registry, answering its auth challenge if there's one
+func (c *Client) get(host, path, uri, accept string) (*http.Response, error) {
+	registry := c.registries[host]
+
+	scheme := "https"
+	if registry.Insecure {
+		scheme = "http"
+	}
+
+	tokenKey := host + " " + path
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest("GET", scheme+"://"+host+uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+
+		c.tokensMu.Lock()
+		token := c.tokens[tokenKey]
+		c.tokensMu.Unlock()
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if registry.Username != "" {
+			req.SetBasicAuth(registry.Username, registry.Password)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0:
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+
+			if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+				return nil, errors.New(fmt.Sprintf("%s: unauthorized", host))
+			}
+
+			token, err := c.fetchToken(registry, challenge)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s: cannot get a token: %v", host, err))
+			}
+
+			c.tokensMu.Lock()
+			c.tokens[tokenKey] = token
+			c.tokensMu.Unlock()
+
+		case resp.StatusCode != http.StatusOK:
+			resp.Body.Close()
+			return nil, errors.New(fmt.Sprintf("%s: GET %s: %s", host, uri, resp.Status))
+
+		default:
+			return resp, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("%s: unauthorized", host))
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Token for a `Bearer realm="...",service="...",scope="..."` challenge
+func (c *Client) fetchToken(registry RegistryConfig, challenge string) (string, error) {
+	params := map[string]string{}
+	for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	if params["realm"] == "" {
+		return "", errors.New("no realm in challenge")
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+
+	query := realm.Query()
+	for _, param := range []string{"service", "scope"} {
+		if params[param] != "" {
+			query.Set(param, params[param])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if registry.Username != "" {
+		req.SetBasicAuth(registry.Username, registry.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return "", errors.New(resp.Status)
+	}
+
+	var token struct {
+		Token       string
+		AccessToken string `json:"access_token"`
+	}
+	if err := decode(resp, &token); err != nil {
+		return "", err
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", errors.New("empty token")
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxResponse))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+var linkNext = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// The next page in a `Link: </v2/...?last=x&n=y>; rel="next"` header
+func nextPage(link string) string {
+	match := linkNext.FindStringSubmatch(link)
+	if match == nil {
+		return ""
+	}
+
+	next, err := url.Parse(match[1])
+	if err != nil {
+		return ""
+	}
+	return next.RequestURI()
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/testutils"
+)
+
+func TestSplitRepository(t *testing.T) {
+	tests := []struct {
+		Repository, Host, Path string
+	}{
+		{"redis", DOCKERHUB_HOST, "library/redis"},
+		{"foo/bar", DOCKERHUB_HOST, "foo/bar"},
+		{"docker.io/foo/bar", DOCKERHUB_HOST, "foo/bar"},
+		{"ghcr.io/foo/bar", "ghcr.io", "foo/bar"},
+		{"registry:5000/foo/bar", "registry:5000", "foo/bar"},
+		{"localhost/bar", "localhost", "bar"},
+	}
+
+	for _, test := range tests {
+		host, path := SplitRepository(test.Repository)
+		if host != test.Host || path != test.Path {
+			t.Fatalf("%s: expected %s %s, got %s %s", test.Repository, test.Host, test.Path, host, path)
+		}
+	}
+}
+
+func TestTagsCreatedAndDigest(t *testing.T) {
+	registry := testutils.NewTestRegistry(t)
+	registry.Username, registry.Password = "mgo", "s3cret"
+	registry.PageSize = 2
+
+	built := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	digests := map[string]string{}
+	for i, tag := range []string{"1.0.0", "1.1.0", "1.2.0", "latest", "main-abcdef"} {
+		digests[tag] = registry.Push("foo/bar", tag, built.Add(time.Duration(i)*time.Hour))
+	}
+
+	client := NewClient([]RegistryConfig{
+		{Host: registry.Host, Username: "mgo", Password: "s3cret", Insecure: true},
+	})
+
+	tags, err := client.Tags(registry.Host + "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"1.0.0", "1.1.0", "1.2.0", "latest", "main-abcdef"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tags)
+	}
+
+	created, err := client.Created(registry.Host+"/foo/bar", "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Equal(built.Add(2 * time.Hour)) {
+		t.Fatalf("Unexpected build date %v", created)
+	}
+
+	digest, err := client.Digest(registry.Host+"/foo/bar", "1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != digests["1.1.0"] {
+		t.Fatalf("Expected digest %s, got %s", digests["1.1.0"], digest)
+	}
+
+	if _, err := client.Tags(registry.Host + "/foo/nope"); err == nil {
+		t.Fatal("Expected an error for a missing repository")
+	}
+
+	// Wrong credentials
+	client = NewClient([]RegistryConfig{{Host: registry.Host, Username: "mgo", Password: "nope", Insecure: true}})
+	if _, err := client.Tags(registry.Host + "/foo/bar"); err == nil {
+		t.Fatal("Expected an error with wrong credentials")
+	}
+}
@@ -0,0 +1,112 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Secrets section in `mygitops.yaml`, eg:
+//
+//	secrets:
+//	  sops: /usr/local/bin/sops
+//	  ageKeyFile: /etc/mgo/age.key
+type Config struct {
+	// The sops binary, `sops` from the PATH by default
+	Sops string
+	// Passed to sops as SOPS_AGE_KEY_FILE. PGP keys are taken from the
+	// user's keyring as usual
+	AgeKeyFile string `yaml:"ageKeyFile"`
+}
+
+// Decrypts SOPS encrypted files
+type Decrypter interface {
+	Decrypt(path string) ([]byte, error)
+}
+
+// The secrets file of a helm values file: `foo-values.yaml` has its secrets
+// in `foo-secrets.yaml`, `values.yaml` in `secrets.yaml`. Empty if the values
+// file isn't named either way
+func SecretsFile(valuesFile string) string {
+	dir, base := filepath.Split(valuesFile)
+
+	if base == "values.yaml" {
+		return filepath.Join(dir, "secrets.yaml")
+	}
+	if strings.HasSuffix(base, "-values.yaml") {
+		return filepath.Join(dir, strings.TrimSuffix(base, "-values.yaml")+"-secrets.yaml")
+	}
+	return ""
+}
+
+// Whether a values file is meant to hold secrets, eg: `foo-secrets.yaml`
+func IsSecretsFile(path string) bool {
+	base := filepath.Base(path)
+	return base == "secrets.yaml" || strings.HasSuffix(base, "-secrets.yaml")
+}
+
+// Whether a file was encrypted by sops, ie. it has a top level `sops`
+// section with a MAC
+func IsEncrypted(content []byte) bool {
+	var doc struct {
+		Sops struct {
+			Mac string
+		}
+	}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return false
+	}
+	return doc.Sops.Mac != ""
+}
+
+// Calls fn with the value files of a release, the encrypted ones replaced
+// by their decrypted content in a temp dir only readable by us. The temp dir
+// is removed once fn returns
+func WithDecrypted(d Decrypter, valueFiles []string, fn func([]string) error) error {
+	var (
+		files  = make([]string, len(valueFiles))
+		tmpDir string
+	)
+
+	for idx, path := range valueFiles {
+		files[idx] = path
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !IsEncrypted(content) {
+			if IsSecretsFile(path) {
+				log.Warnf("Secrets file %s is not encrypted", path)
+			}
+			continue
+		}
+
+		if tmpDir == "" {
+			// Created with 0700
+			tmpDir, err = ioutil.TempDir("", "mygitops-secrets-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpDir)
+		}
+
+		decrypted, err := d.Decrypt(path)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot decrypt %s: %v", path, err))
+		}
+
+		files[idx] = filepath.Join(tmpDir, fmt.Sprintf("%d-%s", idx, filepath.Base(path)))
+		if err := ioutil.WriteFile(files[idx], decrypted, 0600); err != nil {
+			return err
+		}
+	}
+
+	return fn(files)
+}
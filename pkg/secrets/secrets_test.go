@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const encrypted = `password: ENC[AES256_GCM,data:Zm9v,iv:YmFy,tag:YmF6,type:str]
+sops:
+  age:
+  - recipient: age1foo
+  lastmodified: "2026-01-01T00:00:00Z"
+  mac: ENC[AES256_GCM,data:bWFj,iv:YmFy,tag:YmF6,type:str]
+  version: 3.8.1
+`
+
+func TestSecretsFile(t *testing.T) {
+	tests := map[string]string{
+		"/repo/installations/prod/redis-values.yaml": "/repo/installations/prod/redis-secrets.yaml",
+		"/repo/envs/prod/redis/values.yaml":          "/repo/envs/prod/redis/secrets.yaml",
+		"/repo/envs/prod/redis.yaml":                 "",
+	}
+
+	for valuesFile, expected := range tests {
+		if secretsFile := SecretsFile(valuesFile); secretsFile != expected {
+			t.Fatalf("%s: expected '%s', got '%s'", valuesFile, expected, secretsFile)
+		}
+		if IsSecretsFile(valuesFile) || (expected != "" && !IsSecretsFile(expected)) {
+			t.Fatalf("%s: wrong IsSecretsFile()", valuesFile)
+		}
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	if !IsEncrypted([]byte(encrypted)) {
+		t.Fatal("Expected the sops file to be encrypted")
+	}
+
+	for _, content := range []string{"password: foo\n", "sops: nope\n", "sops:\n  version: 3.8.1\n", "{"} {
+		if IsEncrypted([]byte(content)) {
+			t.Fatalf("Expected %q not to be encrypted", content)
+		}
+	}
+}
+
+func TestWithDecrypted(t *testing.T) {
+	dir := t.TempDir()
+	valuesFile := filepath.Join(dir, "redis-values.yaml")
+	secretsFile := filepath.Join(dir, "redis-secrets.yaml")
+
+	ioutil.WriteFile(valuesFile, []byte("replicas: 1\n"), 0644)
+	ioutil.WriteFile(secretsFile, []byte(encrypted), 0644)
+
+	valueFiles := []string{valuesFile, secretsFile}
+
+	decrypter := &DecrypterFake{}
+	var decrypted string
+
+	err := WithDecrypted(decrypter, valueFiles, func(files []string) error {
+		if len(files) != 2 || files[0] != valuesFile || files[1] == secretsFile {
+			t.Fatalf("Expected the secrets file to be replaced, got %v", files)
+		}
+
+		for _, path := range []string{files[1], filepath.Dir(files[1])} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm()&0077 != 0 {
+				t.Fatalf("%s should only be accessible by its owner, has %v", path, info.Mode())
+			}
+		}
+
+		decrypted = files[1]
+		content, _ := ioutil.ReadFile(files[1])
+		if strings.TrimSpace(string(content)) != "password: ENC[AES256_GCM,data:Zm9v,iv:YmFy,tag:YmF6,type:str]" {
+			t.Fatalf("Unexpected decrypted content %s", content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decrypter.Decrypted, []string{secretsFile}) {
+		t.Fatalf("Expected only the secrets file to be decrypted, got %v", decrypter.Decrypted)
+	}
+	if _, err := os.Stat(filepath.Dir(decrypted)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the decrypted secrets to be removed, got %v", err)
+	}
+
+	err = WithDecrypted(&DecrypterFake{FailOnDecrypt: "no key"}, valueFiles, func([]string) error {
+		t.Fatal("Should not be called when decrypting fails")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Fatalf("Expected the decryption error, got %v", err)
+	}
+}
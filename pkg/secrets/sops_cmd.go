@@ -0,0 +1,51 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Implements Decrypter based on the sops executable
+type SopsCmd struct {
+	binary     string
+	ageKeyFile string
+}
+
+// A nil config means the defaults
+func NewSopsCmd(config *Config) *SopsCmd {
+	s := &SopsCmd{binary: "sops"}
+
+	if config != nil {
+		if config.Sops != "" {
+			s.binary = config.Sops
+		}
+		s.ageKeyFile = config.AgeKeyFile
+	}
+	return s
+}
+
+// The decrypted content is only kept in memory
+func (s *SopsCmd) Decrypt(path string) ([]byte, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := exec.Command(s.binary, "--decrypt", path)
+	log.Debugln("  - running:", s.binary, "--decrypt", path)
+
+	// sops needs HOME and GNUPGHOME for PGP keys
+	cmd.Env = os.Environ()
+	if s.ageKeyFile != "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+s.ageKeyFile)
+	}
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New("sops: " + strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Mock Decrypter
+// Decrypting just drops the top level `sops` section. Set `FailOnDecrypt` to
+// return an error with that message instead
+type DecrypterFake struct {
+	FailOnDecrypt string
+
+	// Files passed to Decrypt(), in order
+	Decrypted []string
+	mu        sync.Mutex
+}
+
+func (d *DecrypterFake) Decrypt(path string) ([]byte, error) {
+	d.mu.Lock()
+	d.Decrypted = append(d.Decrypted, path)
+	d.mu.Unlock()
+
+	if d.FailOnDecrypt != "" {
+		return nil, errors.New(d.FailOnDecrypt)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	decrypted := yaml.MapSlice{}
+	for _, item := range doc {
+		if item.Key != "sops" {
+			decrypted = append(decrypted, item)
+		}
+	}
+	return yaml.Marshal(decrypted)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/journal"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+const (
+	// Upper bound for the `wait` parameter
+	maxDeploymentWait = 60 * time.Second
+
+	deploymentPollInterval = 100 * time.Millisecond
+)
+
+// Reports where a deploy request is at: `GET /deployments/{id}`
+//
+// Long-polling: with `wait=<duration>` (eg: `wait=30s`) the response is held
+// until the deploy is done/failed, or until it leaves the stage given in
+// `since`, or until the duration runs out. Following a deploy through all its
+// stages is a matter of passing back the last stage seen as `since`. Clients
+// only get the deploys they may make
+type DeploymentsHandler struct {
+	releaseManager services.ReleaseManager
+	authenticator  *auth.Authenticator
+}
+
+func (dh DeploymentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	wait := time.Duration(0)
+	if param := r.FormValue("wait"); param != "" {
+		var err error
+		wait, err = time.ParseDuration(param)
+		if err != nil {
+			handleServerError(errors.New("parameter `wait` must be a duration, eg: 30s"), http.StatusBadRequest, r, w)
+			return
+		}
+		if wait > maxDeploymentWait {
+			wait = maxDeploymentWait
+		}
+	}
+	since := r.FormValue("since")
+
+	entry, ok := dh.releaseManager.Deployment(id)
+	if !ok {
+		handleServerError(errors.New(fmt.Sprintf("No such deployment %s", id)), http.StatusNotFound, r, w)
+		return
+	}
+	if err := dh.authenticator.Authorize(r, entry.Deploy.Cluster, entry.Deploy.TriggerRepo); err != nil {
+		handleServerError(err, http.StatusForbidden, r, w)
+		return
+	}
+
+	deadline := time.Now().Add(wait)
+	for !entry.Finished() && (since == "" || entry.Stage == since) && time.Now().Before(deadline) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(deploymentPollInterval):
+		}
+
+		entry, _ = dh.releaseManager.Deployment(id)
+	}
+
+	response, err := json.MarshalIndent(apiResponseDeployment{
+		Status:     "ok",
+		Deployment: entry,
+	}, "", "  ")
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		return
+	}
+
+	w.Write(response)
+}
+
+func deploymentPath(id string) string {
+	return "/deployments/" + id
+}
+
+type apiResponseDeployment struct {
+	Status     string         `json:"status"`
+	Deployment *journal.Entry `json:"deployment"`
+}
+
+// Lists the deploys pushed to the gitops repo, newest first:
+// `GET /deployments?cluster=&triggerRepo=&limit=`. Deploys the client may not
+// make are left out
+type DeploymentHistoryHandler struct {
+	releaseManager services.ReleaseManager
+	authenticator  *auth.Authenticator
+}
+
+func (dh DeploymentHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter := &history.Filter{
+		Cluster:     r.FormValue("cluster"),
+		TriggerRepo: r.FormValue("triggerRepo"),
+	}
+
+	if param := r.FormValue("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit < 0 {
+			handleServerError(errors.New("parameter `limit` must be a positive number"), http.StatusBadRequest, r, w)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	// Deploys the client may not see don't count towards the limit
+	limit := filter.Limit
+	filter.Limit = 0
+
+	records, err := dh.releaseManager.History(filter)
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		return
+	}
+
+	allowed := []*history.Record{}
+	for _, record := range records {
+		if limit > 0 && len(allowed) == limit {
+			break
+		}
+		if dh.authenticator.Allowed(r, record.Deploy.Cluster, record.Deploy.TriggerRepo) {
+			allowed = append(allowed, record)
+		}
+	}
+
+	response, err := json.MarshalIndent(apiResponseDeploymentHistory{
+		Status:      "ok",
+		Deployments: allowed,
+	}, "", "  ")
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		return
+	}
+
+	w.Write(response)
+}
+
+type apiResponseDeploymentHistory struct {
+	Status      string            `json:"status"`
+	Deployments []*history.Record `json:"deployments"`
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/journal"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+var testDeploy = &deploy.DeployOptions{Cluster: "prod", TriggerRepo: "github.com/a/repo1"}
+
+func getDeployment(t *testing.T, handler DeploymentsHandler, id, query string) (int, *journal.Entry) {
+	return getDeploymentAs(t, handler, nil, id, query)
+}
+
+func getDeploymentAs(t *testing.T, handler DeploymentsHandler, client *auth.Client, id, query string) (int, *journal.Entry) {
+	req := httptest.NewRequest("GET", "/deployments/"+id+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	if client != nil {
+		req = req.WithContext(auth.WithClient(req.Context(), client))
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	body := apiResponseDeployment{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body.Deployment
+}
+
+func TestDeploymentsHandler(t *testing.T) {
+	handler := DeploymentsHandler{
+		releaseManager: &services.ReleaseManagerMock{
+			Deployments: map[string]*journal.Entry{
+				"abc": {ID: "abc", Deploy: testDeploy, Stage: journal.STAGE_DONE, Commit: "f00"},
+				"def": {ID: "def", Deploy: testDeploy, Stage: journal.STAGE_FAILED, Error: "boom"},
+			},
+		},
+	}
+
+	if status, entry := getDeployment(t, handler, "abc", ""); status != http.StatusOK || entry.Stage != journal.STAGE_DONE || entry.Commit != "f00" {
+		t.Fatalf("Unexpected response %d: %+v", status, entry)
+	}
+
+	if status, entry := getDeployment(t, handler, "def", ""); status != http.StatusOK || entry.Error != "boom" {
+		t.Fatalf("Unexpected response %d: %+v", status, entry)
+	}
+
+	if status, _ := getDeployment(t, handler, "nope", ""); status != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown deployment, got %d", status)
+	}
+
+	if status, _ := getDeployment(t, handler, "abc", "?wait=soon"); status != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a bad `wait`, got %d", status)
+	}
+}
+
+func TestDeploymentsHandlerLongPoll(t *testing.T) {
+	deployments := map[string]*journal.Entry{
+		"abc": {ID: "abc", Deploy: testDeploy, Stage: journal.STAGE_QUEUED},
+	}
+	handler := DeploymentsHandler{
+		releaseManager: &services.ReleaseManagerMock{Deployments: deployments},
+	}
+
+	// Nothing changes: held until `wait` runs out
+	start := time.Now()
+	if _, entry := getDeployment(t, handler, "abc", "?wait=300ms"); entry.Stage != journal.STAGE_QUEUED {
+		t.Fatalf("Unexpected stage %s", entry.Stage)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("Expected the response to be held, returned after %v", elapsed)
+	}
+
+	// Already past the `since` stage: answered right away
+	start = time.Now()
+	if _, entry := getDeployment(t, handler, "abc", "?wait=10s&since=pushed"); entry.Stage != journal.STAGE_QUEUED {
+		t.Fatalf("Unexpected stage %s", entry.Stage)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected an immediate response, returned after %v", elapsed)
+	}
+}
+
+func TestDeploymentHistoryHandler(t *testing.T) {
+	handler := DeploymentHistoryHandler{
+		releaseManager: &services.ReleaseManagerMock{
+			HistoryRecords: []*history.Record{
+				{Commit: "f00", Deploy: &deploy.DeployOptions{ID: "2", Cluster: "prod", TriggerRepo: "github.com/a/repo1"}},
+				{Commit: "ba4", Deploy: &deploy.DeployOptions{ID: "1", Cluster: "staging", TriggerRepo: "github.com/a/repo1"}},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/deployments?cluster=prod&triggerRepo=github.com/a/repo1", nil))
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	body := apiResponseDeploymentHistory{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Deployments) != 1 || body.Deployments[0].Commit != "f00" {
+		t.Fatalf("Expected only the prod deploy, got %+v", body.Deployments)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/deployments?limit=lots", nil))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a bad `limit`, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestDeploymentsDeniedRead(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(&auth.Config{
+		Clients: []auth.ClientConfig{
+			{Name: "ci", Token: "t0ken", Clusters: []string{"staging"}, TriggerRepos: []string{"*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci := auth.NewClient("ci", []string{"staging"}, []string{"*"})
+
+	releaseManager := &services.ReleaseManagerMock{
+		Deployments: map[string]*journal.Entry{
+			"abc": {ID: "abc", Deploy: testDeploy, Stage: journal.STAGE_DONE},
+			"def": {ID: "def", Deploy: &deploy.DeployOptions{Cluster: "staging", TriggerRepo: "github.com/a/repo1"}, Stage: journal.STAGE_DONE},
+		},
+		HistoryRecords: []*history.Record{
+			{Commit: "f00", Deploy: &deploy.DeployOptions{ID: "3", Cluster: "prod", TriggerRepo: "github.com/a/repo1"}},
+			{Commit: "ba4", Deploy: &deploy.DeployOptions{ID: "2", Cluster: "staging", TriggerRepo: "github.com/a/repo1"}},
+			{Commit: "c0f", Deploy: &deploy.DeployOptions{ID: "1", Cluster: "staging", TriggerRepo: "github.com/a/repo2"}},
+		},
+	}
+
+	handler := DeploymentsHandler{releaseManager: releaseManager, authenticator: authenticator}
+	if status, _ := getDeploymentAs(t, handler, ci, "abc", ""); status != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a prod deployment, got %d", status)
+	}
+	if status, _ := getDeploymentAs(t, handler, nil, "abc", ""); status != http.StatusForbidden {
+		t.Fatalf("Expected 403 without a client, got %d", status)
+	}
+	if status, _ := getDeploymentAs(t, handler, ci, "def", ""); status != http.StatusOK {
+		t.Fatalf("Expected the staging deployment, got %d", status)
+	}
+
+	historyHandler := DeploymentHistoryHandler{releaseManager: releaseManager, authenticator: authenticator}
+	for query, expected := range map[string][]string{
+		"":         {"ba4", "c0f"},
+		"?limit=1": {"ba4"},
+	} {
+		req := httptest.NewRequest("GET", "/deployments"+query, nil)
+		req = req.WithContext(auth.WithClient(req.Context(), ci))
+
+		w := httptest.NewRecorder()
+		historyHandler.ServeHTTP(w, req)
+
+		body := apiResponseDeploymentHistory{}
+		if err := json.NewDecoder(w.Result().Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		commits := []string{}
+		for _, record := range body.Deployments {
+			commits = append(commits, record.Commit)
+		}
+		if !reflect.DeepEqual(commits, expected) {
+			t.Fatalf("[%s] Expected only the staging deploys %v, got %v", query, expected, commits)
+		}
+	}
+}
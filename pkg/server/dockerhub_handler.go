@@ -1,13 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/valer-cara/mgo/pkg/auth"
 	"github.com/valer-cara/mgo/pkg/deploy"
 	"github.com/valer-cara/mgo/pkg/notification"
 	"github.com/valer-cara/mgo/pkg/services"
@@ -45,9 +49,27 @@ type DockerhubWebhookPayload_Repository struct {
 	Status          string `json:"status"`
 }
 
+// Docker Hub's callback_url hosts. The deploy's outcome is only reported to these
+var dockerhubCallbackHosts = []string{"registry.hub.docker.com", "hub.docker.com"}
+
+const dockerhubCallbackTimeout = 10 * time.Second
+
+// Docker Hub webhook callback: https://docs.docker.com/docker-hub/webhooks/#validate-a-webhook-callback
+type DockerhubCallback struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
 type DockerhubHandler struct {
 	releaseManager services.ReleaseManager
 	notification   notification.Notification
+	authenticator  *auth.Authenticator
+
+	// Hosts `callback_url` may point to
+	callbackHosts  []string
+	callbackClient *http.Client
 
 	// Filled in from request
 	payload     *DockerhubWebhookPayload
@@ -63,17 +85,25 @@ func (dh DockerhubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := dh.authenticator.Authorize(r, dh.cluster, dh.triggerRepo); err != nil {
+		handleServerError(err, http.StatusForbidden, r, w)
+		return
+	}
+
 	log.Printf("[%s] New deploy request: %s", r.RemoteAddr, dh)
 	dopts := dh.getDeployOptions()
 	if err := dh.releaseManager.RequestRelease(dopts); err != nil {
-		handleServerError(err, http.StatusInternalServerError, r, w)
+		handleServerError(err, deployErrorStatus(err), r, w)
 		dh.sendNotification(err)
+		dh.callback(dopts, err)
 		return
 	}
+	dh.callback(dopts, nil)
 
 	response, err := json.MarshalIndent(apiResponseDeploy{
 		Status: "ok",
-		Deploy: dh.getDeployOptions(),
+		ID:     dopts.ID,
+		Deploy: dopts,
 	}, "", "  ")
 	if err != nil {
 		handleServerError(err, http.StatusInternalServerError, r, w)
@@ -145,6 +175,56 @@ func (dh *DockerhubHandler) getDeployOptions() *deploy.DeployOptions {
 	}
 }
 
+// Report the deploy's outcome back to Docker Hub, if it asked for it
+func (dh *DockerhubHandler) callback(dopts *deploy.DeployOptions, err error) {
+	callbackURL := dh.payload.CallbackURL
+	if callbackURL == "" {
+		return
+	}
+
+	parsed, errURL := url.Parse(callbackURL)
+	if errURL != nil || parsed.Scheme != "https" || !containsString(dh.callbackHosts, parsed.Host) {
+		log.Warnf("Not calling back unexpected Docker Hub callback_url %s", callbackURL)
+		return
+	}
+
+	callback := DockerhubCallback{
+		State:       "success",
+		Description: fmt.Sprintf("Deployed %s:%s to %s", dopts.Image.Repository, dopts.Image.Tag, dopts.Cluster),
+		Context:     "mygitops",
+	}
+	if err != nil {
+		callback.State = "failure"
+		callback.Description = fmt.Sprintf("Deploy to %s failed: %v", dopts.Cluster, err)
+	}
+
+	body, _ := json.Marshal(callback)
+	client := dh.callbackClient
+	if client == nil {
+		client = &http.Client{Timeout: dockerhubCallbackTimeout}
+	}
+
+	resp, errPost := client.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if errPost != nil {
+		log.Errorf("Docker Hub callback failed: %v", errPost)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Errorf("Docker Hub callback failed with status %d", resp.StatusCode)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 // XXX: terrible dupes.. use a middleware for errors & notifs...
 func (dh *DockerhubHandler) sendNotification(err error) error {
 	// If there's no notification service defined don't try to send one
@@ -3,10 +3,11 @@ package server
 import (
 	"bytes"
 	"encoding/json"
-	//"net/http"
+	"errors"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
-	//"net/url"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -60,3 +61,69 @@ func TestDockerhub(t *testing.T) {
 		t.Fatalf("Expected 'ok' response, got '%s'", body["status"].(string))
 	}
 }
+
+func TestDockerhubCallback(t *testing.T) {
+	callbacks := make(chan DockerhubCallback, 1)
+	hub := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callback := DockerhubCallback{}
+		json.NewDecoder(r.Body).Decode(&callback)
+		callbacks <- callback
+	}))
+	defer hub.Close()
+	hubURL, _ := url.Parse(hub.URL)
+
+	tests := []struct {
+		ReleaseManager services.ReleaseManager
+		State          string
+	}{
+		{&services.ReleaseManagerMock{}, "success"},
+		{&services.ReleaseManagerMock{RequestReleaseError: errors.New("request_release")}, "failure"},
+	}
+
+	for testIdx, test := range tests {
+		payload, _ := json.Marshal(DockerhubWebhookPayload{
+			CallbackURL: hub.URL + "/u/foo/bar/hook/123/",
+			PushData:    DockerhubWebhookPayload_PushData{Tag: "1.0.0", Pusher: "matthew"},
+			Repository:  DockerhubWebhookPayload_Repository{RepoName: "foo/bar"},
+		})
+
+		req := httptest.NewRequest("POST", "/deploy/dockerhub?triggerRepo=foo&cluster=bar", bytes.NewReader(payload))
+		w := httptest.NewRecorder()
+
+		handler := DockerhubHandler{
+			releaseManager: test.ReleaseManager,
+			callbackHosts:  []string{hubURL.Host},
+			callbackClient: hub.Client(),
+		}
+		handler.ServeHTTP(w, req)
+
+		select {
+		case callback := <-callbacks:
+			if callback.State != test.State {
+				t.Fatalf("[test %d] Expected callback state %s, got %+v", testIdx, test.State, callback)
+			}
+		default:
+			t.Fatalf("[test %d] Expected Docker Hub to be called back", testIdx)
+		}
+	}
+
+	// Not a Docker Hub host: no callback
+	payload, _ := json.Marshal(DockerhubWebhookPayload{
+		CallbackURL: hub.URL + "/u/foo/bar/hook/123/",
+		PushData:    DockerhubWebhookPayload_PushData{Tag: "1.0.0", Pusher: "matthew"},
+		Repository:  DockerhubWebhookPayload_Repository{RepoName: "foo/bar"},
+	})
+	req := httptest.NewRequest("POST", "/deploy/dockerhub?triggerRepo=foo&cluster=bar", bytes.NewReader(payload))
+	handler := DockerhubHandler{
+		releaseManager: &services.ReleaseManagerMock{},
+		callbackHosts:  dockerhubCallbackHosts,
+		callbackClient: hub.Client(),
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case callback := <-callbacks:
+		t.Fatalf("Expected no callback to an unknown host, got %+v", callback)
+	default:
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/valer-cara/mgo/pkg/auth"
 	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/journal"
 	"github.com/valer-cara/mgo/pkg/notification"
 	"github.com/valer-cara/mgo/pkg/services"
 )
@@ -19,6 +22,7 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 
 type DeployHandler struct {
 	releaseManager services.ReleaseManager
+	authenticator  *auth.Authenticator
 
 	// Notification
 	notification notification.Notification
@@ -27,8 +31,14 @@ type DeployHandler struct {
 	formTriggerRepo string
 	formImageRepo   string
 	formImageTag    string
+	formImageDigest string
 	formAuthor      string
 	formCluster     string
+
+	// Respond as soon as the deploy is queued
+	formAsync bool
+	// Pin the image to the digest of its tag
+	formResolveDigest bool
 }
 
 func (dh DeployHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -41,17 +51,29 @@ func (dh DeployHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := dh.authenticator.Authorize(r, dh.formCluster, dh.formTriggerRepo); err != nil {
+		handleServerError(err, http.StatusForbidden, r, w)
+		return
+	}
+
 	log.Printf("[%s] New deploy request: %s", r.RemoteAddr, dh)
 	dopts := dh.getDeployOptions()
+
+	if dh.formAsync {
+		dh.serveAsync(dopts, w, r)
+		return
+	}
+
 	if err := dh.releaseManager.RequestRelease(dopts); err != nil {
-		handleServerError(err, http.StatusInternalServerError, r, w)
+		handleServerError(err, deployErrorStatus(err), r, w)
 		dh.sendNotification(err)
 		return
 	}
 
 	response, err := json.MarshalIndent(apiResponseDeploy{
 		Status: "ok",
-		Deploy: dh.getDeployOptions(),
+		ID:     dopts.ID,
+		Deploy: dopts,
 	}, "", "  ")
 	if err != nil {
 		handleServerError(err, http.StatusInternalServerError, r, w)
@@ -65,6 +87,45 @@ func (dh DeployHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 }
 
+// Queue the deploy and respond with `202 Accepted` and the deploy ID. The
+// deploy's progress is at `GET /deployments/{id}`. The notification is sent
+// once it's done
+func (dh DeployHandler) serveAsync(dopts *deploy.DeployOptions, w http.ResponseWriter, r *http.Request) {
+	result, err := dh.releaseManager.QueueRelease(dopts)
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		dh.sendNotification(err)
+		return
+	}
+
+	go func() {
+		select {
+		case <-result.Done:
+			log.Printf("Deploy %s successful!", dopts.ID)
+			dh.sendNotification(nil)
+		case err := <-result.Err:
+			log.Errorf("Deploy %s failed: %v", dopts.ID, err)
+			dh.sendNotification(err)
+		}
+	}()
+
+	response, err := json.MarshalIndent(apiResponseDeploy{
+		Status: journal.STAGE_QUEUED,
+		ID:     dopts.ID,
+		Deploy: dopts,
+	}, "", "  ")
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		return
+	}
+
+	log.Printf("[%s] Deploy %s queued", r.RemoteAddr, dopts.ID)
+
+	w.Header().Set("Location", deploymentPath(dopts.ID))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(response)
+}
+
 func (dh *DeployHandler) init(r *http.Request) (int, error) {
 	if err := r.ParseForm(); err != nil {
 		return http.StatusInternalServerError, err
@@ -73,9 +134,32 @@ func (dh *DeployHandler) init(r *http.Request) (int, error) {
 	dh.formTriggerRepo = r.FormValue("triggerRepo")
 	dh.formImageRepo = r.FormValue("imageRepo")
 	dh.formImageTag = r.FormValue("imageTag")
+	dh.formImageDigest = r.FormValue("imageDigest")
 	dh.formAuthor = r.FormValue("author")
 	dh.formCluster = r.FormValue("cluster")
 
+	// Or the whole reference, eg: `registry:5000/foo/bar:1.0@sha256:...`
+	if image := r.FormValue("image"); image != "" {
+		ref := deploy.ParseImage(image)
+		dh.formImageRepo, dh.formImageTag, dh.formImageDigest = ref.Repository, ref.Tag, ref.Digest
+	}
+
+	if async := r.FormValue("async"); async != "" {
+		formAsync, err := strconv.ParseBool(async)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("parameter `async` must be true or false")
+		}
+		dh.formAsync = formAsync
+	}
+
+	if resolveDigest := r.FormValue("resolveDigest"); resolveDigest != "" {
+		formResolveDigest, err := strconv.ParseBool(resolveDigest)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("parameter `resolveDigest` must be true or false")
+		}
+		dh.formResolveDigest = formResolveDigest
+	}
+
 	// If no error, status will be ignored by caller
 	// If error, it's a 400 BadRequest
 	return http.StatusBadRequest, dh.ValidateInput()
@@ -97,6 +181,11 @@ func (dh *DeployHandler) ValidateInput() error {
 	if dh.formCluster == "" {
 		return errors.New("missing parameter `cluster`")
 	}
+
+	image := deploy.DeployOptionsImage{Repository: dh.formImageRepo, Tag: dh.formImageTag, Digest: dh.formImageDigest}
+	if _, err := deploy.ParseReference(image.String()); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -108,7 +197,9 @@ func (dh *DeployHandler) getDeployOptions() *deploy.DeployOptions {
 		Image: deploy.DeployOptionsImage{
 			Repository: dh.formImageRepo,
 			Tag:        dh.formImageTag,
+			Digest:     dh.formImageDigest,
 		},
+		ResolveDigest: dh.formResolveDigest,
 	}
 }
 
@@ -169,5 +260,6 @@ func (dh DeployHandler) String() string {
 type apiResponseDeploy struct {
 	Status string                `json:"status"`
 	Error  string                `json:"error"`
+	ID     string                `json:"id,omitempty"`
 	Deploy *deploy.DeployOptions `json:"deploy"`
 }
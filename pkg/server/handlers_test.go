@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/valer-cara/mgo/pkg/deploy"
 	"github.com/valer-cara/mgo/pkg/services"
 )
 
@@ -73,6 +75,7 @@ func TestServerDeployHandlerResponses(t *testing.T) {
 	}{
 		{&services.ReleaseManagerMock{}, http.StatusOK},
 		{&services.ReleaseManagerMock{RequestReleaseError: errors.New("request_release")}, http.StatusInternalServerError},
+		{&services.ReleaseManagerMock{RequestReleaseError: &deploy.PolicyError{Reason: "frozen"}}, http.StatusUnprocessableEntity},
 	}
 
 	for testIdx, test := range tests {
@@ -92,3 +95,102 @@ func TestServerDeployHandlerResponses(t *testing.T) {
 		}
 	}
 }
+
+func TestServerDeployHandlerImage(t *testing.T) {
+	digest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	tests := []struct {
+		Params   map[string]string
+		Status   int
+		Expected deploy.DeployOptionsImage
+	}{
+		{map[string]string{"image": "registry:5000/foo/bar:1.0@" + digest}, http.StatusOK, deploy.DeployOptionsImage{Repository: "registry:5000/foo/bar", Tag: "1.0", Digest: digest}},
+		{map[string]string{"imageRepo": "registry:5000/foo/bar", "imageTag": "1.0", "imageDigest": digest}, http.StatusOK, deploy.DeployOptionsImage{Repository: "registry:5000/foo/bar", Tag: "1.0", Digest: digest}},
+		{map[string]string{"image": "registry:5000/foo/bar"}, http.StatusBadRequest, deploy.DeployOptionsImage{}},
+		{map[string]string{"image": "Foo/Bar:1.0"}, http.StatusBadRequest, deploy.DeployOptionsImage{}},
+		{map[string]string{"imageRepo": "foo/bar", "imageTag": "1.0", "imageDigest": "sha256:nope"}, http.StatusBadRequest, deploy.DeployOptionsImage{}},
+	}
+
+	for testIdx, test := range tests {
+		data := url.Values{}
+		data.Set("triggerRepo", "xxx")
+		data.Set("author", "xxx")
+		data.Set("cluster", "xxx")
+		for param, value := range test.Params {
+			data.Set(param, value)
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/deploy", strings.NewReader(data.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		handler := DeployHandler{
+			releaseManager: &services.ReleaseManagerMock{},
+		}
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, resp.StatusCode)
+		}
+		if test.Status != http.StatusOK {
+			continue
+		}
+
+		body := apiResponseDeploy{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Deploy.Image != test.Expected {
+			t.Fatalf("[test %d] Expected %+v, got %+v", testIdx, test.Expected, body.Deploy.Image)
+		}
+	}
+}
+
+func TestServerDeployHandlerAsync(t *testing.T) {
+	data := url.Values{}
+	data.Set("triggerRepo", "xxx")
+	data.Set("imageRepo", "xxx")
+	data.Set("imageTag", "xxx")
+	data.Set("author", "xxx")
+	data.Set("cluster", "xxx")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/deploy?async=true", strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	handler := DeployHandler{
+		releaseManager: &services.ReleaseManagerMock{},
+	}
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", resp.StatusCode)
+	}
+
+	body := apiResponseDeploy{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ID == "" || body.Status != "queued" {
+		t.Fatalf("Expected a queued deploy with an ID, got %+v", body)
+	}
+	if location := resp.Header.Get("Location"); location != "/deployments/"+body.ID {
+		t.Fatalf("Unexpected Location header '%s'", location)
+	}
+}
+
+func TestServerDeployHandlerAsyncValidation(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/deploy?async=maybe&triggerRepo=x&imageRepo=x&imageTag=x&author=x&cluster=x", nil)
+
+	handler := DeployHandler{
+		releaseManager: &services.ReleaseManagerMock{QueueReleaseError: errors.New("should not be called")},
+	}
+	handler.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a bad `async` value, got %d", w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/notification"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+// Promotes a trigger repo from a cluster to the next: `POST /promote`
+//
+// Deploys to `to` the image the trigger repo has on `from`, once it synced
+// there. The pair should be one of the `promotions` in `mygitops.yaml`
+type PromoteHandler struct {
+	releaseManager services.ReleaseManager
+	notification   notification.Notification
+	authenticator  *auth.Authenticator
+
+	// Request variables passed in
+	formTriggerRepo string
+	formAuthor      string
+	formFrom        string
+	formTo          string
+}
+
+func (ph PromoteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := (&ph).init(r); err != nil {
+		handleServerError(err, http.StatusBadRequest, r, w)
+		return
+	}
+
+	if err := ph.authenticator.Authorize(r, ph.formTo, ph.formTriggerRepo); err != nil {
+		handleServerError(err, http.StatusForbidden, r, w)
+		return
+	}
+
+	log.Printf("[%s] New promotion request: %s", r.RemoteAddr, ph)
+	dopts := ph.getDeployOptions()
+
+	if err := ph.releaseManager.RequestRelease(dopts); err != nil {
+		handleServerError(err, deployErrorStatus(err), r, w)
+		ph.sendNotification(dopts, err)
+		return
+	}
+
+	response, err := json.MarshalIndent(apiResponseDeploy{
+		Status: "ok",
+		ID:     dopts.ID,
+		Deploy: dopts,
+	}, "", "  ")
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		ph.sendNotification(dopts, err)
+		return
+	}
+
+	log.Printf("[%s] Promotion of %s to %s successful!", r.RemoteAddr, dopts.Image, dopts.Cluster)
+	ph.sendNotification(dopts, nil)
+
+	w.Write(response)
+}
+
+func (ph *PromoteHandler) init(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	ph.formTriggerRepo = r.FormValue("triggerRepo")
+	ph.formAuthor = r.FormValue("author")
+	ph.formFrom = r.FormValue("from")
+	ph.formTo = r.FormValue("to")
+
+	if ph.formTriggerRepo == "" {
+		return errors.New("missing parameter `triggerRepo`")
+	}
+	if ph.formAuthor == "" {
+		return errors.New("missing parameter `author`")
+	}
+	if ph.formFrom == "" {
+		return errors.New("missing parameter `from`")
+	}
+	if ph.formTo == "" {
+		return errors.New("missing parameter `to`")
+	}
+	return nil
+}
+
+func (ph *PromoteHandler) getDeployOptions() *deploy.DeployOptions {
+	return &deploy.DeployOptions{
+		TriggerRepo: ph.formTriggerRepo,
+		Author:      ph.formAuthor,
+		Cluster:     ph.formTo,
+		PromoteFrom: ph.formFrom,
+	}
+}
+
+func (ph *PromoteHandler) sendNotification(dopts *deploy.DeployOptions, err error) {
+	if ph.notification == nil {
+		return
+	}
+
+	errNotif := ph.notification.Deployed(
+		dopts.TriggerRepo,
+		dopts.Image.Repository,
+		dopts.Image.Tag,
+		dopts.Cluster,
+		dopts.Author,
+		err,
+	)
+	if errNotif != nil {
+		log.Errorf("Error sending notification, err: %v", errNotif)
+	}
+}
+
+func (ph PromoteHandler) String() string {
+	return fmt.Sprintf("triggerRepo: %s, author: %s, from: %s, to: %s",
+		ph.formTriggerRepo,
+		ph.formAuthor,
+		ph.formFrom,
+		ph.formTo,
+	)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+func TestPromoteHandlerValidation(t *testing.T) {
+	for _, removedKey := range []string{"triggerRepo", "author", "from", "to"} {
+		data := url.Values{}
+		data.Set("triggerRepo", "xxx")
+		data.Set("author", "xxx")
+		data.Set("from", "staging")
+		data.Set("to", "prod")
+
+		data.Del(removedKey)
+
+		req := httptest.NewRequest("POST", "/promote", strings.NewReader(data.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+
+		handler := PromoteHandler{releaseManager: &services.ReleaseManagerMock{}}
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status 400 BadRequest with missing param %s. Got %d.", removedKey, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestPromoteHandlerResponses(t *testing.T) {
+	data := url.Values{}
+	data.Set("triggerRepo", "xxx")
+	data.Set("author", "xxx")
+	data.Set("from", "staging")
+	data.Set("to", "prod")
+
+	refused := &deploy.PromotionError{TriggerRepo: "xxx", From: "staging", To: "prod", Reason: "never synced"}
+
+	tests := []struct {
+		ReleaseManager services.ReleaseManager
+		Status         int
+	}{
+		{&services.ReleaseManagerMock{}, http.StatusOK},
+		{&services.ReleaseManagerMock{RequestReleaseError: refused}, http.StatusUnprocessableEntity},
+		{&services.ReleaseManagerMock{RequestReleaseError: errors.New("request_release")}, http.StatusInternalServerError},
+	}
+
+	for testIdx, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/promote", strings.NewReader(data.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		handler := PromoteHandler{
+			releaseManager: test.ReleaseManager,
+		}
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, w.Result().StatusCode)
+		}
+	}
+}
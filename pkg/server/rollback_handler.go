@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/notification"
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+// Rolls a trigger repo back on a cluster: `POST /rollback`
+//
+// Goes back to the image deployed before the current one or, with `to`, to
+// the image of an earlier deploy given by its tag or commit
+type RollbackHandler struct {
+	releaseManager services.ReleaseManager
+	notification   notification.Notification
+	authenticator  *auth.Authenticator
+
+	// Request variables passed in
+	formTriggerRepo string
+	formAuthor      string
+	formCluster     string
+	formTo          string
+}
+
+func (rh RollbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := (&rh).init(r); err != nil {
+		handleServerError(err, http.StatusBadRequest, r, w)
+		return
+	}
+
+	if err := rh.authenticator.Authorize(r, rh.formCluster, rh.formTriggerRepo); err != nil {
+		handleServerError(err, http.StatusForbidden, r, w)
+		return
+	}
+
+	log.Printf("[%s] New rollback request: %s", r.RemoteAddr, rh)
+	dopts := rh.getDeployOptions()
+
+	if err := rh.releaseManager.RequestRelease(dopts); err != nil {
+		handleServerError(err, deployErrorStatus(err), r, w)
+		rh.sendNotification(dopts, err)
+		return
+	}
+
+	response, err := json.MarshalIndent(apiResponseDeploy{
+		Status: "ok",
+		ID:     dopts.ID,
+		Deploy: dopts,
+	}, "", "  ")
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		rh.sendNotification(dopts, err)
+		return
+	}
+
+	log.Printf("[%s] Rollback to %s:%s successful!", r.RemoteAddr, dopts.Image.Repository, dopts.Image.Tag)
+	rh.sendNotification(dopts, nil)
+
+	w.Write(response)
+}
+
+func (rh *RollbackHandler) init(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	rh.formTriggerRepo = r.FormValue("triggerRepo")
+	rh.formAuthor = r.FormValue("author")
+	rh.formCluster = r.FormValue("cluster")
+	rh.formTo = r.FormValue("to")
+
+	if rh.formTriggerRepo == "" {
+		return errors.New("missing parameter `triggerRepo`")
+	}
+	if rh.formAuthor == "" {
+		return errors.New("missing parameter `author`")
+	}
+	if rh.formCluster == "" {
+		return errors.New("missing parameter `cluster`")
+	}
+	return nil
+}
+
+func (rh *RollbackHandler) getDeployOptions() *deploy.DeployOptions {
+	return &deploy.DeployOptions{
+		TriggerRepo: rh.formTriggerRepo,
+		Author:      rh.formAuthor,
+		Cluster:     rh.formCluster,
+		Rollback:    true,
+		RollbackTo:  rh.formTo,
+	}
+}
+
+func (rh *RollbackHandler) sendNotification(dopts *deploy.DeployOptions, err error) {
+	if rh.notification == nil {
+		return
+	}
+
+	errNotif := rh.notification.Deployed(
+		dopts.TriggerRepo,
+		dopts.Image.Repository,
+		dopts.Image.Tag,
+		dopts.Cluster,
+		dopts.Author,
+		err,
+	)
+	if errNotif != nil {
+		log.Errorf("Error sending notification, err: %v", errNotif)
+	}
+}
+
+func (rh RollbackHandler) String() string {
+	return fmt.Sprintf("triggerRepo: %s, author: %s, cluster: %s, to: %s",
+		rh.formTriggerRepo,
+		rh.formAuthor,
+		rh.formCluster,
+		rh.formTo,
+	)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/services"
+)
+
+func TestRollbackHandlerValidation(t *testing.T) {
+	for _, removedKey := range []string{"triggerRepo", "author", "cluster"} {
+		data := url.Values{}
+		data.Set("triggerRepo", "xxx")
+		data.Set("author", "xxx")
+		data.Set("cluster", "xxx")
+
+		data.Del(removedKey)
+
+		req := httptest.NewRequest("POST", "/rollback", strings.NewReader(data.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+
+		handler := RollbackHandler{releaseManager: &services.ReleaseManagerMock{}}
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status 400 BadRequest with missing param %s. Got %d.", removedKey, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestRollbackHandlerResponses(t *testing.T) {
+	data := url.Values{}
+	data.Set("triggerRepo", "xxx")
+	data.Set("author", "xxx")
+	data.Set("cluster", "xxx")
+	data.Set("to", "1.0.0")
+
+	tests := []struct {
+		ReleaseManager services.ReleaseManager
+		Status         int
+	}{
+		{&services.ReleaseManagerMock{}, http.StatusOK},
+		{&services.ReleaseManagerMock{RequestReleaseError: errors.New("request_release")}, http.StatusInternalServerError},
+	}
+
+	for testIdx, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/rollback", strings.NewReader(data.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		handler := RollbackHandler{
+			releaseManager: test.ReleaseManager,
+		}
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, w.Result().StatusCode)
+		}
+	}
+}
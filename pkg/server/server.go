@@ -7,8 +7,12 @@ import (
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/autoupdate"
+	"github.com/valer-cara/mgo/pkg/deploy"
 	"github.com/valer-cara/mgo/pkg/notification"
 	"github.com/valer-cara/mgo/pkg/services"
+	"github.com/valer-cara/mgo/pkg/webhook"
 )
 
 const writeErrorsToClient = true
@@ -24,17 +28,31 @@ type Server struct {
 	// this way for now...
 	notifier       notification.Notification
 	releaseManager services.ReleaseManager
+
+	// nil when no clients are configured
+	authenticator *auth.Authenticator
+	// nil when no webhook secrets are configured
+	webhooks *webhook.Verifier
+	// Webhooks declared in mygitops.yaml
+	routes []*webhook.Route
+	// nil when auto updates are off
+	autoUpdater *autoupdate.AutoUpdater
 }
 
-func NewServer(listenAddr, gitopsRepo, helmHome, kubeconfig string, notifier notification.Notification, dryRun bool) *Server {
+func NewServer(listenAddr, gitopsRepo, helmHome, kubeconfig, stateDir string, notifier notification.Notification, authenticator *auth.Authenticator, webhooks *webhook.Verifier, routes []*webhook.Route, autoUpdater *autoupdate.AutoUpdater, dryRun bool) *Server {
 	return &Server{
 		listenAddr: listenAddr,
 
-		notifier: notifier,
+		notifier:      notifier,
+		authenticator: authenticator,
+		webhooks:      webhooks,
+		routes:        routes,
+		autoUpdater:   autoUpdater,
 		releaseManager: services.NewReleaseManagerBatched(&services.ReleaseManagerBatchedOptions{
 			GitopsRepo: gitopsRepo,
 			KubeConfig: kubeconfig,
 			HelmHome:   helmHome,
+			StateDir:   stateDir,
 			DryRun:     dryRun,
 		}),
 	}
@@ -46,11 +64,17 @@ func (s *Server) Serve() error {
 		return err
 	}
 
+	if s.autoUpdater != nil {
+		log.Println("Starting auto updates...")
+		go s.autoUpdater.Run(s.releaseManager)
+	}
+
 	// XXX: def need a better way to notify
 	// like a middleware to handle errors
 	deployHandler := DeployHandler{
 		releaseManager: s.releaseManager,
 		notification:   s.notifier,
+		authenticator:  s.authenticator,
 	}
 
 	// XXX: this is largely a dupe, shuold DRY this out..
@@ -58,22 +82,121 @@ func (s *Server) Serve() error {
 	dockerhubHandler := DockerhubHandler{
 		releaseManager: s.releaseManager,
 		notification:   s.notifier,
+		authenticator:  s.authenticator,
+		callbackHosts:  dockerhubCallbackHosts,
+	}
+
+	rollbackHandler := RollbackHandler{
+		releaseManager: s.releaseManager,
+		notification:   s.notifier,
+		authenticator:  s.authenticator,
+	}
+
+	promoteHandler := PromoteHandler{
+		releaseManager: s.releaseManager,
+		notification:   s.notifier,
+		authenticator:  s.authenticator,
+	}
+
+	deploymentsHandler := DeploymentsHandler{
+		releaseManager: s.releaseManager,
+		authenticator:  s.authenticator,
+	}
+
+	deploymentHistoryHandler := DeploymentHistoryHandler{
+		releaseManager: s.releaseManager,
+		authenticator:  s.authenticator,
 	}
 
 	r := mux.NewRouter()
 	r.HandleFunc("/", IndexHandler)
-	r.Handle("/deploy", deployHandler).Methods("POST")
-	r.Handle("/deploy/dockerhub", dockerhubHandler).Methods("POST")
+	r.Handle("/deploy", s.requireAuth(deployHandler)).Methods("POST")
+	r.Handle("/deploy/dockerhub", s.requireWebhook(webhook.SOURCE_DOCKERHUB, dockerhubHandler)).Methods("POST")
+	for _, source := range []string{webhook.SOURCE_GITHUB, webhook.SOURCE_GITLAB, webhook.SOURCE_HARBOR, webhook.SOURCE_QUAY} {
+		webhookHandler := NewWebhookHandler(source, s.releaseManager, s.notifier, s.authenticator)
+		r.Handle("/deploy/"+source, s.requireWebhook(source, webhookHandler)).Methods("POST")
+	}
+	for _, route := range s.routes {
+		routeHandler := NewRouteHandler(route, s.releaseManager, s.notifier, s.authenticator)
+		r.Handle(route.Path, s.requireRoute(route, routeHandler)).Methods("POST")
+	}
+	r.Handle("/rollback", s.requireAuth(rollbackHandler)).Methods("POST")
+	r.Handle("/promote", s.requireAuth(promoteHandler)).Methods("POST")
+	r.Handle("/deployments", s.requireAuth(deploymentHistoryHandler)).Methods("GET")
+	r.Handle("/deployments/{id}", s.requireAuth(deploymentsHandler)).Methods("GET")
 	http.Handle("/", r)
 
 	log.Println("Server started")
 	return http.ListenAndServe(s.listenAddr, nil)
 }
 
+// Rejects requests of unauthenticated clients, when auth is configured.
+// Handlers check what the client may deploy, or see
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	if !s.authenticator.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			handleServerError(err, http.StatusUnauthorized, r, w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithClient(r.Context(), client)))
+	})
+}
+
+// Webhooks of sources with a secret configured are checked against it, and
+// may deploy what the source is allowed. The others need an API client, as any
+// other request
+func (s *Server) requireWebhook(source string, next http.Handler) http.Handler {
+	if !s.webhooks.Configured(source) {
+		return s.requireAuth(next)
+	}
+
+	return s.requireVerified("webhook:"+source, func(r *http.Request) (*auth.Client, error) {
+		return s.webhooks.Verify(source, r)
+	}, next)
+}
+
+// Same as webhooks, for routes with a secret
+func (s *Server) requireRoute(route *webhook.Route, next http.Handler) http.Handler {
+	if !route.Secured() {
+		return s.requireAuth(next)
+	}
+
+	return s.requireVerified("route:"+route.Path, route.Verify, next)
+}
+
+func (s *Server) requireVerified(name string, verify func(*http.Request) (*auth.Client, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := verify(r)
+		if err != nil {
+			s.authenticator.Audit().Record(r, auth.EVENT_AUTHENTICATION_FAILED, name, "", "", err.Error())
+			handleServerError(err, http.StatusUnauthorized, r, w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithClient(r.Context(), client)))
+	})
+}
+
 func respondf(w http.ResponseWriter, format string, args ...interface{}) {
 	w.Write([]byte(fmt.Sprintf(format, args...)))
 }
 
+// Policy violations and refused promotions are the client's fault, other
+// deploy errors are ours
+func deployErrorStatus(err error) int {
+	switch err.(type) {
+	case *deploy.PolicyError, *deploy.PromotionError:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
 func handleServerError(err error, status int, r *http.Request, w http.ResponseWriter) {
 	log.Errorf("[%s] [status: %d] Error: %v", r.RemoteAddr, status, err)
 
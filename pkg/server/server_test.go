@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/services"
+	"github.com/valer-cara/mgo/pkg/webhook"
+)
+
+func TestRequireAuth(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(&auth.Config{
+		Clients: []auth.ClientConfig{
+			{Name: "ci", Token: "t0ken", Clusters: []string{"staging"}, TriggerRepos: []string{"*"}},
+			{Name: "bot", HmacSecret: "s3cret", Clusters: []string{"*"}, TriggerRepos: []string{"*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{authenticator: authenticator}
+	handler := s.requireAuth(DeployHandler{
+		releaseManager: &services.ReleaseManagerMock{},
+		authenticator:  authenticator,
+	})
+
+	form := func(cluster string) string {
+		data := url.Values{}
+		data.Set("triggerRepo", "github.com/a/repo1")
+		data.Set("imageRepo", "a/repo1")
+		data.Set("imageTag", "1.0.0")
+		data.Set("author", "xxx")
+		data.Set("cluster", cluster)
+		return data.Encode()
+	}
+
+	tests := []struct {
+		Cluster string
+		Sign    func(*http.Request, string)
+		Status  int
+	}{
+		{"staging", func(r *http.Request, body string) {}, http.StatusUnauthorized},
+		{"staging", func(r *http.Request, body string) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"staging", func(r *http.Request, body string) { r.Header.Set("Authorization", "Bearer t0ken") }, http.StatusOK},
+		{"prod", func(r *http.Request, body string) { r.Header.Set("Authorization", "Bearer t0ken") }, http.StatusForbidden},
+		{"prod", func(r *http.Request, body string) { auth.SignRequest(r, "bot", "s3cret", []byte(body), time.Now()) }, http.StatusOK},
+		{"prod", func(r *http.Request, body string) { auth.SignRequest(r, "bot", "wrong", []byte(body), time.Now()) }, http.StatusUnauthorized},
+	}
+
+	for testIdx, test := range tests {
+		body := form(test.Cluster)
+		req := httptest.NewRequest("POST", "/deploy", strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		test.Sign(req, body)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestRequireAuthDisabled(t *testing.T) {
+	s := &Server{}
+	handler := s.requireAuth(http.HandlerFunc(IndexHandler))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/deployments", nil))
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("Expected requests to go through without auth configured, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestRequireWebhook(t *testing.T) {
+	webhooks, err := webhook.NewVerifier(webhook.Config{
+		webhook.SOURCE_DOCKERHUB: {Secret: "dh-secret", Clusters: []string{"staging"}, TriggerRepos: []string{"*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := auth.NewAuthenticator(&auth.Config{
+		Clients: []auth.ClientConfig{{Name: "ci", Token: "t0ken", Clusters: []string{"*"}, TriggerRepos: []string{"*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, _ := json.Marshal(DockerhubWebhookPayload{
+		PushData:   DockerhubWebhookPayload_PushData{Tag: "1.0.0", Pusher: "matthew"},
+		Repository: DockerhubWebhookPayload_Repository{RepoName: "foo/bar"},
+	})
+
+	tests := []struct {
+		Webhooks *webhook.Verifier
+		Query    string
+		Header   string
+		Status   int
+	}{
+		{webhooks, "cluster=staging&secret=dh-secret", "", http.StatusOK},
+		{webhooks, "cluster=staging&secret=nope", "", http.StatusUnauthorized},
+		{webhooks, "cluster=prod&secret=dh-secret", "", http.StatusForbidden},
+		// The webhook secret, not the API clients, decides
+		{webhooks, "cluster=staging", "Bearer t0ken", http.StatusUnauthorized},
+		// No webhook secret: API clients only
+		{nil, "cluster=prod", "Bearer t0ken", http.StatusOK},
+		{nil, "cluster=prod&secret=dh-secret", "", http.StatusUnauthorized},
+	}
+
+	for testIdx, test := range tests {
+		s := &Server{authenticator: authenticator, webhooks: test.Webhooks}
+		handler := s.requireWebhook(webhook.SOURCE_DOCKERHUB, DockerhubHandler{
+			releaseManager: &services.ReleaseManagerMock{},
+			authenticator:  authenticator,
+		})
+
+		req := httptest.NewRequest("POST", "/deploy/dockerhub?triggerRepo=github.com/foo/bar&"+test.Query, bytes.NewReader(payload))
+		if test.Header != "" {
+			req.Header.Set("Authorization", test.Header)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestRequireRoute(t *testing.T) {
+	route, err := webhook.NewRoute(&webhook.RouteConfig{
+		Path:         "/hooks/ci",
+		TriggerRepo:  "{{ .repo }}",
+		Image:        "{{ .image }}",
+		Secret:       "s3cret",
+		TokenHeader:  "X-Token",
+		Clusters:     []string{"staging"},
+		TriggerRepos: []string{"*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{}
+	handler := s.requireRoute(route, NewRouteHandler(route, &services.ReleaseManagerMock{}, nil, nil))
+
+	tests := []struct {
+		Query  string
+		Token  string
+		Body   string
+		Status int
+	}{
+		{"cluster=staging", "s3cret", `{"repo": "github.com/foo/bar", "image": "foo/bar:1.0.0"}`, http.StatusOK},
+		{"cluster=staging", "nope", `{"repo": "github.com/foo/bar", "image": "foo/bar:1.0.0"}`, http.StatusUnauthorized},
+		{"cluster=prod", "s3cret", `{"repo": "github.com/foo/bar", "image": "foo/bar:1.0.0"}`, http.StatusForbidden},
+		{"", "s3cret", `{"repo": "github.com/foo/bar", "image": "foo/bar:1.0.0"}`, http.StatusBadRequest},
+		{"cluster=staging", "s3cret", `{"repo": "github.com/foo/bar", "image": "foo/bar"}`, http.StatusBadRequest},
+	}
+
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", "/hooks/ci?"+test.Query, strings.NewReader(test.Body))
+		req.Header.Set("X-Token", test.Token)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Result().StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, w.Result().StatusCode)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/notification"
+	"github.com/valer-cara/mgo/pkg/services"
+	"github.com/valer-cara/mgo/pkg/webhook"
+)
+
+// Deploys what a registry/forge webhook reports: `POST /deploy/{source}?cluster=`,
+// or a route declared in mygitops.yaml
+//
+// The image, the trigger repo and the author come from the payload. Events
+// that don't call for a deploy are answered with an `ignored` status
+type WebhookHandler struct {
+	source         string
+	parse          webhook.ParseFunc
+	releaseManager services.ReleaseManager
+	notification   notification.Notification
+	authenticator  *auth.Authenticator
+}
+
+func NewWebhookHandler(source string, releaseManager services.ReleaseManager, notifier notification.Notification, authenticator *auth.Authenticator) WebhookHandler {
+	return WebhookHandler{
+		source:         source,
+		parse:          webhook.Parsers[source],
+		releaseManager: releaseManager,
+		notification:   notifier,
+		authenticator:  authenticator,
+	}
+}
+
+func NewRouteHandler(route *webhook.Route, releaseManager services.ReleaseManager, notifier notification.Notification, authenticator *auth.Authenticator) WebhookHandler {
+	return WebhookHandler{
+		source:         route.Path,
+		parse:          route.Parse,
+		releaseManager: releaseManager,
+		notification:   notifier,
+		authenticator:  authenticator,
+	}
+}
+
+func (wh WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		handleServerError(err, http.StatusInternalServerError, r, w)
+		return
+	}
+
+	dopts, err := wh.parse(r, body)
+	if ignored, ok := err.(*webhook.IgnoredError); ok {
+		log.Printf("[%s] Ignoring %s webhook: %s", r.RemoteAddr, wh.source, ignored.Reason)
+		wh.respond(w, apiResponseDeploy{Status: "ignored", Error: ignored.Reason})
+		return
+	} else if err != nil {
+		handleServerError(err, http.StatusBadRequest, r, w)
+		return
+	}
+	if dopts.Cluster == "" {
+		dopts.Cluster = r.URL.Query().Get("cluster")
+	}
+	if dopts.Cluster == "" {
+		handleServerError(errors.New("missing http parameter `cluster`"), http.StatusBadRequest, r, w)
+		return
+	}
+
+	if err := wh.authenticator.Authorize(r, dopts.Cluster, dopts.TriggerRepo); err != nil {
+		handleServerError(err, http.StatusForbidden, r, w)
+		return
+	}
+
+	log.Printf("[%s] New %s deploy request: %s", r.RemoteAddr, wh.source, dopts)
+	if err := wh.releaseManager.RequestRelease(dopts); err != nil {
+		handleServerError(err, deployErrorStatus(err), r, w)
+		wh.sendNotification(dopts, err)
+		return
+	}
+
+	log.Printf("[%s] Deploy successful!", r.RemoteAddr)
+	wh.sendNotification(dopts, nil)
+
+	wh.respond(w, apiResponseDeploy{
+		Status: "ok",
+		ID:     dopts.ID,
+		Deploy: dopts,
+	})
+}
+
+func (wh WebhookHandler) respond(w http.ResponseWriter, body apiResponseDeploy) {
+	response, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		log.Errorf("Cannot encode response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
+
+func (wh WebhookHandler) sendNotification(dopts *deploy.DeployOptions, err error) {
+	if wh.notification == nil {
+		return
+	}
+
+	errNotif := wh.notification.Deployed(
+		dopts.TriggerRepo,
+		dopts.Image.Repository,
+		dopts.Image.Tag,
+		dopts.Cluster,
+		dopts.Author,
+		err,
+	)
+	if errNotif != nil {
+		log.Errorf("Error sending notification, err: %v", errNotif)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/services"
+	"github.com/valer-cara/mgo/pkg/webhook"
+)
+
+const testQuayPayload = `{"docker_url": "quay.io/acme/web-app", "updated_tags": ["4.0.0"]}`
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		URL            string
+		Payload        string
+		ReleaseManager services.ReleaseManager
+		Status         int
+		ResponseStatus string
+	}{
+		{"/deploy/quay?cluster=prod", testQuayPayload, &services.ReleaseManagerMock{}, http.StatusOK, "ok"},
+		{"/deploy/quay?cluster=prod", `{"docker_url": "quay.io/acme/web-app"}`, &services.ReleaseManagerMock{}, http.StatusOK, "ignored"},
+		{"/deploy/quay", testQuayPayload, &services.ReleaseManagerMock{}, http.StatusBadRequest, "error"},
+		{"/deploy/quay?cluster=prod", `not json`, &services.ReleaseManagerMock{}, http.StatusBadRequest, "error"},
+		{"/deploy/quay?cluster=prod", testQuayPayload, &services.ReleaseManagerMock{RequestReleaseError: errors.New("request_release")}, http.StatusInternalServerError, "error"},
+	}
+
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", test.URL, strings.NewReader(test.Payload))
+		w := httptest.NewRecorder()
+
+		NewWebhookHandler(webhook.SOURCE_QUAY, test.ReleaseManager, nil, nil).ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != test.Status {
+			t.Fatalf("[test %d] Expected status %d, got %d", testIdx, test.Status, resp.StatusCode)
+		}
+
+		body := apiResponseDeploy{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Status != test.ResponseStatus {
+			t.Fatalf("[test %d] Expected status '%s', got %+v", testIdx, test.ResponseStatus, body)
+		}
+		if body.Status == "ok" && (body.Deploy.Cluster != "prod" || body.Deploy.TriggerRepo != "quay.io/acme/web-app" || body.Deploy.Image.Tag != "4.0.0") {
+			t.Fatalf("[test %d] Unexpected deploy %+v", testIdx, body.Deploy)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/valer-cara/mgo/pkg/config"
 	"github.com/valer-cara/mgo/pkg/deploy"
 	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/registry"
 )
 
 // Wraps functionality to create a new deployment
@@ -22,26 +24,48 @@ func NewDeployService(gitopsRepo string, dopts *deploy.DeployOptions) *DeploySer
 }
 
 func (ds *DeployService) Execute() error {
-	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, ds.gitopsRepo)
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, ds.gitopsRepo, &config.Global.Git)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Cannot initialize git service on %s", ds.gitopsRepo))
 	}
 
-	dpl := deploy.NewDeploy(gitService, &deploy.MyUpdater{}, ds.dopts)
-
-	err = dpl.Create()
+	layout, err := config.Global.RepoLayout()
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot create deployment: %v", err))
+		return err
 	}
 
-	return nil
+	if err := resolveDigest(registry.NewClient(config.Global.Registries), ds.dopts); err != nil {
+		return err
+	}
+
+	dpl := deploy.NewDeploy(gitService, &deploy.MyUpdater{Layout: layout}, ds.dopts)
+
+	return deploy.WrapCreateError(dpl.Create())
 }
 
 func (ds *DeployService) String() string {
-	return fmt.Sprintf("DeployService(cluster: %s, author: %s, image: %s:%s)",
+	return fmt.Sprintf("DeployService(cluster: %s, author: %s, image: %s)",
 		ds.dopts.Cluster,
 		ds.dopts.Author,
-		ds.dopts.Image.Repository,
-		ds.dopts.Image.Tag,
+		ds.dopts.Image,
 	)
 }
+
+// Pin the image to the digest of its tag, when the request or the config asks
+// for it. Rollbacks and promotions keep the image they go back to or copy
+func resolveDigest(registryClient *registry.Client, dopts *deploy.DeployOptions) error {
+	if dopts.Rollback || dopts.PromoteFrom != "" || dopts.Image.Digest != "" || !(dopts.ResolveDigest || config.Global.ResolveDigests) {
+		return nil
+	}
+	if dopts.Image.Tag == "" {
+		return errors.New(fmt.Sprintf("Cannot resolve the digest of %s: no tag", dopts.Image.Repository))
+	}
+
+	digest, err := registryClient.Digest(dopts.Image.Repository, dopts.Image.Tag)
+	if err != nil {
+		return err
+	}
+	dopts.Image.Digest = digest
+
+	return nil
+}
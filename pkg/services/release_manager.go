@@ -1,7 +1,10 @@
 package services
 
 import (
+	"github.com/valer-cara/mgo/pkg/async"
 	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/journal"
 )
 
 // ReleaseManager: Aggregates multiple requests to deploy, does each
@@ -9,5 +12,14 @@ import (
 // Should be global
 type ReleaseManager interface {
 	Init() error
+	// Deploy and wait until the cluster is synced. For rollbacks, the image
+	// is filled in once resolved
 	RequestRelease(*deploy.DeployOptions) error
+	// Queue the deploy and return right away. The deploy gets an ID and the
+	// result is signaled once the cluster is synced
+	QueueRelease(*deploy.DeployOptions) (*async.Result, error)
+	// Where a deploy request is at
+	Deployment(id string) (*journal.Entry, bool)
+	// Deploys pushed to the gitops repo, newest first
+	History(*history.Filter) ([]*history.Record, error)
 }
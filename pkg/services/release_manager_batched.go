@@ -6,13 +6,23 @@ import (
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/valer-cara/mgo/pkg/async"
 	btch "github.com/valer-cara/mgo/pkg/batcher"
 	"github.com/valer-cara/mgo/pkg/config"
 	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/forge"
 	"github.com/valer-cara/mgo/pkg/git"
 	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/journal"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/registry"
+	"github.com/valer-cara/mgo/pkg/secrets"
 	clusterSync "github.com/valer-cara/mgo/pkg/sync"
 	"github.com/valer-cara/mgo/pkg/util"
 
@@ -27,25 +37,57 @@ import (
 // 2. goes over all queued deployments and creates a commit for each
 // 3. syncs all affected clusters and returns the corresponding statuses
 //
+// Deploys to the clusters listed in `pullRequests` are committed on a branch of
+// their own instead, and a pull request is opened for it. Their cluster is
+// synced once it's merged.
+//
+// Every request is tracked in a journal. Requests that were queued or in flight
+// when the server stopped are replayed by Init(): those whose commit already
+// made it to the remote are only synced, the others are committed again.
 type ReleaseManagerBatched struct {
 	options *ReleaseManagerBatchedOptions
 
 	// dependent services
+	layout       manifest.Layout
 	gitService   *git.Git
 	syncServices map[string]*clusterSync.Sync
 	helmServices map[string]helm.HelmService
+	registry     *registry.Client
 
 	// batcher
 	batcher       *btch.Batcher
 	chanBatchDone chan bool
 	chanBatchErr  chan error
 
+	journal *journal.Journal
+	// Deploys committed in the current batch. Only touched from the batcher
+	// goroutine (jobs and hooks)
+	batchResults []*async.Result
+
 	clusterSyncWaitlists map[string]*async.Waitlist
+
+	// Set when some clusters deploy through pull requests
+	forge forge.Forge
+	// Deploys waiting for their pull request to be merged, by ID
+	reviewsMu sync.Mutex
+	reviews   map[string]*review
 }
 
+type review struct {
+	dopts       *deploy.DeployOptions
+	result      *async.Result
+	pullRequest *forge.PullRequest
+}
+
+// Deploys going through a pull request are committed on `mgo/deploy/<id>`
+const DEPLOY_BRANCH_PREFIX = "mgo/deploy/"
+
 type ReleaseManagerBatchedOptions struct {
 	GitopsRepo, KubeConfig, HelmHome string
 	DryRun                           bool
+
+	// Where the deploy journal is kept. Empty means in memory only
+	StateDir string
 }
 
 type kubeconfigClusters struct {
@@ -61,11 +103,14 @@ func NewReleaseManagerBatched(opts *ReleaseManagerBatchedOptions) *ReleaseManage
 		options:       opts,
 		syncServices:  make(map[string]*clusterSync.Sync),
 		helmServices:  make(map[string]helm.HelmService),
+		registry:      registry.NewClient(config.Global.Registries),
 		chanBatchDone: chanBatchDone,
 		chanBatchErr:  chanBatchErr,
 
 		// maps clusterName -> array of async results
 		clusterSyncWaitlists: make(map[string]*async.Waitlist),
+
+		reviews: make(map[string]*review),
 	}
 
 	batcherOpts := &btch.BatcherOptions{
@@ -83,20 +128,60 @@ func NewReleaseManagerBatched(opts *ReleaseManagerBatchedOptions) *ReleaseManage
 }
 
 func (r *ReleaseManagerBatched) Init() error {
+	layout, err := config.Global.RepoLayout()
+	if err != nil {
+		return err
+	}
+	r.layout = layout
+
 	// Init git service
-	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, r.options.GitopsRepo)
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, r.options.GitopsRepo, &config.Global.Git)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Cannot initialize git service on %s", r.options.GitopsRepo))
 	}
 	r.gitService = gitService
 
+	if config.Global.PullRequests.Enabled() {
+		forgeClient, err := forge.NewForge(&config.Global.PullRequests)
+		if err != nil {
+			return err
+		}
+		r.forge = forgeClient
+	}
+
 	if err := r.initPerClusterServices(); err != nil {
 		return errors.New(fmt.Sprintf("Cannot determine available kubernetes clusters: %v", err))
 	}
 
+	return r.start()
+}
+
+// Open the journal, start processing and replay what's pending
+func (r *ReleaseManagerBatched) start() error {
+	if r.options.StateDir == "" {
+		log.Warnln("No state dir set, queued deploys will be lost on restart")
+	}
+	deployJournal, err := journal.NewJournal(r.options.StateDir)
+	if err != nil {
+		return err
+	}
+	r.journal = deployJournal
+
 	go r.batcher.Start()
 	go r.monitorBatch()
 
+	if r.forge != nil {
+		interval, err := config.Global.PullRequests.Interval()
+		if err != nil {
+			return err
+		}
+		go r.pollPullRequests(interval)
+	}
+
+	if err := r.replay(); err != nil {
+		return errors.New(fmt.Sprintf("Cannot replay pending deploys: %v", err))
+	}
+
 	return nil
 }
 
@@ -120,8 +205,13 @@ func (r *ReleaseManagerBatched) initPerClusterServices() error {
 			return errors.New(fmt.Sprintf("Cannot initialize helm service for cluster %s: %v", cluster.Name, err))
 		}
 
+		kubectlService, err := r.initKubectlService(cluster.Name)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot initialize kubectl service for cluster %s: %v", cluster.Name, err))
+		}
+
 		r.helmServices[cluster.Name] = helmService
-		r.syncServices[cluster.Name] = clusterSync.NewSync(r.options.GitopsRepo, cluster.Name, helmService)
+		r.syncServices[cluster.Name] = clusterSync.NewSync(r.options.GitopsRepo, cluster.Name, r.layout, helmService, kubectlService, secrets.NewSopsCmd(&config.Global.Secrets))
 		r.clusterSyncWaitlists[cluster.Name] = async.NewWaitlist()
 	}
 
@@ -129,13 +219,17 @@ func (r *ReleaseManagerBatched) initPerClusterServices() error {
 }
 
 func (r *ReleaseManagerBatched) initHelmService(cluster string) (helm.HelmService, error) {
-	helmService := helm.NewHelmCmd(&helm.HelmCmdOptions{
+	helmService, err := helm.NewHelmService(&helm.HelmServiceOptions{
+		Backend:      config.Global.Helm.Backend,
 		DryRun:       r.options.DryRun,
 		HelmHome:     r.options.HelmHome,
 		Kubeconfig:   r.options.KubeConfig,
 		Kubecontext:  cluster,
 		Repositories: config.Global.Helm.Repositories,
 	})
+	if err != nil {
+		return nil, err
+	}
 	if err := helmService.Init(); err != nil {
 		return nil, err
 	}
@@ -143,39 +237,140 @@ func (r *ReleaseManagerBatched) initHelmService(cluster string) (helm.HelmServic
 	return helmService, nil
 }
 
-func (r *ReleaseManagerBatched) RequestRelease(dopts *deploy.DeployOptions) error {
-	if r.syncServices[dopts.Cluster] == nil {
-		return errors.New("Requested cluster is not managed by this instance of mygitops. Check `cluster` parameter.")
+func (r *ReleaseManagerBatched) initKubectlService(cluster string) (kubectl.KubectlService, error) {
+	kubectlService := kubectl.NewKubectlCmd(&kubectl.KubectlCmdOptions{
+		DryRun:      r.options.DryRun,
+		Kubeconfig:  r.options.KubeConfig,
+		Kubecontext: cluster,
+	})
+	if err := kubectlService.Init(); err != nil {
+		return nil, err
 	}
 
-	job := r.newDeployJob(dopts)
-	chanJobDone, chanJobError := make(chan bool), make(chan error)
-	clusterSyncResult := async.NewResult()
+	return kubectlService, nil
+}
 
-	r.batcher.Queue(job, chanJobDone, chanJobError)
+func (r *ReleaseManagerBatched) RequestRelease(dopts *deploy.DeployOptions) error {
+	result, err := r.QueueRelease(dopts)
+	if err != nil {
+		return err
+	}
 
-	// await deployment committed
+	// await deployment committed & synced to cluster
 	select {
-	case <-chanJobDone:
-		r.clusterSyncWaitlists[dopts.Cluster].Add(clusterSyncResult)
-	case err := <-chanJobError:
+	case <-result.Done:
+		return nil
+	case err := <-result.Err:
 		return err
 	}
+}
 
-	// await deployment synced to cluster
-	select {
-	case <-clusterSyncResult.Done:
+func (r *ReleaseManagerBatched) QueueRelease(dopts *deploy.DeployOptions) (*async.Result, error) {
+	if r.syncServices[dopts.Cluster] == nil {
+		return nil, errors.New("Requested cluster is not managed by this instance of mygitops. Check `cluster` parameter.")
+	}
+
+	if dopts.PromoteFrom != "" && !deploy.CanPromote(config.Global.Promotions, dopts.PromoteFrom, dopts.Cluster) {
+		return nil, &deploy.PromotionError{
+			TriggerRepo: dopts.TriggerRepo,
+			From:        dopts.PromoteFrom,
+			To:          dopts.Cluster,
+			Reason:      "no such promotion in mygitops.yaml",
+		}
+	}
+
+	// Before journaling, so replays deploy the same image
+	if err := resolveDigest(r.registry, dopts); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.journal.Queue(dopts); err != nil {
+		return nil, err
+	}
+
+	return r.queue(dopts, r.newDeployJob), nil
+}
+
+func (r *ReleaseManagerBatched) Deployment(id string) (*journal.Entry, bool) {
+	return r.journal.Get(id)
+}
+
+func (r *ReleaseManagerBatched) History(filter *history.Filter) ([]*history.Record, error) {
+	return history.List(r.gitService, r.gitService.RemoteRef(), filter)
+}
+
+// Queue a job for the deploy. The result is signaled once the cluster synced,
+// or as soon as the job fails
+func (r *ReleaseManagerBatched) queue(dopts *deploy.DeployOptions, newJob func(*deploy.DeployOptions, *async.Result) btch.Job) *async.Result {
+	result := async.NewResult(dopts.ID)
+	r.enqueue(dopts, result, newJob)
+	return result
+}
+
+// Same as queue(), for a result already handed out
+func (r *ReleaseManagerBatched) enqueue(dopts *deploy.DeployOptions, result *async.Result, newJob func(*deploy.DeployOptions, *async.Result) btch.Job) {
+	chanJobDone, chanJobError := make(chan bool, 1), make(chan error, 1)
+
+	r.batcher.Queue(newJob(dopts, result), chanJobDone, chanJobError)
+
+	go func() {
+		select {
+		case <-chanJobDone:
+		case err := <-chanJobError:
+			r.record(dopts.ID, journal.STAGE_FAILED, err)
+			result.Err <- err
+		}
+	}()
+}
+
+// Pick up the deploys that didn't finish before the last shutdown. Deploy
+// commits are found on the remote by their trailer, so a deploy is never
+// committed twice
+func (r *ReleaseManagerBatched) replay() error {
+	pending := r.journal.Pending()
+	if len(pending) == 0 {
 		return nil
-	case err := <-clusterSyncResult.Err:
+	}
+
+	if err := r.gitService.Fetch(); err != nil {
 		return err
 	}
 
+	for _, entry := range pending {
+		dopts := entry.Deploy
+
+		if r.syncServices[dopts.Cluster] == nil {
+			r.record(entry.ID, journal.STAGE_FAILED, errors.New("Cluster is no longer managed by this instance of mygitops"))
+			continue
+		}
+
+		sha, err := r.gitService.FindCommit(r.gitService.RemoteRef(), dopts.Trailer())
+		if err != nil {
+			return err
+		}
+
+		if sha == "" && entry.PullRequest != nil && r.forge != nil {
+			log.Printf("Deploy %s is still waiting for %s to be merged", entry.ID, entry.PullRequest.URL)
+			r.watch(dopts, async.NewResult(entry.ID), entry.PullRequest)
+		} else if sha == "" {
+			log.Printf("Replaying deploy %s (%s)", entry.ID, dopts)
+			r.record(entry.ID, journal.STAGE_QUEUED, nil)
+			r.queue(dopts, r.newDeployJob)
+		} else {
+			log.Printf("Deploy %s already pushed as %s, syncing cluster %s", entry.ID, sha, dopts.Cluster)
+			r.recordCommit(entry.ID, journal.STAGE_PUSHED, sha)
+			r.queue(dopts, r.newSyncJob)
+		}
+	}
+
 	return nil
 }
 
 // Update local gitops repository, preparing for new deploy-related edits
 func (r *ReleaseManagerBatched) createPreBatchHook() func() error {
 	return func() error {
+		r.batchResults = nil
+
 		err := util.CallFunctions(
 			func() error { return r.gitService.Fetch() },
 			func() error { return r.gitService.Reset() }, // --hard origin master (or branch)
@@ -188,34 +383,52 @@ func (r *ReleaseManagerBatched) createPreBatchHook() func() error {
 // Update local gitops repository, preparing for new deploy-related edits
 func (r *ReleaseManagerBatched) createPostBatchHook() func() error {
 	return func() error {
-		// Push updates, and retry a few times while doing a `git pull -r`
-		err := retry.Do(
-			r.gitService.Push,
-			retry.Attempts(4),
-			retry.OnRetry(func(n uint, err error) {
-				log.Warnln("Retrying git push: ", err)
-				if err := r.gitService.Pull("-r"); err != nil {
-					log.Warnln("Git pull -r failed: ", err)
-				}
-			}),
-		)
+		err := r.push()
 		if err != nil {
+			for _, waitlist := range r.clusterSyncWaitlists {
+				r.recordAll(waitlist, journal.STAGE_FAILED, err)
+				waitlist.AllError(err)
+				waitlist.Clear()
+			}
 			return err
 		}
 
+		// `pull -r` may have rebased the commits, look them up again
+		for _, result := range r.batchResults {
+			sha, err := r.gitService.FindCommit("HEAD", deploy.Trailer(result.ID))
+			if err != nil {
+				log.Warnf("Cannot find commit of deploy %s: %v", result.ID, err)
+			}
+			r.recordCommit(result.ID, journal.STAGE_PUSHED, sha)
+		}
+
 		for cluster, waitlist := range r.clusterSyncWaitlists {
 			if !waitlist.IsEmpty() {
 				log.Printf("Syncing cluster %s", cluster)
+				r.recordAll(waitlist, journal.STAGE_SYNCING, nil)
 
+				var rollback *RollbackError
 				err := r.syncCluster(cluster)
 				if err != nil {
-					waitlist.AllError(err)
+					rollback, err = r.rollBack(cluster, waitlist, err)
+				}
+				if err != nil {
 					log.Errorf("Error syncing cluster %s: %s", cluster, err)
 				} else {
-					waitlist.AllDone()
+					r.markSynced(cluster)
 					log.Printf("Done syncing cluster %s", cluster)
 				}
 
+				// Reverted deploys fail whatever the cluster ended up like,
+				// the others go by how it last synced
+				for _, result := range waitlist.Results() {
+					if rollback != nil && rollback.reverted(result.ID) {
+						r.finish(result, rollback)
+					} else {
+						r.finish(result, err)
+					}
+				}
+
 				r.clusterSyncWaitlists[cluster].Clear()
 			}
 		}
@@ -224,6 +437,20 @@ func (r *ReleaseManagerBatched) createPostBatchHook() func() error {
 	}
 }
 
+// Push updates, and retry a few times while doing a `git pull -r`
+func (r *ReleaseManagerBatched) push() error {
+	return retry.Do(
+		r.gitService.Push,
+		retry.Attempts(4),
+		retry.OnRetry(func(n uint, err error) {
+			log.Warnln("Retrying git push: ", err)
+			if err := r.gitService.Pull("-r"); err != nil {
+				log.Warnln("Git pull -r failed: ", err)
+			}
+		}),
+	)
+}
+
 func (r *ReleaseManagerBatched) syncCluster(cluster string) error {
 	err := r.helmServices[cluster].UpdateRepos()
 	if err != nil {
@@ -233,6 +460,130 @@ func (r *ReleaseManagerBatched) syncCluster(cluster string) error {
 	return r.syncServices[cluster].Sync()
 }
 
+// A failed sync, and how rolling back the deploys it was syncing went
+type RollbackError struct {
+	Cluster string
+	// Why the sync failed
+	Err error
+	// Deploys reverted
+	Reverted []string
+	// Why rolling back failed, if it did
+	RollbackErr error
+}
+
+func (e *RollbackError) reverted(id string) bool {
+	for _, reverted := range e.Reverted {
+		if reverted == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RollbackError) Error() string {
+	syncErr := strings.TrimSpace(e.Err.Error())
+
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%s\nRolling back deploys %s on %s failed: %v", syncErr, strings.Join(e.Reverted, ", "), e.Cluster, e.RollbackErr)
+	}
+	return fmt.Sprintf("%s\nRolled back deploys %s on %s", syncErr, strings.Join(e.Reverted, ", "), e.Cluster)
+}
+
+// Reverts the deploys of the batch to a cluster whose sync failed, those the
+// cluster or their release opted in for, then syncs again. Returns how the
+// rollback went, nil if there was nothing to roll back, and the error of the
+// cluster's last sync: the one after reverting, if it got that far
+func (r *ReleaseManagerBatched) rollBack(cluster string, waitlist *async.Waitlist, syncErr error) (*RollbackError, error) {
+	deploys := r.rollbackCandidates(cluster, waitlist)
+	if len(deploys) == 0 {
+		return nil, syncErr
+	}
+
+	rollback := &RollbackError{Cluster: cluster, Err: syncErr}
+	shas := make([]string, len(deploys))
+	for idx, entry := range deploys {
+		rollback.Reverted = append(rollback.Reverted, entry.ID)
+		// Newest first
+		shas[len(deploys)-1-idx] = entry.Commit
+	}
+	log.Warnf("Syncing cluster %s failed, rolling back deploys %s", cluster, strings.Join(rollback.Reverted, ", "))
+
+	rollback.RollbackErr = util.CallFunctions(
+		func() error { return r.gitService.Revert(shas...) },
+		func() error { return r.gitService.Commit(deploy.RevertMessage(cluster, rollback.Reverted, syncErr)) },
+		r.push,
+	)
+	if rollback.RollbackErr != nil {
+		return rollback, syncErr
+	}
+
+	rollback.RollbackErr = r.syncCluster(cluster)
+	return rollback, rollback.RollbackErr
+}
+
+// The deploys committed in this batch and waiting for the cluster, in the
+// order they were committed, that may be rolled back automatically. Deploys
+// merged through a pull request aren't: they went through a review, so does
+// undoing them
+func (r *ReleaseManagerBatched) rollbackCandidates(cluster string, waitlist *async.Waitlist) []*journal.Entry {
+	waiting := map[string]bool{}
+	for _, result := range waitlist.Results() {
+		waiting[result.ID] = true
+	}
+
+	wholeCluster := false
+	for _, c := range config.Global.AutoRollback {
+		wholeCluster = wholeCluster || c == cluster
+	}
+
+	var candidates []*journal.Entry
+	for _, result := range r.batchResults {
+		entry, ok := r.journal.Get(result.ID)
+		if !ok || !waiting[result.ID] || entry.Commit == "" || entry.Deploy == nil {
+			continue
+		}
+		if wholeCluster || r.releaseAutoRollback(entry.Deploy) {
+			candidates = append(candidates, entry)
+		}
+	}
+	return candidates
+}
+
+// Whether the release the deploy updated has `autoRollback` set
+func (r *ReleaseManagerBatched) releaseAutoRollback(dopts *deploy.DeployOptions) bool {
+	manifests, err := manifest.FindManifests(r.layout, r.gitService.Root(), dopts.Cluster)
+	if err != nil {
+		log.Warnf("Cannot find the releases of cluster %s: %v", dopts.Cluster, err)
+		return false
+	}
+
+	for _, path := range manifests.Helm {
+		header, err := manifest.ParseHeader(path)
+		if err != nil || header == nil {
+			continue
+		}
+		if _, ok := header.Images[dopts.TriggerRepo]; ok && header.AutoRollback {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the commit the cluster synced, the one promotions go by. Failing to
+// do so only holds promotions back
+func (r *ReleaseManagerBatched) markSynced(cluster string) {
+	sha, err := r.gitService.Head()
+	if err == nil {
+		err = r.gitService.UpdateRef(deploy.SyncedRef(cluster), sha)
+	}
+	if err == nil {
+		err = r.gitService.PushRefs(deploy.SyncedRef(cluster))
+	}
+	if err != nil {
+		log.Warnf("Cannot record the commit synced to cluster %s: %v", cluster, err)
+	}
+}
+
 func (r *ReleaseManagerBatched) monitorBatch() {
 	for {
 		select {
@@ -244,17 +595,202 @@ func (r *ReleaseManagerBatched) monitorBatch() {
 	}
 }
 
-func (r *ReleaseManagerBatched) newDeployJob(dopts *deploy.DeployOptions) btch.Job {
+// Commits the deploy and puts it on its cluster's sync waitlist. Rollbacks and
+// promotions are resolved here, against the freshly fetched repo and the
+// deploys committed earlier in the batch
+func (r *ReleaseManagerBatched) newDeployJob(dopts *deploy.DeployOptions, result *async.Result) btch.Job {
 	return func() error {
-		log.Debugln("NewDeploy:", dopts.String())
+		if dopts.Rollback {
+			if err := history.ResolveRollback(r.gitService, "HEAD", dopts); err != nil {
+				return err
+			}
+			r.recordDeploy(dopts)
+		} else if dopts.PromoteFrom != "" {
+			if err := deploy.ResolvePromotion(r.gitService, r.layout, dopts); err != nil {
+				return err
+			}
+			r.recordDeploy(dopts)
+		}
+
+		if r.reviewed(dopts.Cluster) {
+			return r.openPullRequest(dopts, result)
+		}
+
+		if err := r.createDeploy(dopts); err != nil {
+			return err
+		}
+
+		r.batchResults = append(r.batchResults, result)
+		r.clusterSyncWaitlists[dopts.Cluster].Add(result)
+
+		return nil
+	}
+}
+
+func (r *ReleaseManagerBatched) createDeploy(dopts *deploy.DeployOptions) error {
+	log.Debugln("NewDeploy:", dopts.String())
+
+	dpl := deploy.NewDeploy(r.gitService, &deploy.MyUpdater{Layout: r.layout}, dopts)
+
+	if err := deploy.WrapCreateError(dpl.Create()); err != nil {
+		return err
+	}
+
+	sha, err := r.gitService.Head()
+	if err != nil {
+		log.Warnf("Cannot determine commit of deploy %s: %v", dopts.ID, err)
+	}
+	r.recordCommit(dopts.ID, journal.STAGE_COMMITTED, sha)
+
+	return nil
+}
+
+// Whether deploys to the cluster go through a pull request
+func (r *ReleaseManagerBatched) reviewed(cluster string) bool {
+	return r.forge != nil && config.Global.PullRequests.Reviewed(cluster)
+}
+
+// Commit the deploy on a branch of its own, off the remote branch, and open a
+// pull request for it. The result is signaled once it's merged and synced, see
+// checkPullRequests()
+func (r *ReleaseManagerBatched) openPullRequest(dopts *deploy.DeployOptions, result *async.Result) error {
+	branch := DEPLOY_BRANCH_PREFIX + dopts.ID
+
+	err := util.CallFunctions(
+		func() error { return r.gitService.Checkout(branch, r.gitService.RemoteRef()) },
+		func() error { return r.createDeploy(dopts) },
+		func() error { return r.gitService.PushBranch(branch) },
+	)
+
+	// Back to the branch the rest of the batch is committed on
+	if errCheckout := r.gitService.Checkout(r.gitService.Branch(), ""); errCheckout != nil {
+		log.Errorf("Cannot check out %s after committing deploy %s: %v", r.gitService.Branch(), dopts.ID, errCheckout)
+		if err == nil {
+			err = errCheckout
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	message := strings.SplitN(deploy.CommitMessage(dopts), "\n\n", 2)
+	pullRequest, err := r.forge.Open(branch, r.gitService.Branch(), message[0], message[len(message)-1])
+	if err != nil {
+		return err
+	}
 
-		dpl := deploy.NewDeploy(r.gitService, &deploy.MyUpdater{}, dopts)
+	log.Printf("Deploy %s is waiting for %s to be merged", dopts.ID, pullRequest.URL)
+	err = r.journal.Update(dopts.ID, func(e *journal.Entry) {
+		e.Stage = journal.STAGE_REVIEW
+		e.PullRequest = pullRequest
+	})
+	if err != nil {
+		log.Errorf("Cannot record pull request of deploy %s: %v", dopts.ID, err)
+	}
+
+	r.watch(dopts, result, pullRequest)
+	return nil
+}
+
+func (r *ReleaseManagerBatched) watch(dopts *deploy.DeployOptions, result *async.Result, pullRequest *forge.PullRequest) {
+	r.reviewsMu.Lock()
+	r.reviews[dopts.ID] = &review{dopts: dopts, result: result, pullRequest: pullRequest}
+	r.reviewsMu.Unlock()
+}
+
+func (r *ReleaseManagerBatched) pollPullRequests(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.checkPullRequests()
+	}
+}
+
+// Sync the clusters of the deploys whose pull request was merged. Those closed
+// without merging fail
+func (r *ReleaseManagerBatched) checkPullRequests() {
+	r.reviewsMu.Lock()
+	reviews := []*review{}
+	for _, review := range r.reviews {
+		reviews = append(reviews, review)
+	}
+	r.reviewsMu.Unlock()
 
-		err := dpl.Create()
+	for _, review := range reviews {
+		state, err := r.forge.State(review.pullRequest.Number)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Cannot create deployment: %v", err))
+			log.Warnf("Cannot check pull request of deploy %s: %v", review.dopts.ID, err)
+			continue
+		}
+		if state == forge.STATE_OPEN {
+			continue
 		}
 
+		r.reviewsMu.Lock()
+		delete(r.reviews, review.dopts.ID)
+		r.reviewsMu.Unlock()
+
+		if state == forge.STATE_MERGED {
+			log.Printf("%s merged, syncing cluster %s", review.pullRequest.URL, review.dopts.Cluster)
+			r.record(review.dopts.ID, journal.STAGE_PUSHED, nil)
+			r.enqueue(review.dopts, review.result, r.newSyncJob)
+		} else {
+			err := errors.New(fmt.Sprintf("Pull request %s was closed without merging", review.pullRequest.URL))
+			r.record(review.dopts.ID, journal.STAGE_FAILED, err)
+			review.result.Err <- err
+		}
+	}
+}
+
+// For deploys already pushed: only puts the deploy on its cluster's sync waitlist
+func (r *ReleaseManagerBatched) newSyncJob(dopts *deploy.DeployOptions, result *async.Result) btch.Job {
+	return func() error {
+		r.clusterSyncWaitlists[dopts.Cluster].Add(result)
 		return nil
 	}
 }
+
+// Journal write errors don't stop a deploy, they only make it harder to pick
+// up after a restart
+func (r *ReleaseManagerBatched) record(id, stage string, err error) {
+	if errJournal := r.journal.SetStage(id, stage, err); errJournal != nil {
+		log.Errorf("Cannot record stage %s of deploy %s: %v", stage, id, errJournal)
+	}
+}
+
+func (r *ReleaseManagerBatched) recordCommit(id, stage, sha string) {
+	err := r.journal.Update(id, func(e *journal.Entry) {
+		e.Stage = stage
+		if sha != "" {
+			e.Commit = sha
+		}
+	})
+	if err != nil {
+		log.Errorf("Cannot record stage %s of deploy %s: %v", stage, id, err)
+	}
+}
+
+func (r *ReleaseManagerBatched) recordDeploy(dopts *deploy.DeployOptions) {
+	stored := *dopts
+	err := r.journal.Update(dopts.ID, func(e *journal.Entry) {
+		e.Deploy = &stored
+	})
+	if err != nil {
+		log.Errorf("Cannot record deploy %s: %v", dopts.ID, err)
+	}
+}
+
+// Signal the deploy's outcome, and journal it
+func (r *ReleaseManagerBatched) finish(result *async.Result, err error) {
+	if err != nil {
+		r.record(result.ID, journal.STAGE_FAILED, err)
+		result.Err <- err
+	} else {
+		r.record(result.ID, journal.STAGE_DONE, nil)
+		result.Done <- true
+	}
+}
+
+func (r *ReleaseManagerBatched) recordAll(waitlist *async.Waitlist, stage string, err error) {
+	for _, result := range waitlist.Results() {
+		r.record(result.ID, stage, err)
+	}
+}
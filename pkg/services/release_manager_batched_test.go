@@ -0,0 +1,628 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valer-cara/mgo/pkg/async"
+	"github.com/valer-cara/mgo/pkg/config"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/forge"
+	"github.com/valer-cara/mgo/pkg/git"
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/journal"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/registry"
+	"github.com/valer-cara/mgo/pkg/secrets"
+	clusterSync "github.com/valer-cara/mgo/pkg/sync"
+	"github.com/valer-cara/mgo/pkg/testutils"
+	"github.com/valer-cara/mgo/pkg/util"
+)
+
+const testCluster = "myprodcluster"
+
+// A release manager on a sample repo, syncing through fake helm/kubectl
+func newTestReleaseManager(t *testing.T, repo, stateDir string) *ReleaseManagerBatched {
+	r := NewReleaseManagerBatched(&ReleaseManagerBatchedOptions{
+		GitopsRepo: repo,
+		StateDir:   stateDir,
+	})
+
+	gitService, err := git.NewGit(git.BACKEND_EXTERNAL, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.layout = &manifest.DefaultLayout{}
+	r.gitService = gitService
+	addTestCluster(r, repo, testCluster)
+
+	return r
+}
+
+func addTestCluster(r *ReleaseManagerBatched, repo, cluster string) {
+	helmService := &helm.HelmFake{}
+	r.helmServices[cluster] = helmService
+	r.syncServices[cluster] = clusterSync.NewSync(repo, cluster, r.layout, helmService, &kubectl.KubectlFake{}, &secrets.DecrypterFake{})
+	r.clusterSyncWaitlists[cluster] = async.NewWaitlist()
+}
+
+func newTestDeployOptions(tag string) *deploy.DeployOptions {
+	return &deploy.DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Author:      "Ronaldo",
+		Cluster:     testCluster,
+		Image:       deploy.DeployOptionsImage{Repository: "a/repo1", Tag: tag},
+	}
+}
+
+func countPushedCommits(t *testing.T, repo, id string) int {
+	out, err := exec.Command("git", "-C", repo, "log", "origin/master", "--format=%H", "--fixed-strings", "--grep="+deploy.Trailer(id)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(strings.Fields(string(out)))
+}
+
+func waitFinished(t *testing.T, j *journal.Journal, ids ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for {
+			entry, _ := j.Get(id)
+			if entry.Finished() {
+				if entry.Stage != journal.STAGE_DONE {
+					t.Fatalf("Deploy %s failed: %s", id, entry.Error)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Deploy %s stuck in stage %s", id, entry.Stage)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestRequestReleaseJournaled(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	r := newTestReleaseManager(t, repo, t.TempDir())
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	dopts := newTestDeployOptions("1.1.0")
+	if err := r.RequestRelease(dopts); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _ := r.journal.Get(dopts.ID)
+	if entry.Stage != journal.STAGE_DONE || entry.Commit == "" {
+		t.Fatalf("Expected a done deploy with its commit recorded, got %+v", entry)
+	}
+	if n := countPushedCommits(t, repo, dopts.ID); n != 1 {
+		t.Fatalf("Expected 1 pushed commit, found %d", n)
+	}
+
+	// Authored by the requester, committed by mgo
+	identities, err := exec.Command("git", "-C", repo, "log", "origin/master", "-1", "--format=%an|%cn").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Ronaldo|" + git.GIT_NAME; strings.TrimSpace(string(identities)) != expected {
+		t.Fatalf("Expected %s, got %s", expected, identities)
+	}
+}
+
+// One deploy was only queued, the other one committed and pushed, when the
+// server stopped. After a restart both end up synced, each with a single commit
+func TestReplayPendingDeploys(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+	stateDir := t.TempDir()
+
+	before, err := journal.NewJournal(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := newTestDeployOptions("1.1.0")
+	before.Queue(queued)
+
+	pushed := newTestDeployOptions("1.2.0")
+	before.Queue(pushed)
+
+	gitService, _ := git.NewGit(git.BACKEND_EXTERNAL, repo, nil)
+	dpl := deploy.NewDeploy(gitService, &deploy.MyUpdater{}, pushed)
+	if err := dpl.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitService.Push(); err != nil {
+		t.Fatal(err)
+	}
+	before.SetStage(pushed.ID, journal.STAGE_COMMITTED, nil)
+	before.Close()
+
+	r := newTestReleaseManager(t, repo, stateDir)
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFinished(t, r.journal, queued.ID, pushed.ID)
+
+	for _, id := range []string{queued.ID, pushed.ID} {
+		if n := countPushedCommits(t, repo, id); n != 1 {
+			t.Fatalf("Expected 1 pushed commit for deploy %s, found %d", id, n)
+		}
+	}
+}
+
+func TestRequestReleaseRollback(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	r := newTestReleaseManager(t, repo, "")
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{"1.1.0", "1.2.0"} {
+		if err := r.RequestRelease(newTestDeployOptions(tag)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rollback := &deploy.DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Author:      "Ronaldo",
+		Cluster:     testCluster,
+		Rollback:    true,
+	}
+	if err := r.RequestRelease(rollback); err != nil {
+		t.Fatal(err)
+	}
+	if rollback.Image.Tag != "1.1.0" {
+		t.Fatalf("Expected a rollback to 1.1.0, got %+v", rollback.Image)
+	}
+
+	entry, _ := r.journal.Get(rollback.ID)
+	if entry.Deploy.Image.Tag != "1.1.0" || entry.Deploy.RollbackTo == "" {
+		t.Fatalf("Expected the resolved rollback in the journal, got %+v", entry.Deploy)
+	}
+
+	subject, err := exec.Command("git", "-C", repo, "log", "origin/master", "-1", "--format=%s").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(subject), "Rollback: a/repo1:1.1.0 to "+testCluster+" by Ronaldo") {
+		t.Fatalf("Unexpected rollback commit '%s'", string(subject))
+	}
+
+	rollback = &deploy.DeployOptions{
+		TriggerRepo: "github.com/a/repo1",
+		Author:      "Ronaldo",
+		Cluster:     testCluster,
+		Rollback:    true,
+		RollbackTo:  "0.0.1",
+	}
+	if err := r.RequestRelease(rollback); err == nil {
+		t.Fatal("Expected an error rolling back to a tag never deployed")
+	}
+}
+
+func TestRequestReleasePromote(t *testing.T) {
+	const staging = "mystagingcluster"
+
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+	err := util.RunCommands([]string{"GIT_DIR=" + filepath.Join(repo, ".git"), "GIT_WORK_TREE=" + repo},
+		exec.Command("cp", "-r", filepath.Join(repo, "installations", testCluster), filepath.Join(repo, "installations", staging)),
+		exec.Command("git", "add", "-A"),
+		exec.Command("git", "commit", "-m", "Add staging"),
+		exec.Command("git", "push", "origin", "master"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Global.Promotions = []deploy.Promotion{{From: staging, To: testCluster}}
+	defer func() { config.Global.Promotions = nil }()
+
+	r := newTestReleaseManager(t, repo, "")
+	addTestCluster(r, repo, staging)
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	promotion := func(from, to string) *deploy.DeployOptions {
+		return &deploy.DeployOptions{
+			TriggerRepo: "github.com/a/repo1",
+			Author:      "Ronaldo",
+			Cluster:     to,
+			PromoteFrom: from,
+		}
+	}
+	expectRefused := func(dopts *deploy.DeployOptions, reason string) {
+		err := r.RequestRelease(dopts)
+		if _, ok := err.(*deploy.PromotionError); !ok {
+			t.Fatalf("Expected a promotion refused as %s, got %v", reason, err)
+		}
+	}
+
+	expectRefused(promotion(staging, testCluster), "never synced")
+	expectRefused(promotion(testCluster, staging), "not configured")
+
+	toStaging := newTestDeployOptions("1.1.0")
+	toStaging.Cluster = staging
+	if err := r.RequestRelease(toStaging); err != nil {
+		t.Fatal(err)
+	}
+
+	promoted := promotion(staging, testCluster)
+	if err := r.RequestRelease(promoted); err != nil {
+		t.Fatal(err)
+	}
+	if promoted.Image != toStaging.Image {
+		t.Fatalf("Expected %s promoted, got %s", toStaging.Image, promoted.Image)
+	}
+
+	subject, err := exec.Command("git", "-C", repo, "log", "origin/master", "-1", "--format=%s").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(subject), "Promote: a/repo1:1.1.0 to "+testCluster+" by Ronaldo") {
+		t.Fatalf("Unexpected promotion commit '%s'", string(subject))
+	}
+
+	// Pushed to staging, never synced there
+	unsynced := newTestDeployOptions("1.2.0")
+	unsynced.Cluster = staging
+	if err := deploy.NewDeploy(r.gitService, &deploy.MyUpdater{}, unsynced).Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.gitService.Push(); err != nil {
+		t.Fatal(err)
+	}
+	expectRefused(promotion(staging, testCluster), "not synced")
+}
+
+func waitStage(t *testing.T, j *journal.Journal, id, stage string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entry, _ := j.Get(id)
+		if entry.Stage == stage {
+			return
+		}
+		if entry.Finished() || time.Now().After(deadline) {
+			t.Fatalf("Expected deploy %s in stage %s, got %s (%s)", id, stage, entry.Stage, entry.Error)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRequestReleasePullRequest(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+	origin, err := exec.Command("git", "-C", repo, "remote", "get-url", "origin").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forgeServer := testutils.NewTestForge(t)
+	config.Global.PullRequests = forge.Config{
+		Clusters:   []string{testCluster},
+		Forge:      forge.FORGE_GITHUB,
+		URL:        forgeServer.URL,
+		Repository: forgeServer.Repository,
+		Token:      forgeServer.Token,
+	}
+	defer func() { config.Global.PullRequests = forge.Config{} }()
+
+	r := newTestReleaseManager(t, repo, "")
+	r.forge, _ = forge.NewForge(&config.Global.PullRequests)
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := newTestDeployOptions("1.1.0")
+	result, err := r.QueueRelease(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStage(t, r.journal, merged.ID, journal.STAGE_REVIEW)
+
+	pr := forgeServer.PullRequests()[0]
+	if pr.Branch != DEPLOY_BRANCH_PREFIX+merged.ID || pr.Base != "master" || pr.Title != "Deploy: a/repo1:1.1.0 to "+testCluster+" by Ronaldo" {
+		t.Fatalf("Unexpected pull request %+v", pr)
+	}
+	if n := countPushedCommits(t, repo, merged.ID); n != 0 {
+		t.Fatalf("Expected nothing pushed to master before the merge, found %d commits", n)
+	}
+
+	r.checkPullRequests()
+	if entry, _ := r.journal.Get(merged.ID); entry.Stage != journal.STAGE_REVIEW || entry.PullRequest.Number != 1 {
+		t.Fatalf("Expected the deploy in review, got %+v", entry)
+	}
+
+	err = exec.Command("git", "-C", strings.TrimSpace(string(origin)), "update-ref", "refs/heads/master", "refs/heads/"+pr.Branch).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgeServer.Merge(1)
+	r.checkPullRequests()
+
+	select {
+	case <-result.Done:
+	case err := <-result.Err:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deploy not synced after the merge")
+	}
+
+	closed := newTestDeployOptions("1.2.0")
+	result, err = r.QueueRelease(closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStage(t, r.journal, closed.ID, journal.STAGE_REVIEW)
+
+	forgeServer.Close(2)
+	r.checkPullRequests()
+
+	select {
+	case <-result.Err:
+	case <-result.Done:
+		t.Fatal("Expected the deploy to fail with its pull request closed")
+	}
+}
+
+func TestResolveDigest(t *testing.T) {
+	reg := testutils.NewTestRegistry(t)
+	digest := reg.Push("foo/bar", "1.0.0", time.Now())
+	client := registry.NewClient([]registry.RegistryConfig{{Host: reg.Host, Insecure: true}})
+
+	tests := []struct {
+		Deploy   deploy.DeployOptions
+		Expected string
+	}{
+		{deploy.DeployOptions{ResolveDigest: true, Image: deploy.DeployOptionsImage{Repository: reg.Host + "/foo/bar", Tag: "1.0.0"}}, digest},
+		// Not asked for
+		{deploy.DeployOptions{Image: deploy.DeployOptionsImage{Repository: reg.Host + "/foo/bar", Tag: "1.0.0"}}, ""},
+		// Already pinned
+		{deploy.DeployOptions{ResolveDigest: true, Image: deploy.DeployOptionsImage{Repository: reg.Host + "/foo/bar", Tag: "1.0.0", Digest: "sha256:1234"}}, "sha256:1234"},
+	}
+
+	for testIdx, test := range tests {
+		if err := resolveDigest(client, &test.Deploy); err != nil {
+			t.Fatalf("[test %d] %v", testIdx, err)
+		}
+		if test.Deploy.Image.Digest != test.Expected {
+			t.Fatalf("[test %d] Expected digest `%s`, got `%s`", testIdx, test.Expected, test.Deploy.Image.Digest)
+		}
+	}
+
+	err := resolveDigest(client, &deploy.DeployOptions{ResolveDigest: true, Image: deploy.DeployOptionsImage{Repository: reg.Host + "/foo/bar", Tag: "nope"}})
+	if err == nil {
+		t.Fatal("Expected an error for a missing tag")
+	}
+}
+
+// The deploy only succeeds once the release rolled out
+func TestRequestReleaseNotReady(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+	err := util.RunCommands([]string{"GIT_DIR=" + filepath.Join(repo, ".git"), "GIT_WORK_TREE=" + repo},
+		exec.Command("sed", "-i", "s/^  namespace: app$/  namespace: app\\n  readyTimeout: 1s/", filepath.Join(repo, "installations", testCluster, "some-values.yaml")),
+		exec.Command("git", "commit", "-am", "Wait for foobar"),
+		exec.Command("git", "push", "origin", "master"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReleaseManager(t, repo, t.TempDir())
+	r.syncServices[testCluster] = clusterSync.NewSync(repo, testCluster, r.layout, r.helmServices[testCluster], &kubectl.KubectlFake{
+		Objects: map[string]string{
+			"Job/app/migrate": `
+kind: Job
+metadata: {name: migrate, namespace: app, labels: {release: foobar}}
+status:
+  conditions:
+  - {type: Failed, status: "True", message: BackoffLimitExceeded}
+`,
+		},
+	}, &secrets.DecrypterFake{})
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	dopts := newTestDeployOptions("1.1.0")
+	err = r.RequestRelease(dopts)
+	if err == nil || !strings.Contains(err.Error(), "Release app/foobar not ready: Job app/migrate: failed: BackoffLimitExceeded") {
+		t.Fatalf("Expected the release not to be ready, got %v", err)
+	}
+
+	entry, _ := r.journal.Get(dopts.ID)
+	if entry.Stage != journal.STAGE_FAILED || !strings.Contains(entry.Error, "not ready") {
+		t.Fatalf("Expected a failed deploy, got %+v", entry)
+	}
+}
+
+// Fails syncing releases whose values hold badImage
+type brokenImageHelm struct {
+	helm.HelmFake
+	badImage string
+}
+
+func (h *brokenImageHelm) SyncRelease(release *helm.HelmRelease, valueFiles []string) error {
+	for _, file := range valueFiles {
+		if content, _ := ioutil.ReadFile(file); strings.Contains(string(content), h.badImage) {
+			return errors.New("crash looping")
+		}
+	}
+	return nil
+}
+
+func TestRequestReleaseAutoRollback(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	r := newTestReleaseManager(t, repo, "")
+	helmService := &brokenImageHelm{badImage: "a/repo1:1.1.0"}
+	r.helmServices[testCluster] = helmService
+	r.syncServices[testCluster] = clusterSync.NewSync(repo, testCluster, r.layout, helmService, &kubectl.KubectlFake{}, &secrets.DecrypterFake{})
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	lastSubject := func() string {
+		subject, err := exec.Command("git", "-C", repo, "log", "origin/master", "-1", "--format=%s").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(subject))
+	}
+
+	// Not opted in, the broken deploy stays
+	if err := r.RequestRelease(newTestDeployOptions("1.1.0")); err == nil || strings.Contains(err.Error(), "Rolled back") {
+		t.Fatalf("Expected the sync to fail without a rollback, got %v", err)
+	}
+	if !strings.HasPrefix(lastSubject(), "Deploy: a/repo1:1.1.0") {
+		t.Fatalf("Expected the broken deploy to stay, got %s", lastSubject())
+	}
+	if err := r.RequestRelease(newTestDeployOptions("1.2.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Global.AutoRollback = []string{testCluster}
+	defer func() { config.Global.AutoRollback = nil }()
+
+	dopts := newTestDeployOptions("1.1.0")
+	err := r.RequestRelease(dopts)
+	rollback, ok := err.(*RollbackError)
+	if !ok || rollback.RollbackErr != nil || len(rollback.Reverted) != 1 || rollback.Reverted[0] != dopts.ID || !strings.Contains(rollback.Error(), "crash looping") {
+		t.Fatalf("Expected the deploy to be rolled back, got %v", err)
+	}
+	if lastSubject() != "Revert: 1 deploy to "+testCluster {
+		t.Fatalf("Expected a revert commit, got %s", lastSubject())
+	}
+
+	records, err := r.History(&history.Filter{Cluster: testCluster, TriggerRepo: "github.com/a/repo1", Limit: 1})
+	if err != nil || len(records) != 1 || records[0].Deploy.Image.Tag != "1.2.0" {
+		t.Fatalf("Expected 1.2.0 to be the current deploy, got %v (%v)", records, err)
+	}
+
+	// Releases opt in on their own
+	config.Global.AutoRollback = nil
+	err = util.RunCommands([]string{"GIT_DIR=" + filepath.Join(repo, ".git"), "GIT_WORK_TREE=" + repo},
+		exec.Command("sed", "-i", "s/^  namespace: app$/  namespace: app\\n  autoRollback: true/", filepath.Join(repo, "installations", testCluster, "some-values.yaml")),
+		exec.Command("git", "commit", "-am", "Roll foobar back"),
+		exec.Command("git", "push", "origin", "master"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.RequestRelease(newTestDeployOptions("1.1.0")).(*RollbackError); !ok {
+		t.Fatal("Expected the deploy to be rolled back")
+	}
+}
+
+// Only one of the releases deployed in the batch opted in for rollbacks
+func TestRequestReleaseAutoRollbackMixedBatch(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	err := ioutil.WriteFile(filepath.Join(repo, "installations", testCluster, "web-values.yaml"), []byte(`__mygitops:
+  chart: foo/web
+  version: 0.1.0
+  name: web
+  namespace: app
+  autoRollback: true
+  images:
+    github.com/a/repo1:
+      image: "a/repo1:1.0.0"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = util.RunCommands([]string{"GIT_DIR=" + filepath.Join(repo, ".git"), "GIT_WORK_TREE=" + repo},
+		exec.Command("git", "add", "-A"),
+		exec.Command("git", "commit", "-m", "Add web"),
+		exec.Command("git", "push", "origin", "master"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReleaseManager(t, repo, "")
+	helmService := &brokenImageHelm{badImage: "a/repo1:1.1.0"}
+	r.helmServices[testCluster] = helmService
+	r.syncServices[testCluster] = clusterSync.NewSync(repo, testCluster, r.layout, helmService, &kubectl.KubectlFake{}, &secrets.DecrypterFake{})
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both queued in the same batch
+	requestBoth := func(tag string) (*deploy.DeployOptions, error, error) {
+		web := newTestDeployOptions(tag)
+		other := &deploy.DeployOptions{
+			TriggerRepo: "github.com/foo/bar",
+			Author:      "Ronaldo",
+			Cluster:     testCluster,
+			Image:       deploy.DeployOptionsImage{Repository: "foo/bar", Tag: tag},
+		}
+
+		errs := []error{}
+		results := []*async.Result{}
+		for _, dopts := range []*deploy.DeployOptions{web, other} {
+			result, err := r.QueueRelease(dopts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, result)
+		}
+		for _, result := range results {
+			select {
+			case <-result.Done:
+				errs = append(errs, nil)
+			case err := <-result.Err:
+				errs = append(errs, err)
+			}
+		}
+
+		for idx, dopts := range []*deploy.DeployOptions{web, other} {
+			entry, _ := r.journal.Get(dopts.ID)
+			if (errs[idx] == nil) != (entry.Stage == journal.STAGE_DONE) {
+				t.Fatalf("Deploy %s journaled as %s, got %v", dopts.ID, entry.Stage, errs[idx])
+			}
+		}
+		return web, errs[0], errs[1]
+	}
+
+	// The broken web deploy is reverted, the other one is live
+	web, webErr, otherErr := requestBoth("1.1.0")
+	rollback, ok := webErr.(*RollbackError)
+	if !ok || rollback.RollbackErr != nil || len(rollback.Reverted) != 1 || rollback.Reverted[0] != web.ID {
+		t.Fatalf("Expected the web deploy to be rolled back, got %v", webErr)
+	}
+	if otherErr != nil {
+		t.Fatalf("Expected the other deploy to be done, got %v", otherErr)
+	}
+
+	records, err := r.History(&history.Filter{Cluster: testCluster, Limit: 1})
+	if err != nil || len(records) != 1 || records[0].Deploy.Image.Repository != "foo/bar" {
+		t.Fatalf("Expected foo/bar to be the latest deploy, got %v (%v)", records, err)
+	}
+
+	// Still broken once rolled back: the other deploy fails with the sync
+	// error alone
+	helmService.badImage = "1.3.0"
+	_, webErr, otherErr = requestBoth("1.3.0")
+	if rollback, ok := webErr.(*RollbackError); !ok || rollback.RollbackErr == nil {
+		t.Fatalf("Expected the web deploy to be rolled back and the sync to fail, got %v", webErr)
+	}
+	if _, ok := otherErr.(*RollbackError); ok || otherErr == nil || !strings.Contains(otherErr.Error(), "crash looping") {
+		t.Fatalf("Expected the sync error, got %v", otherErr)
+	}
+}
@@ -1,13 +1,25 @@
 package services
 
 import (
-	"github.com/valer-cara/mgo/pkg/deploy"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/valer-cara/mgo/pkg/async"
+	"github.com/valer-cara/mgo/pkg/deploy"
+	"github.com/valer-cara/mgo/pkg/history"
+	"github.com/valer-cara/mgo/pkg/journal"
 )
 
 type ReleaseManagerMock struct {
 	InitError           error
 	RequestReleaseError error
+	QueueReleaseError   error
+
+	// Returned by Deployment(), by ID
+	Deployments map[string]*journal.Entry
+
+	// Returned by History(), filtered
+	HistoryRecords []*history.Record
+	HistoryError   error
 }
 
 func (r *ReleaseManagerMock) Init() error {
@@ -25,3 +37,44 @@ func (r *ReleaseManagerMock) RequestRelease(dopts *deploy.DeployOptions) error {
 	}
 	return nil
 }
+
+// The result is signaled right away, with RequestReleaseError if set
+func (r *ReleaseManagerMock) QueueRelease(dopts *deploy.DeployOptions) (*async.Result, error) {
+	log.Println("ReleaseManagerMock: QueueRelease()")
+	if r.QueueReleaseError != nil {
+		return nil, r.QueueReleaseError
+	}
+
+	if dopts.ID == "" {
+		dopts.ID = journal.NewID()
+	}
+
+	result := async.NewResult(dopts.ID)
+	if r.RequestReleaseError != nil {
+		result.Err <- r.RequestReleaseError
+	} else {
+		result.Done <- true
+	}
+	return result, nil
+}
+
+func (r *ReleaseManagerMock) Deployment(id string) (*journal.Entry, bool) {
+	log.Println("ReleaseManagerMock: Deployment()")
+	entry, ok := r.Deployments[id]
+	return entry, ok
+}
+
+func (r *ReleaseManagerMock) History(filter *history.Filter) ([]*history.Record, error) {
+	log.Println("ReleaseManagerMock: History()")
+	if r.HistoryError != nil {
+		return nil, r.HistoryError
+	}
+
+	records := []*history.Record{}
+	for _, record := range r.HistoryRecords {
+		if filter.Matches(record.Deploy) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/valer-cara/mgo/pkg/config"
 	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/secrets"
 	"github.com/valer-cara/mgo/pkg/sync"
 )
 
@@ -17,7 +19,8 @@ type SyncService struct {
 	kubecontext string
 	dryRun      bool
 
-	helmService helm.HelmService
+	helmService    helm.HelmService
+	kubectlService kubectl.KubectlService
 }
 
 func NewSyncService(gitopsRepo, helmHome, kubeconfig, kubecontext string, dryRun bool) *SyncService {
@@ -31,13 +34,18 @@ func NewSyncService(gitopsRepo, helmHome, kubeconfig, kubecontext string, dryRun
 }
 
 func (ss *SyncService) Init() error {
-	ss.helmService = helm.NewHelmCmd(&helm.HelmCmdOptions{
+	helmService, err := helm.NewHelmService(&helm.HelmServiceOptions{
+		Backend:      config.Global.Helm.Backend,
 		DryRun:       ss.dryRun,
 		HelmHome:     ss.helmHome,
 		Kubeconfig:   ss.kubeconfig,
 		Kubecontext:  ss.kubecontext,
 		Repositories: config.Global.Helm.Repositories,
 	})
+	if err != nil {
+		return err
+	}
+	ss.helmService = helmService
 
 	if err := ss.helmService.Init(); err != nil {
 		return errors.New(fmt.Sprintf(
@@ -46,13 +54,31 @@ func (ss *SyncService) Init() error {
 		))
 	}
 
+	ss.kubectlService = kubectl.NewKubectlCmd(&kubectl.KubectlCmdOptions{
+		DryRun:      ss.dryRun,
+		Kubeconfig:  ss.kubeconfig,
+		Kubecontext: ss.kubecontext,
+	})
+
+	if err := ss.kubectlService.Init(); err != nil {
+		return errors.New(fmt.Sprintf(
+			"Cannot sync cluster: Cannot initialize kubectl service: %v",
+			err,
+		))
+	}
+
 	return nil
 }
 
 func (ss *SyncService) Execute() error {
-	syncService := sync.NewSync(ss.gitopsRepo, ss.kubecontext, ss.helmService)
+	layout, err := config.Global.RepoLayout()
+	if err != nil {
+		return err
+	}
+
+	syncService := sync.NewSync(ss.gitopsRepo, ss.kubecontext, layout, ss.helmService, ss.kubectlService, secrets.NewSopsCmd(&config.Global.Secrets))
 
-	err := syncService.Sync()
+	err = syncService.Sync()
 	if err != nil {
 		return errors.New(fmt.Sprintf(
 			"Cannot sync cluster: %v",
@@ -2,43 +2,54 @@ package sync
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
-	"os"
-	"strings"
+	"time"
 
+	"github.com/valer-cara/mgo/pkg/health"
 	"github.com/valer-cara/mgo/pkg/helm"
 	"github.com/valer-cara/mgo/pkg/jobs"
+	"github.com/valer-cara/mgo/pkg/kubectl"
 	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
 	"github.com/valer-cara/mgo/pkg/util"
 )
 
 type Sync struct {
 	gitopsRepoRoot string
 	cluster        string
+	layout         manifest.Layout
 	files          struct {
-		raw    []string
-		values []string
+		raw       []string
+		values    []string
+		kustomize []string
 	}
 
-	helmService helm.HelmService
+	helmService    helm.HelmService
+	kubectlService kubectl.KubectlService
+	decrypter      secrets.Decrypter
 }
 
-func NewSync(gitopsRepoRoot string, cluster string, helmService helm.HelmService) *Sync {
+func NewSync(gitopsRepoRoot string, cluster string, layout manifest.Layout, helmService helm.HelmService, kubectlService kubectl.KubectlService, decrypter secrets.Decrypter) *Sync {
 	return &Sync{
 		gitopsRepoRoot: gitopsRepoRoot,
 		cluster:        cluster,
+		layout:         layout,
 		helmService:    helmService,
+		kubectlService: kubectlService,
+		decrypter:      decrypter,
 	}
 }
 
 func (s *Sync) Sync() error {
-	manifests, err := manifest.FindManifests(s.gitopsRepoRoot, s.cluster)
+	manifests, err := manifest.FindManifests(s.layout, s.gitopsRepoRoot, s.cluster)
 	if err != nil {
 		return err
 	}
 
 	s.files.raw = manifests.Raw
 	s.files.values = manifests.Helm
+	s.files.kustomize = manifests.Kustomize
 
 	if err := s.syncHelmManifsets(); err != nil {
 		return err
@@ -48,18 +59,40 @@ func (s *Sync) Sync() error {
 		return err
 	}
 
+	if err := s.syncKustomizations(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 type syncJob struct {
 	Release    *helm.HelmRelease
 	ValueFiles []string
+	Wave       int
+	DependsOn  []string
+	// Wait for the release to roll out, when set
+	ReadyTimeout time.Duration
+
+	// Resolved DependsOn
+	deps []*syncJob
+	// Outcome, set once the release's wave ran
+	err     error
+	blocked *BlockedError
+}
+
+func (j *syncJob) id() string {
+	return j.Release.Namespace + "/" + j.Release.Name
+}
+
+func (j *syncJob) synced() bool {
+	return j.err == nil && j.blocked == nil
 }
 
 func (s *Sync) syncHelmManifsets() error {
 	var (
 		errs     []error
-		syncJobs []interface{}
+		syncJobs []*syncJob
 	)
 
 	for _, path := range s.files.values {
@@ -76,36 +109,78 @@ func (s *Sync) syncHelmManifsets() error {
 			continue
 		}
 
-		// XXX: quick hack to include plaintext secrets here.... need to use sops/helm-secrets instead...
-		files := []string{path}
-
-		secretsFile := strings.Replace(path, "-values.yaml", "-secrets.yaml", -1)
-		if _, err := os.Stat(secretsFile); err == nil {
-			files = append(files, secretsFile)
+		files, err := header.ResolveValueFiles(s.gitopsRepoRoot, path)
+		if err != nil {
+			errs = append(errs, errors.New("Manifest "+path+": "+err.Error()))
+			continue
 		}
 
-		var job interface{} = syncJob{
-			Release:    &header.HelmRelease,
-			ValueFiles: files,
-		}
+		// Validated above
+		readyTimeout, _ := header.ReadyWait()
 
-		syncJobs = append(syncJobs, job)
+		syncJobs = append(syncJobs, &syncJob{
+			Release:      &header.HelmRelease,
+			ValueFiles:   files,
+			Wave:         header.Wave,
+			DependsOn:    header.DependsOn,
+			ReadyTimeout: readyTimeout,
+		})
 	}
 
 	if len(errs) > 0 {
 		return util.AggregateErrors(errs)
 	}
 
-	errs = jobs.Parallel(func(job interface{}) error {
-		j := job.(syncJob)
+	batches, err := planWaves(syncJobs)
+	if err != nil {
+		return err
+	}
+
+	// Releases of the previous waves that didn't sync
+	var notSynced []string
 
-		err := s.helmService.SyncRelease(j.Release, j.ValueFiles)
-		if err != nil {
-			return err
+	for idx, batch := range batches {
+		var ready []interface{}
+
+		for _, j := range batch {
+			if blockers := j.blockers(notSynced); len(blockers) > 0 {
+				j.blocked = &BlockedError{Release: j.id(), On: blockers}
+				log.Warnln(j.blocked)
+				continue
+			}
+			ready = append(ready, j)
+		}
+
+		// Each job only sets its own outcome
+		jobs.Parallel(func(job interface{}) error {
+			j := job.(*syncJob)
+
+			// Encrypted secrets only live on disk while the release is synced
+			j.err = secrets.WithDecrypted(s.decrypter, j.ValueFiles, func(files []string) error {
+				return s.helmService.SyncRelease(j.Release, files)
+			})
+			if j.err == nil && j.ReadyTimeout > 0 {
+				j.err = health.WaitReady(s.kubectlService, j.Release, j.ReadyTimeout)
+			}
+			return nil
+		}, ready, &jobs.ParallelOpts{MaxParallel: 15})
+
+		if idx == len(batches)-1 || batches[idx+1][0].Wave != batch[0].Wave {
+			for _, j := range syncJobs {
+				if j.Wave == batch[0].Wave && !j.synced() {
+					notSynced = append(notSynced, j.id())
+				}
+			}
 		}
+	}
 
-		return nil
-	}, syncJobs, &jobs.ParallelOpts{MaxParallel: 15})
+	for _, j := range syncJobs {
+		if j.err != nil {
+			errs = append(errs, j.err)
+		} else if j.blocked != nil {
+			errs = append(errs, j.blocked)
+		}
+	}
 
 	if len(errs) > 0 {
 		return util.AggregateErrors(errs)
@@ -114,9 +189,58 @@ func (s *Sync) syncHelmManifsets() error {
 	return nil
 }
 
+// The releases j waits for that didn't sync: its failed or blocked
+// dependencies, or else those of the previous waves
+func (j *syncJob) blockers(previousWaves []string) []string {
+	var blockers []string
+	for _, dep := range j.deps {
+		if !dep.synced() {
+			blockers = append(blockers, dep.id())
+		}
+	}
+
+	if len(blockers) == 0 {
+		return previousWaves
+	}
+	return blockers
+}
+
+// Raw manifests are applied one by one, in the order they were found, so that
+// things like namespaces can be created before the resources living in them.
+// A failing file doesn't stop the others from being applied.
 func (s *Sync) syncRawManifsets() error {
+	var errs []error
+
 	for _, path := range s.files.raw {
-		log.Printf("TODO: Not yet syncing %s...", path)
+		log.Debugf("Applying raw manifest %s", path)
+
+		if err := s.kubectlService.Apply(path); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Manifest %s: %v", path, err)))
+		}
+	}
+
+	if len(errs) > 0 {
+		return util.AggregateErrors(errs)
+	}
+
+	return nil
+}
+
+// Kustomizations are built and the result applied, same as raw manifests
+func (s *Sync) syncKustomizations() error {
+	var errs []error
+
+	for _, dir := range s.files.kustomize {
+		log.Debugf("Applying kustomization %s", dir)
+
+		if err := kubectl.WithKustomization(s.kubectlService, dir, s.kubectlService.Apply); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Kustomization %s: %v", dir, err)))
+		}
+	}
+
+	if len(errs) > 0 {
+		return util.AggregateErrors(errs)
 	}
+
 	return nil
 }
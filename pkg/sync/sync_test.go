@@ -2,7 +2,13 @@ package sync
 
 import (
 	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
 	"github.com/valer-cara/mgo/pkg/testutils"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -10,11 +16,76 @@ func TestSync(t *testing.T) {
 	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
 
 	helmService := helm.HelmFake{}
+	kubectlService := kubectl.KubectlFake{}
 
-	x := NewSync(repo, "myprodcluster", &helmService)
+	x := NewSync(repo, "myprodcluster", &manifest.DefaultLayout{}, &helmService, &kubectlService, &secrets.DecrypterFake{})
 	err := x.Sync()
 
 	if err != nil {
 		t.Fatalf("Cannot sync repo %s: %v", repo, err)
 	}
+
+	if len(kubectlService.Applied) != 2 ||
+		!strings.HasSuffix(kubectlService.Applied[0], "/one-configmap-raw.yaml") ||
+		!strings.HasSuffix(kubectlService.Applied[1], "/web.yaml") {
+		t.Fatalf("Expected the raw manifest and the built kustomization to be applied, got %v", kubectlService.Applied)
+	}
+}
+
+func TestSyncRawManifestErrors(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	helmService := helm.HelmFake{}
+	kubectlService := kubectl.KubectlFake{FailOnApply: "apply failed"}
+
+	x := NewSync(repo, "myprodcluster", &manifest.DefaultLayout{}, &helmService, &kubectlService, &secrets.DecrypterFake{})
+	err := x.Sync()
+
+	if err == nil {
+		t.Fatal("Expected sync to fail when applying raw manifests fails")
+	}
+	if !strings.Contains(err.Error(), "one-configmap-raw.yaml: apply failed") {
+		t.Fatalf("Expected error to mention the failing file, got: %v", err)
+	}
+}
+
+func TestSyncKustomizationErrors(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	helmService := helm.HelmFake{}
+	kubectlService := kubectl.KubectlFake{FailOnKustomize: "bad kustomization"}
+
+	x := NewSync(repo, "myprodcluster", &manifest.DefaultLayout{}, &helmService, &kubectlService, &secrets.DecrypterFake{})
+	err := x.Sync()
+
+	if err == nil || !strings.Contains(err.Error(), "web: bad kustomization") {
+		t.Fatalf("Expected the kustomization build error, got: %v", err)
+	}
+}
+
+func TestSyncDecryptsSecrets(t *testing.T) {
+	repo := testutils.CreateTestRepoFromSample(t, "../../tests/minimal-gitops-repo")
+
+	secretsFile := filepath.Join(repo, "installations/myprodcluster/some-secrets.yaml")
+	err := ioutil.WriteFile(secretsFile, []byte("password: ENC[AES256_GCM,data:Zm9v,type:str]\nsops:\n  mac: ENC[AES256_GCM,data:bWFj,type:str]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helmService := helm.HelmFake{}
+	kubectlService := kubectl.KubectlFake{}
+	decrypter := secrets.DecrypterFake{}
+
+	if err := NewSync(repo, "myprodcluster", &manifest.DefaultLayout{}, &helmService, &kubectlService, &decrypter).Sync(); err != nil {
+		t.Fatalf("Cannot sync repo %s: %v", repo, err)
+	}
+	if len(decrypter.Decrypted) != 1 || decrypter.Decrypted[0] != secretsFile {
+		t.Fatalf("Expected %s to be decrypted, got %v", secretsFile, decrypter.Decrypted)
+	}
+
+	decrypter = secrets.DecrypterFake{FailOnDecrypt: "no key"}
+	err = NewSync(repo, "myprodcluster", &manifest.DefaultLayout{}, &helmService, &kubectlService, &decrypter).Sync()
+	if err == nil || !strings.Contains(err.Error(), "some-secrets.yaml: no key") {
+		t.Fatalf("Expected the decryption error, got: %v", err)
+	}
 }
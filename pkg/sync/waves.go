@@ -0,0 +1,153 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A release that wasn't synced because releases it waits for failed or were
+// blocked themselves
+type BlockedError struct {
+	Release string
+	On      []string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("Release %s blocked: %s not synced", e.Release, strings.Join(e.On, ", "))
+}
+
+// Releases are synced in waves, ascending. Within a wave, a release is only
+// synced once those in its `dependsOn` are. A release also waits for all
+// releases of the previous waves, so it's blocked when any of them failed
+//
+// Returns the releases grouped in batches that can each be synced in
+// parallel, in order. Unknown or ambiguous dependencies, dependencies on
+// later waves and cycles are errors
+func planWaves(syncJobs []*syncJob) ([][]*syncJob, error) {
+	byName := map[string][]*syncJob{}
+	for _, j := range syncJobs {
+		byName[j.Release.Name] = append(byName[j.Release.Name], j)
+		byName[j.id()] = append(byName[j.id()], j)
+	}
+
+	for _, j := range syncJobs {
+		j.deps = nil
+		for _, name := range j.DependsOn {
+			found := byName[name]
+			switch {
+			case len(found) == 0:
+				return nil, errors.New(fmt.Sprintf("Release %s: unknown dependency `%s`", j.id(), name))
+			case len(found) > 1:
+				return nil, errors.New(fmt.Sprintf("Release %s: ambiguous dependency `%s`, use `namespace/name`", j.id(), name))
+			case found[0] == j:
+				return nil, errors.New(fmt.Sprintf("Release %s depends on itself", j.id()))
+			case found[0].Wave > j.Wave:
+				return nil, errors.New(fmt.Sprintf("Release %s (wave %d) depends on %s from a later wave (%d)", j.id(), j.Wave, found[0].id(), found[0].Wave))
+			}
+			j.deps = append(j.deps, found[0])
+		}
+	}
+
+	if cycle := findCycle(syncJobs); cycle != nil {
+		return nil, errors.New("Dependency cycle between releases: " + strings.Join(cycle, " -> "))
+	}
+
+	// Level of each release within its wave: how many releases of the same
+	// wave it waits for, transitively
+	levels := map[*syncJob]int{}
+	var level func(j *syncJob) int
+	level = func(j *syncJob) int {
+		if l, ok := levels[j]; ok {
+			return l
+		}
+		l := 0
+		for _, dep := range j.deps {
+			if dep.Wave == j.Wave {
+				if depLevel := level(dep) + 1; depLevel > l {
+					l = depLevel
+				}
+			}
+		}
+		levels[j] = l
+		return l
+	}
+
+	type batchKey struct{ wave, level int }
+	batches := map[batchKey][]*syncJob{}
+	var keys []batchKey
+
+	for _, j := range syncJobs {
+		key := batchKey{j.Wave, level(j)}
+		if _, ok := batches[key]; !ok {
+			keys = append(keys, key)
+		}
+		batches[key] = append(batches[key], j)
+	}
+
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].wave != keys[b].wave {
+			return keys[a].wave < keys[b].wave
+		}
+		return keys[a].level < keys[b].level
+	})
+
+	planned := make([][]*syncJob, len(keys))
+	for idx, key := range keys {
+		planned[idx] = batches[key]
+	}
+	return planned, nil
+}
+
+// The first cycle found, as the ids of the releases in it, the first one
+// repeated at the end. Nil if there's none
+func findCycle(syncJobs []*syncJob) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var (
+		state = map[*syncJob]int{}
+		stack []*syncJob
+		visit func(j *syncJob) []string
+	)
+
+	visit = func(j *syncJob) []string {
+		state[j] = visiting
+		stack = append(stack, j)
+
+		for _, dep := range j.deps {
+			switch state[dep] {
+			case visiting:
+				var cycle []string
+				for idx := len(stack) - 1; idx >= 0; idx-- {
+					cycle = append([]string{stack[idx].id()}, cycle...)
+					if stack[idx] == dep {
+						break
+					}
+				}
+				return append(cycle, dep.id())
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[j] = visited
+		return nil
+	}
+
+	for _, j := range syncJobs {
+		if state[j] == unvisited {
+			if cycle := visit(j); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
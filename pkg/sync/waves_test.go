@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/helm"
+	"github.com/valer-cara/mgo/pkg/kubectl"
+	"github.com/valer-cara/mgo/pkg/manifest"
+	"github.com/valer-cara/mgo/pkg/secrets"
+)
+
+// Writes a values file per release, `name: header extra`
+func createWavesRepo(t *testing.T, releases map[string]string) string {
+	repo := t.TempDir()
+	dir := filepath.Join(repo, "installations", "prod")
+	os.MkdirAll(dir, 0755)
+
+	for name, extra := range releases {
+		content := fmt.Sprintf("__mygitops:\n  chart: stable/%s\n  version: 1.0.0\n  name: %s\n  namespace: default\n%s", name, name, extra)
+		if err := ioutil.WriteFile(filepath.Join(dir, name+"-values.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func syncWaves(repo string, helmService *helm.HelmFake) error {
+	return NewSync(repo, "prod", &manifest.DefaultLayout{}, helmService, &kubectl.KubectlFake{}, &secrets.DecrypterFake{}).Sync()
+}
+
+func TestSyncWaves(t *testing.T) {
+	repo := createWavesRepo(t, map[string]string{
+		"crds":     "  wave: -1\n",
+		"operator": "",
+		"postgres": "  dependsOn: [operator]\n",
+		"app":      "  wave: 1\n  dependsOn: [default/postgres]\n",
+		"web":      "  wave: 1\n  dependsOn: [app]\n",
+	})
+
+	helmService := &helm.HelmFake{}
+	if err := syncWaves(repo, helmService); err != nil {
+		t.Fatal(err)
+	}
+
+	if synced := strings.Join(helmService.Synced, ","); synced != "crds,operator,postgres,app,web" {
+		t.Fatalf("Unexpected sync order %s", synced)
+	}
+}
+
+func TestSyncWavesBlocked(t *testing.T) {
+	repo := createWavesRepo(t, map[string]string{
+		"operator": "",
+		"postgres": "  dependsOn: [operator]\n",
+		"redis":    "",
+		"app":      "  wave: 1\n",
+	})
+
+	helmService := &helm.HelmFake{FailOnSyncReleases: map[string]string{"operator": "operator failed"}}
+	err := syncWaves(repo, helmService)
+	if err == nil {
+		t.Fatal("Expected the sync to fail")
+	}
+
+	if synced := strings.Join(helmService.Synced, ","); synced != "operator,redis" && synced != "redis,operator" {
+		t.Fatalf("Expected only operator and redis to be synced, got %s", synced)
+	}
+	for _, expected := range []string{
+		"operator failed",
+		"Release default/postgres blocked: default/operator not synced",
+		"Release default/app blocked: default/operator, default/postgres not synced",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected '%s' in: %v", expected, err)
+		}
+	}
+}
+
+func TestSyncWavesErrors(t *testing.T) {
+	tests := map[string]map[string]string{
+		"Dependency cycle between releases: default/a -> default/b -> default/c -> default/a": {
+			"a": "  dependsOn: [b]\n",
+			"b": "  dependsOn: [c]\n",
+			"c": "  dependsOn: [a]\n",
+		},
+		"unknown dependency `nope`": {
+			"a": "  dependsOn: [nope]\n",
+		},
+		"depends on itself": {
+			"a": "  dependsOn: [a]\n",
+		},
+		"from a later wave": {
+			"a": "  dependsOn: [b]\n",
+			"b": "  wave: 2\n",
+		},
+	}
+
+	for expected, releases := range tests {
+		helmService := &helm.HelmFake{}
+		err := syncWaves(createWavesRepo(t, releases), helmService)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected '%s', got %v", expected, err)
+		}
+		if len(helmService.Synced) != 0 {
+			t.Fatalf("Expected nothing to be synced, got %v", helmService.Synced)
+		}
+	}
+}
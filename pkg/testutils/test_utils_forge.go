@@ -0,0 +1,186 @@
+package testutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A forge stand-in, serving the pull request APIs of GitHub (`/repos/...`),
+// Gitea (`/api/v1/repos/...`) and GitLab (`/api/v4/projects/...`) for a
+// single repository
+type TestForge struct {
+	Server *httptest.Server
+	URL    string
+
+	Repository string
+	Token      string
+
+	mu           sync.Mutex
+	pullRequests []*TestPullRequest
+}
+
+type TestPullRequest struct {
+	Number       int
+	Branch, Base string
+	Title, Body  string
+	// `open`, `merged` or `closed`
+	State string
+}
+
+func NewTestForge(t *testing.T) *TestForge {
+	f := &TestForge{
+		Repository: "foo/gitops",
+		Token:      "test-forge-token",
+	}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	f.URL = f.Server.URL
+	t.Cleanup(f.Server.Close)
+
+	return f
+}
+
+func (f *TestForge) PullRequests() []TestPullRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prs := []TestPullRequest{}
+	for _, pr := range f.pullRequests {
+		prs = append(prs, *pr)
+	}
+	return prs
+}
+
+// Only flags the pull request, the branch is left as is
+func (f *TestForge) Merge(number int) {
+	f.setState(number, "merged")
+}
+
+func (f *TestForge) Close(number int) {
+	f.setState(number, "closed")
+}
+
+func (f *TestForge) setState(number int, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pullRequests[number-1].State = state
+}
+
+func (f *TestForge) serve(w http.ResponseWriter, r *http.Request) {
+	var (
+		flavor, rest string
+		auth         string
+	)
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v4/projects/"):
+		flavor, rest, auth = "gitlab", strings.TrimPrefix(r.URL.Path, "/api/v4/projects/"), r.Header.Get("PRIVATE-TOKEN")
+	case strings.HasPrefix(r.URL.Path, "/api/v1/repos/"):
+		flavor, rest, auth = "gitea", strings.TrimPrefix(r.URL.Path, "/api/v1/repos/"), strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+	case strings.HasPrefix(r.URL.Path, "/repos/"):
+		flavor, rest, auth = "github", strings.TrimPrefix(r.URL.Path, "/repos/"), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if auth != f.Token {
+		http.Error(w, `{"message": "bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	collection := "/pulls"
+	if flavor == "gitlab" {
+		collection = "/merge_requests"
+	}
+
+	idx := strings.LastIndex(rest, collection)
+	if idx < 0 || rest[:idx] != f.Repository {
+		http.NotFound(w, r)
+		return
+	}
+	number := strings.TrimPrefix(rest[idx+len(collection):], "/")
+
+	switch {
+	case r.Method == "POST" && number == "":
+		f.open(flavor, w, r)
+	case r.Method == "GET" && number != "":
+		n, err := strconv.Atoi(number)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if err != nil || n < 1 || n > len(f.pullRequests) {
+			http.NotFound(w, r)
+			return
+		}
+		f.respond(flavor, w, f.pullRequests[n-1])
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *TestForge) open(flavor string, w http.ResponseWriter, r *http.Request) {
+	var params map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pr := &TestPullRequest{
+		Branch: params["head"],
+		Base:   params["base"],
+		Title:  params["title"],
+		Body:   params["body"],
+		State:  "open",
+	}
+	if flavor == "gitlab" {
+		pr.Branch, pr.Base, pr.Body = params["source_branch"], params["target_branch"], params["description"]
+	}
+	if pr.Branch == "" || pr.Base == "" || pr.Title == "" {
+		http.Error(w, `{"message": "missing branch, base or title"}`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pullRequests = append(f.pullRequests, pr)
+	pr.Number = len(f.pullRequests)
+
+	w.WriteHeader(http.StatusCreated)
+	f.respond(flavor, w, pr)
+}
+
+func (f *TestForge) respond(flavor string, w http.ResponseWriter, pr *TestPullRequest) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if flavor == "gitlab" {
+		state := pr.State
+		if state == "open" {
+			state = "opened"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"iid":     pr.Number,
+			"web_url": fmt.Sprintf("%s/%s/-/merge_requests/%d", f.URL, f.Repository, pr.Number),
+			"state":   state,
+		})
+		return
+	}
+
+	state := pr.State
+	if state == "merged" {
+		state = "closed"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"number":   pr.Number,
+		"html_url": fmt.Sprintf("%s/%s/pulls/%d", f.URL, f.Repository, pr.Number),
+		"state":    state,
+		"merged":   pr.State == "merged",
+	})
+}
@@ -0,0 +1,162 @@
+package testutils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A registry stand-in, serving the parts of the registry API mgo uses.
+// Images pushed to it only have a manifest and a config
+type TestRegistry struct {
+	Server *httptest.Server
+	// `127.0.0.1:<port>`, to prefix repositories with
+	Host string
+
+	// When set, a bearer token is required, handed out by `/token` to those
+	// having these credentials
+	Username, Password string
+	// Tags listed per page. 0 means all at once
+	PageSize int
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+	// Manifest digests by repository path and tag
+	tags map[string]map[string]string
+}
+
+const testRegistryToken = "test-registry-token"
+
+func NewTestRegistry(t *testing.T) *TestRegistry {
+	r := &TestRegistry{
+		blobs: make(map[string][]byte),
+		tags:  make(map[string]map[string]string),
+	}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	r.Host = strings.TrimPrefix(r.Server.URL, "http://")
+	t.Cleanup(r.Server.Close)
+
+	return r
+}
+
+// Push an image built at `created`. Returns its manifest digest
+func (r *TestRegistry) Push(path, tag string, created time.Time) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, _ := json.Marshal(map[string]interface{}{
+		"created":      created.UTC().Format(time.RFC3339Nano),
+		"architecture": "amd64",
+		"os":           "linux",
+	})
+	configDigest := r.addBlob(config)
+
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest":    configDigest,
+			"size":      len(config),
+		},
+		"layers": []interface{}{},
+	})
+	manifestDigest := r.addBlob(manifest)
+
+	if r.tags[path] == nil {
+		r.tags[path] = make(map[string]string)
+	}
+	r.tags[path][tag] = manifestDigest
+
+	return manifestDigest
+}
+
+func (r *TestRegistry) addBlob(content []byte) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	r.blobs[digest] = content
+	return digest
+}
+
+func (r *TestRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if user, pass, _ := req.BasicAuth(); user != r.Username || pass != r.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": testRegistryToken})
+		return
+	}
+
+	if r.Username != "" && req.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry",scope="repository:x:pull"`, r.Server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(path, "/tags/list"))
+
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		digest := parts[1]
+		if !strings.HasPrefix(digest, "sha256:") {
+			digest = r.tags[parts[0]][digest]
+		}
+
+		manifest, ok := r.blobs[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Write(manifest)
+
+	case strings.Contains(path, "/blobs/"):
+		blob, ok := r.blobs[strings.SplitN(path, "/blobs/", 2)[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(blob)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *TestRegistry) serveTags(w http.ResponseWriter, req *http.Request, path string) {
+	if r.tags[path] == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tags := []string{}
+	for tag := range r.tags[path] {
+		if tag > req.URL.Query().Get("last") {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	if r.PageSize > 0 && len(tags) > r.PageSize {
+		tags = tags[:r.PageSize]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%s&last=%s>; rel="next"`, path, strconv.Itoa(r.PageSize), tags[len(tags)-1]))
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": path, "tags": tags})
+}
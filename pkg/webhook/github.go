@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+const HEADER_GITHUB_EVENT = "X-GitHub-Event"
+
+// Reference here:
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads#package
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads#registry_package
+type GithubPackagePayload struct {
+	Action          string               `json:"action"`
+	Package         *GithubPackage       `json:"package"`
+	RegistryPackage *GithubPackage       `json:"registry_package"`
+	Repository      GithubRepository     `json:"repository"`
+	Sender          GithubPayload_Sender `json:"sender"`
+}
+type GithubPackage struct {
+	Name           string `json:"name"`
+	Namespace      string `json:"namespace"`
+	PackageType    string `json:"package_type"`
+	PackageVersion struct {
+		ContainerMetadata struct {
+			Tag struct {
+				Name   string `json:"name"`
+				Digest string `json:"digest"`
+			} `json:"tag"`
+		} `json:"container_metadata"`
+	} `json:"package_version"`
+	Registry struct {
+		URL string `json:"url"`
+	} `json:"registry"`
+}
+type GithubRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+type GithubPayload_Sender struct {
+	Login string `json:"login"`
+}
+
+// Container images published to GHCR, from `package` and `registry_package`
+// events. The trigger repo is the GitHub repo the package belongs to
+func ParseGithub(r *http.Request, body []byte) (*deploy.DeployOptions, error) {
+	event := r.Header.Get(HEADER_GITHUB_EVENT)
+	if event != "package" && event != "registry_package" {
+		return nil, &IgnoredError{fmt.Sprintf("github event `%s`", event)}
+	}
+
+	payload := GithubPackagePayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	pkg := payload.Package
+	if pkg == nil {
+		pkg = payload.RegistryPackage
+	}
+	if pkg == nil {
+		return nil, errors.New("is json payload from github malformed? No `package` found in request")
+	}
+
+	if payload.Action != "published" {
+		return nil, &IgnoredError{fmt.Sprintf("package action `%s`", payload.Action)}
+	}
+	if !strings.EqualFold(pkg.PackageType, "container") {
+		return nil, &IgnoredError{fmt.Sprintf("package type `%s`", pkg.PackageType)}
+	}
+
+	tag := pkg.PackageVersion.ContainerMetadata.Tag.Name
+	if tag == "" {
+		return nil, &IgnoredError{"untagged package version"}
+	}
+
+	if payload.Repository.HTMLURL == "" {
+		return nil, errors.New("is json payload from github malformed? No `repository` found in request")
+	}
+
+	registry := "ghcr.io"
+	if parsed, err := url.Parse(pkg.Registry.URL); err == nil && parsed.Host != "" {
+		registry = parsed.Host
+	}
+
+	return &deploy.DeployOptions{
+		TriggerRepo: triggerRepo(payload.Repository.HTMLURL),
+		Author:      payload.Sender.Login,
+		Image: deploy.DeployOptionsImage{
+			Repository: strings.ToLower(registry + "/" + pkg.Namespace + "/" + pkg.Name),
+			Tag:        tag,
+		},
+	}, nil
+}
+
+// The trigger repo of a repo's web url, eg: `github.com/foo/bar`
+func triggerRepo(webURL string) string {
+	parsed, err := url.Parse(webURL)
+	if err != nil || parsed.Host == "" {
+		return webURL
+	}
+	return parsed.Host + strings.TrimSuffix(parsed.Path, "/")
+}
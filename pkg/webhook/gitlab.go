@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+const HEADER_GITLAB_EVENT = "X-Gitlab-Event"
+
+// Length of `$CI_COMMIT_SHORT_SHA`
+const shortShaLength = 8
+
+// Reference here:
+// https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#pipeline-events
+type GitlabPipelinePayload struct {
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		Ref    string `json:"ref"`
+		Tag    bool   `json:"tag"`
+		Sha    string `json:"sha"`
+		Status string `json:"status"`
+	} `json:"object_attributes"`
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+}
+
+// Notifications of the container registry (the docker distribution format)
+// Reference here:
+// https://distribution.github.io/distribution/about/notifications/
+type RegistryNotificationPayload struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+		Actor struct {
+			Name string `json:"name"`
+		} `json:"actor"`
+	} `json:"events"`
+}
+
+// Successful pipelines, deploying the project's image from the GitLab
+// registry, or pushes notified by the GitLab registry
+func ParseGitlab(r *http.Request, body []byte) (*deploy.DeployOptions, error) {
+	if r.Header.Get(HEADER_GITLAB_EVENT) == "Pipeline Hook" {
+		return parseGitlabPipeline(body)
+	}
+	if r.Header.Get(HEADER_GITLAB_EVENT) != "" {
+		return nil, &IgnoredError{fmt.Sprintf("gitlab event `%s`", r.Header.Get(HEADER_GITLAB_EVENT))}
+	}
+
+	return parseGitlabRegistry(body)
+}
+
+// The image is `registry.<gitlab host>/<project path>`, tagged with the git
+// tag for tag pipelines, with the short commit sha otherwise (the
+// `$CI_COMMIT_TAG` / `$CI_COMMIT_SHORT_SHA` convention)
+func parseGitlabPipeline(body []byte) (*deploy.DeployOptions, error) {
+	payload := GitlabPipelinePayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.ObjectAttributes.Status != "success" {
+		return nil, &IgnoredError{fmt.Sprintf("pipeline status `%s`", payload.ObjectAttributes.Status)}
+	}
+
+	project, err := url.Parse(payload.Project.WebURL)
+	if err != nil || project.Host == "" || payload.Project.PathWithNamespace == "" {
+		return nil, errors.New("is json payload from gitlab malformed? No `project` found in request")
+	}
+
+	tag := payload.ObjectAttributes.Ref
+	if !payload.ObjectAttributes.Tag {
+		tag = payload.ObjectAttributes.Sha
+		if len(tag) > shortShaLength {
+			tag = tag[:shortShaLength]
+		}
+	}
+	if tag == "" {
+		return nil, errors.New("is json payload from gitlab malformed? No `ref` or `sha` found in request")
+	}
+
+	return &deploy.DeployOptions{
+		TriggerRepo: triggerRepo(payload.Project.WebURL),
+		Author:      payload.User.Username,
+		Image: deploy.DeployOptionsImage{
+			Repository: strings.ToLower("registry." + project.Host + "/" + payload.Project.PathWithNamespace),
+			Tag:        tag,
+		},
+	}, nil
+}
+
+// The last tagged push. The trigger repo is the project on the GitLab host
+// (the registry host without `registry.`)
+func parseGitlabRegistry(body []byte) (*deploy.DeployOptions, error) {
+	payload := RegistryNotificationPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	for i := len(payload.Events) - 1; i >= 0; i-- {
+		event := payload.Events[i]
+		if event.Action != "push" || event.Target.Tag == "" {
+			continue
+		}
+
+		registry := event.Request.Host
+		return &deploy.DeployOptions{
+			TriggerRepo: strings.TrimPrefix(registry, "registry.") + "/" + event.Target.Repository,
+			Author:      event.Actor.Name,
+			Image: deploy.DeployOptionsImage{
+				Repository: registry + "/" + event.Target.Repository,
+				Tag:        event.Target.Tag,
+			},
+		}, nil
+	}
+
+	return nil, &IgnoredError{"no tagged push in registry events"}
+}
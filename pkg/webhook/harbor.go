@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+// Reference here:
+// https://goharbor.io/docs/main/working-with-projects/project-configuration/configure-webhooks/
+type HarborPayload struct {
+	Type      string `json:"type"`
+	Operator  string `json:"operator"`
+	EventData struct {
+		Resources []struct {
+			Tag         string `json:"tag"`
+			Digest      string `json:"digest"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+// Artifact pushes. Harbor doesn't know the source repo, so the trigger repo
+// is the image repository, eg: `harbor.example.com/foo/bar`
+func ParseHarbor(r *http.Request, body []byte) (*deploy.DeployOptions, error) {
+	payload := HarborPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.Type != "PUSH_ARTIFACT" && payload.Type != "pushImage" {
+		return nil, &IgnoredError{fmt.Sprintf("harbor event `%s`", payload.Type)}
+	}
+
+	for _, resource := range payload.EventData.Resources {
+		if resource.Tag == "" {
+			continue
+		}
+
+		// resource_url is `<registry>/<repo>:<tag>` or `<registry>/<repo>@<digest>`
+		repository := strings.TrimSuffix(resource.ResourceURL, ":"+resource.Tag)
+		if repository == resource.ResourceURL {
+			return nil, errors.New(fmt.Sprintf("is json payload from harbor malformed? Unexpected `resource_url` %s", resource.ResourceURL))
+		}
+
+		return &deploy.DeployOptions{
+			TriggerRepo: repository,
+			Author:      payload.Operator,
+			Image: deploy.DeployOptionsImage{
+				Repository: repository,
+				Tag:        resource.Tag,
+				// What was pushed, even if the tag moves before the deploy
+				Digest: resource.Digest,
+			},
+		}, nil
+	}
+
+	return nil, &IgnoredError{"no tagged artifact in harbor event"}
+}
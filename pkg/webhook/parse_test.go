@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Payload  string
+		Headers  map[string]string
+		Expected deploy.DeployOptions
+	}{
+		{
+			SOURCE_GITHUB, "testdata/github-package.json",
+			map[string]string{HEADER_GITHUB_EVENT: "package"},
+			deploy.DeployOptions{TriggerRepo: "github.com/acme/web-app", Author: "octocat", Image: deploy.DeployOptionsImage{Repository: "ghcr.io/acme/web-app", Tag: "1.4.0"}},
+		},
+		{
+			SOURCE_GITHUB, "testdata/github-registry-package.json",
+			map[string]string{HEADER_GITHUB_EVENT: "registry_package"},
+			deploy.DeployOptions{TriggerRepo: "github.com/acme/web-app", Author: "octocat", Image: deploy.DeployOptionsImage{Repository: "ghcr.io/acme/web-app", Tag: "1.4.0"}},
+		},
+		{
+			SOURCE_GITLAB, "testdata/gitlab-pipeline.json",
+			map[string]string{HEADER_GITLAB_EVENT: "Pipeline Hook"},
+			deploy.DeployOptions{TriggerRepo: "gitlab.example.com/acme/web-app", Author: "root", Image: deploy.DeployOptionsImage{Repository: "registry.gitlab.example.com/acme/web-app", Tag: "bcbb5ec3"}},
+		},
+		{
+			SOURCE_GITLAB, "testdata/gitlab-registry.json",
+			map[string]string{},
+			deploy.DeployOptions{TriggerRepo: "gitlab.example.com/acme/web-app", Author: "root", Image: deploy.DeployOptionsImage{Repository: "registry.gitlab.example.com/acme/web-app", Tag: "2.0.1"}},
+		},
+		{
+			SOURCE_HARBOR, "testdata/harbor.json",
+			map[string]string{},
+			deploy.DeployOptions{TriggerRepo: "harbor.example.com/acme/web-app", Author: "admin", Image: deploy.DeployOptionsImage{Repository: "harbor.example.com/acme/web-app", Tag: "3.2.1", Digest: "sha256:954b378c375d852eb3c63ab88978f640b4348b01c1b3456a024a81536dafbbf4"}},
+		},
+		{
+			SOURCE_QUAY, "testdata/quay.json",
+			map[string]string{},
+			deploy.DeployOptions{TriggerRepo: "quay.io/acme/web-app", Author: "quay", Image: deploy.DeployOptionsImage{Repository: "quay.io/acme/web-app", Tag: "4.0.0"}},
+		},
+	}
+
+	for testIdx, test := range tests {
+		body, err := ioutil.ReadFile(test.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", "/", nil)
+		for header, value := range test.Headers {
+			req.Header.Set(header, value)
+		}
+
+		dopts, err := Parsers[test.Source](req, body)
+		if err != nil {
+			t.Fatalf("[test %d] %v", testIdx, err)
+		}
+		if *dopts != test.Expected {
+			t.Fatalf("[test %d] Expected %+v, got %+v", testIdx, test.Expected, *dopts)
+		}
+	}
+}
+
+func TestParsersIgnore(t *testing.T) {
+	tests := []struct {
+		Source  string
+		Payload string
+		Headers map[string]string
+	}{
+		{SOURCE_GITHUB, `{"zen": "Keep it logically awesome."}`, map[string]string{HEADER_GITHUB_EVENT: "ping"}},
+		{SOURCE_GITHUB, `{"action": "updated", "package": {"package_type": "container"}}`, map[string]string{HEADER_GITHUB_EVENT: "package"}},
+		{SOURCE_GITHUB, `{"action": "published", "package": {"package_type": "npm"}}`, map[string]string{HEADER_GITHUB_EVENT: "package"}},
+		{SOURCE_GITLAB, `{"object_kind": "pipeline", "object_attributes": {"status": "failed"}}`, map[string]string{HEADER_GITLAB_EVENT: "Pipeline Hook"}},
+		{SOURCE_GITLAB, `{"object_kind": "push"}`, map[string]string{HEADER_GITLAB_EVENT: "Push Hook"}},
+		{SOURCE_GITLAB, `{"events": [{"action": "pull", "target": {"tag": "1.0"}}]}`, map[string]string{}},
+		{SOURCE_HARBOR, `{"type": "DELETE_ARTIFACT"}`, map[string]string{}},
+		{SOURCE_QUAY, `{"docker_url": "quay.io/a/b", "updated_tags": []}`, map[string]string{}},
+	}
+
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		for header, value := range test.Headers {
+			req.Header.Set(header, value)
+		}
+
+		_, err := Parsers[test.Source](req, []byte(test.Payload))
+		if _, ok := err.(*IgnoredError); !ok {
+			t.Fatalf("[test %d] Expected the webhook to be ignored, got %v", testIdx, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+// Reference here:
+// https://docs.quay.io/guides/notifications.html#repository-push
+type QuayPayload struct {
+	Repository  string   `json:"repository"`
+	DockerURL   string   `json:"docker_url"`
+	UpdatedTags []string `json:"updated_tags"`
+}
+
+// Repository pushes. Quay doesn't know the source repo, so the trigger repo
+// is the image repository, eg: `quay.io/foo/bar`. Of the tags pushed, the
+// first one other than `latest` is deployed
+func ParseQuay(r *http.Request, body []byte) (*deploy.DeployOptions, error) {
+	payload := QuayPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.DockerURL == "" {
+		return nil, errors.New("is json payload from quay malformed? No `docker_url` found in request")
+	}
+	if len(payload.UpdatedTags) == 0 {
+		return nil, &IgnoredError{"no updated tags"}
+	}
+
+	tag := payload.UpdatedTags[0]
+	for _, updated := range payload.UpdatedTags {
+		if updated != "latest" {
+			tag = updated
+			break
+		}
+	}
+
+	return &deploy.DeployOptions{
+		TriggerRepo: payload.DockerURL,
+		Author:      "quay",
+		Image: deploy.DeployOptionsImage{
+			Repository: payload.DockerURL,
+			Tag:        tag,
+		},
+	}, nil
+}
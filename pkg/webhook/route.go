@@ -0,0 +1,280 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+// Routes are served under this path
+const ROUTES_PREFIX = "/hooks/"
+
+// A webhook declared in mygitops.yaml: the deploy is extracted from the JSON
+// payload with templates, eg: `{{ .repository.url }}`.
+//
+// Besides the payload, templates get `query "<param>"`, `header "<name>"`
+// and a few string helpers: `lower`, `upper`, `trimPrefix`, `trimSuffix`,
+// `replace`, `split`, `last` and `default`
+type RouteConfig struct {
+	// Where the route is served, under `/hooks/`
+	Path string
+
+	TriggerRepo string `yaml:"triggerRepo"`
+	// `repo:tag`, or only the repo when `tag` is set
+	Image string
+	// Optional
+	Tag string
+	// Optional, the route's path by default
+	Author string
+	// Optional, the `cluster` query parameter by default
+	Cluster string
+
+	// Optional. The webhook is only deployed when it renders `true`
+	Filter string
+
+	// Optional. Without it, the route takes API clients, like any other
+	// request. With it, the secret is expected in `tokenHeader`, or as the
+	// HMAC-SHA256 of the body in `signatureHeader` (hex, optionally prefixed
+	// by `sha256=`), or else as the `secret` query parameter. `${VAR}` is
+	// replaced by the environment variable
+	Secret          string
+	TokenHeader     string `yaml:"tokenHeader"`
+	SignatureHeader string `yaml:"signatureHeader"`
+
+	// What the route may deploy, as in the `auth` clients
+	Clusters     []string
+	TriggerRepos []string `yaml:"triggerRepos"`
+}
+
+type Route struct {
+	Path string
+
+	config    *RouteConfig
+	secret    string
+	client    *auth.Client
+	templates map[string]*template.Template
+}
+
+// Compile the routes, checking their paths and templates
+func NewRoutes(configs []RouteConfig) ([]*Route, error) {
+	routes := []*Route{}
+	paths := map[string]bool{}
+
+	for i := range configs {
+		route, err := NewRoute(&configs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if paths[route.Path] {
+			return nil, errors.New(fmt.Sprintf("routes: %s is defined twice", route.Path))
+		}
+		paths[route.Path] = true
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+func NewRoute(config *RouteConfig) (*Route, error) {
+	if !strings.HasPrefix(config.Path, ROUTES_PREFIX) || len(config.Path) == len(ROUTES_PREFIX) {
+		return nil, errors.New(fmt.Sprintf("routes: path `%s` should be under %s", config.Path, ROUTES_PREFIX))
+	}
+	if config.TriggerRepo == "" || config.Image == "" {
+		return nil, errors.New(fmt.Sprintf("routes: %s needs both `triggerRepo` and `image`", config.Path))
+	}
+	if (config.TokenHeader != "" || config.SignatureHeader != "") && config.Secret == "" {
+		return nil, errors.New(fmt.Sprintf("routes: %s has a token or signature header but no secret", config.Path))
+	}
+
+	route := &Route{
+		Path:      config.Path,
+		config:    config,
+		secret:    os.ExpandEnv(config.Secret),
+		client:    auth.NewClient("route:"+config.Path, config.Clusters, config.TriggerRepos),
+		templates: make(map[string]*template.Template),
+	}
+
+	expressions := map[string]string{
+		"triggerRepo": config.TriggerRepo,
+		"image":       config.Image,
+		"tag":         config.Tag,
+		"author":      config.Author,
+		"cluster":     config.Cluster,
+		"filter":      config.Filter,
+	}
+	for name, expression := range expressions {
+		if expression == "" {
+			continue
+		}
+
+		// Request dependent functions are bound when rendering. Missing keys
+		// render as `<no value>`, so optional fields and `default` work
+		tmpl, err := template.New(name).Option("missingkey=zero").Funcs(routeFuncs(nil)).Parse(expression)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("routes: %s: bad `%s`: %v", config.Path, name, err))
+		}
+		route.templates[name] = tmpl
+	}
+
+	return route, nil
+}
+
+// Whether the route checks a secret
+func (rt *Route) Secured() bool {
+	return rt.secret != ""
+}
+
+// Checks the request carries the route's secret. Returns the client the route
+// acts as. The body is left for the handler to read
+func (rt *Route) Verify(r *http.Request) (*auth.Client, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot read request body: %v", err))
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	switch {
+	case rt.config.SignatureHeader != "":
+		mac := hmac.New(sha256.New, []byte(rt.secret))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		signature := strings.TrimPrefix(r.Header.Get(rt.config.SignatureHeader), "sha256=")
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil, errors.New(fmt.Sprintf("%s: invalid signature", rt.Path))
+		}
+	case rt.config.TokenHeader != "":
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(rt.config.TokenHeader)), []byte(rt.secret)) != 1 {
+			return nil, errors.New(fmt.Sprintf("%s: invalid token", rt.Path))
+		}
+	default:
+		if err := verifySharedSecret(r, body, rt.secret); err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v", rt.Path, err))
+		}
+	}
+
+	return rt.client, nil
+}
+
+// The deploy described by the payload. Payloads not passing the filter are
+// ignored
+func (rt *Route) Parse(r *http.Request, body []byte) (*deploy.DeployOptions, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, errors.New(fmt.Sprintf("payload is not JSON: %v", err))
+	}
+
+	render := func(name string) (string, error) {
+		tmpl, ok := rt.templates[name]
+		if !ok {
+			return "", nil
+		}
+
+		var out bytes.Buffer
+		if err := template.Must(tmpl.Clone()).Funcs(routeFuncs(r)).Execute(&out, payload); err != nil {
+			return "", errors.New(fmt.Sprintf("%s: cannot render `%s`: %v", rt.Path, name, err))
+		}
+		return strings.TrimSpace(out.String()), nil
+	}
+
+	if _, ok := rt.templates["filter"]; ok {
+		match, err := render("filter")
+		if err != nil {
+			return nil, err
+		}
+		if match != "true" {
+			return nil, &IgnoredError{"filtered out"}
+		}
+	}
+
+	values := map[string]string{}
+	for _, name := range []string{"triggerRepo", "image", "tag", "author", "cluster"} {
+		value, err := render(name)
+		if err != nil {
+			return nil, err
+		}
+		// Something was missing from the payload
+		if strings.Contains(value, "<no value>") {
+			value = ""
+		}
+		values[name] = value
+	}
+
+	image := deploy.ParseImage(values["image"])
+	if values["tag"] != "" {
+		image = deploy.DeployOptionsImage{Repository: values["image"], Tag: values["tag"]}
+	}
+	if values["author"] == "" {
+		values["author"] = rt.Path
+	}
+
+	if values["triggerRepo"] == "" || image.Repository == "" || image.Tag == "" {
+		return nil, errors.New(fmt.Sprintf("%s: no trigger repo, image or tag in payload", rt.Path))
+	}
+
+	return &deploy.DeployOptions{
+		TriggerRepo: values["triggerRepo"],
+		Author:      values["author"],
+		Cluster:     values["cluster"],
+		Image:       image,
+	}, nil
+}
+
+func routeFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"query": func(name string) string {
+			if r == nil {
+				return ""
+			}
+			return r.URL.Query().Get(name)
+		},
+		"header": func(name string) string {
+			if r == nil {
+				return ""
+			}
+			return r.Header.Get(name)
+		},
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"last": func(list interface{}) interface{} {
+			switch l := list.(type) {
+			case []interface{}:
+				if len(l) > 0 {
+					return l[len(l)-1]
+				}
+			case []string:
+				if len(l) > 0 {
+					return l[len(l)-1]
+				}
+			}
+			return nil
+		},
+		"default": func(def string, value interface{}) string {
+			if value == nil || fmt.Sprint(value) == "" {
+				return def
+			}
+			return fmt.Sprint(value)
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+// Eg: a drone build
+const testRoutePayload = `{
+  "event": "build",
+  "action": "updated",
+  "repo": {"slug": "Acme/Web-App", "link": "https://git.example.com/acme/web-app"},
+  "build": {"status": "success", "number": 1234, "after": "bcbb5ec396a2c0f8", "target": "main", "sender": "octocat"}
+}`
+
+func newTestRoute(t *testing.T, config RouteConfig) *Route {
+	if config.Path == "" {
+		config.Path = "/hooks/drone"
+	}
+	if config.TriggerRepo == "" {
+		config.TriggerRepo = `{{ .repo.link | trimPrefix "https://" }}`
+	}
+	if config.Image == "" {
+		config.Image = `registry.example.com/{{ lower .repo.slug }}`
+	}
+
+	route, err := NewRoute(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return route
+}
+
+func TestRouteParse(t *testing.T) {
+	route := newTestRoute(t, RouteConfig{
+		Tag:     `{{ .build.number }}-{{ .build.after }}`,
+		Author:  `{{ .build.sender }}`,
+		Cluster: `{{ if eq .build.target "main" }}prod{{ else }}{{ query "cluster" }}{{ end }}`,
+		Filter:  `{{ and (eq .event "build") (eq .build.status "success") }}`,
+	})
+
+	req := httptest.NewRequest("POST", "/hooks/drone", nil)
+	dopts, err := route.Parse(req, []byte(testRoutePayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := deploy.DeployOptions{
+		TriggerRepo: "git.example.com/acme/web-app",
+		Author:      "octocat",
+		Cluster:     "prod",
+		Image:       deploy.DeployOptionsImage{Repository: "registry.example.com/acme/web-app", Tag: "1234-bcbb5ec396a2c0f8"},
+	}
+	if *dopts != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, *dopts)
+	}
+
+	// Filtered out
+	failed := strings.Replace(testRoutePayload, `"status": "success"`, `"status": "failure"`, 1)
+	if _, err := route.Parse(req, []byte(failed)); err == nil {
+		t.Fatal("Expected the failed build to be filtered out")
+	} else if _, ok := err.(*IgnoredError); !ok {
+		t.Fatalf("Expected an IgnoredError, got %v", err)
+	}
+}
+
+func TestRouteParseDefaults(t *testing.T) {
+	route := newTestRoute(t, RouteConfig{
+		Image: `registry.example.com/{{ lower .repo.slug }}:{{ .build.after }}`,
+	})
+
+	dopts, err := route.Parse(httptest.NewRequest("POST", "/hooks/drone?cluster=staging", nil), []byte(testRoutePayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dopts.Image.Tag != "bcbb5ec396a2c0f8" || dopts.Author != "/hooks/drone" || dopts.Cluster != "" {
+		t.Fatalf("Unexpected deploy %+v", *dopts)
+	}
+}
+
+func TestRouteParseMissingKeys(t *testing.T) {
+	route := newTestRoute(t, RouteConfig{
+		Tag:     `{{ .build.number }}`,
+		Author:  `{{ .build.committer }}`,
+		Cluster: `{{ .deploy_to | default "staging" }}`,
+		Filter:  `{{ or (eq .event "build") .force }}`,
+	})
+
+	dopts, err := route.Parse(httptest.NewRequest("POST", "/hooks/drone", nil), []byte(testRoutePayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dopts.Image.Tag != "1234" || dopts.Author != "/hooks/drone" || dopts.Cluster != "staging" {
+		t.Fatalf("Unexpected deploy %+v", *dopts)
+	}
+
+	withCluster := strings.Replace(testRoutePayload, `"event": "build",`, `"event": "build", "deploy_to": "prod",`, 1)
+	if dopts, err := route.Parse(httptest.NewRequest("POST", "/hooks/drone", nil), []byte(withCluster)); err != nil || dopts.Cluster != "prod" {
+		t.Fatalf("Expected the payload's cluster, got %+v (%v)", dopts, err)
+	}
+}
+
+func TestRouteParseErrors(t *testing.T) {
+	route := newTestRoute(t, RouteConfig{Tag: `{{ .build.nope }}-{{ .build.after }}`})
+
+	req := httptest.NewRequest("POST", "/hooks/drone", nil)
+	for _, payload := range []string{testRoutePayload, `not json`, `{"repo": {}}`} {
+		if _, err := route.Parse(req, []byte(payload)); err == nil {
+			t.Fatalf("Expected an error parsing %s", payload)
+		} else if _, ok := err.(*IgnoredError); ok {
+			t.Fatalf("Expected an error, not an ignored webhook: %v", err)
+		}
+	}
+}
+
+func TestRouteVerify(t *testing.T) {
+	body := []byte(testRoutePayload)
+
+	tests := []struct {
+		Config RouteConfig
+		URL    string
+		Header map[string]string
+		Valid  bool
+	}{
+		{RouteConfig{Secret: "s3cret"}, "/hooks/drone?secret=s3cret", nil, true},
+		{RouteConfig{Secret: "s3cret"}, "/hooks/drone?secret=nope", nil, false},
+		{RouteConfig{Secret: "s3cret", TokenHeader: "X-Token"}, "/hooks/drone", map[string]string{"X-Token": "s3cret"}, true},
+		{RouteConfig{Secret: "s3cret", TokenHeader: "X-Token"}, "/hooks/drone?secret=s3cret", nil, false},
+		{RouteConfig{Secret: "s3cret", SignatureHeader: "X-Signature"}, "/hooks/drone", map[string]string{"X-Signature": GithubSignature("s3cret", body)}, true},
+		{RouteConfig{Secret: "s3cret", SignatureHeader: "X-Signature"}, "/hooks/drone", map[string]string{"X-Signature": strings.TrimPrefix(GithubSignature("s3cret", body), "sha256=")}, true},
+		{RouteConfig{Secret: "s3cret", SignatureHeader: "X-Signature"}, "/hooks/drone", map[string]string{"X-Signature": GithubSignature("nope", body)}, false},
+	}
+
+	for testIdx, test := range tests {
+		route := newTestRoute(t, test.Config)
+
+		req := httptest.NewRequest("POST", test.URL, strings.NewReader(string(body)))
+		for header, value := range test.Header {
+			req.Header.Set(header, value)
+		}
+
+		_, err := route.Verify(req)
+		if test.Valid != (err == nil) {
+			t.Fatalf("[test %d] Expected valid=%v, got %v", testIdx, test.Valid, err)
+		}
+	}
+}
+
+func TestNewRoutes(t *testing.T) {
+	valid := RouteConfig{Path: "/hooks/a", TriggerRepo: "{{ .repo }}", Image: "{{ .image }}"}
+
+	bad := [][]RouteConfig{
+		{{Path: "/deploy", TriggerRepo: "x", Image: "x"}},
+		{{Path: "/hooks/", TriggerRepo: "x", Image: "x"}},
+		{{Path: "/hooks/a", Image: "x"}},
+		{{Path: "/hooks/a", TriggerRepo: "{{ .repo", Image: "x"}},
+		{{Path: "/hooks/a", TriggerRepo: "x", Image: "x", TokenHeader: "X-Token"}},
+		{valid, valid},
+	}
+
+	for testIdx, configs := range bad {
+		if _, err := NewRoutes(configs); err == nil {
+			t.Fatalf("[test %d] Expected an error", testIdx)
+		}
+	}
+
+	if routes, err := NewRoutes([]RouteConfig{valid}); err != nil || len(routes) != 1 {
+		t.Fatal("Expected a valid route:", err)
+	}
+}
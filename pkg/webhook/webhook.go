@@ -0,0 +1,201 @@
+/*
+ * Webhook: checks that webhook requests really come from the service they
+ * claim to, each service in its own way:
+ *
+ *   - github: `X-Hub-Signature-256`, the HMAC-SHA256 of the body
+ *   - gitlab: the `X-Gitlab-Token` header
+ *   - harbor: the `Authorization` header
+ *   - dockerhub, quay: they can't sign requests, so the shared secret is part
+ *     of the webhook URL, as the `secret` query parameter
+ *
+ * and turns their payloads into deploys.
+ */
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/valer-cara/mgo/pkg/auth"
+	"github.com/valer-cara/mgo/pkg/deploy"
+)
+
+const (
+	SOURCE_GITHUB    = "github"
+	SOURCE_GITLAB    = "gitlab"
+	SOURCE_DOCKERHUB = "dockerhub"
+	SOURCE_HARBOR    = "harbor"
+	SOURCE_QUAY      = "quay"
+)
+
+const (
+	HEADER_GITHUB_SIGNATURE = "X-Hub-Signature-256"
+	HEADER_GITLAB_TOKEN     = "X-Gitlab-Token"
+
+	PARAM_SECRET = "secret"
+)
+
+// Largest webhook body read
+const maxBody = 10 << 20
+
+// Per source, eg: `github`
+type Config map[string]SourceConfig
+
+type SourceConfig struct {
+	// `${VAR}` is replaced by the environment variable
+	Secret string
+
+	// What the webhook may deploy, as in the `auth` clients
+	Clusters     []string
+	TriggerRepos []string `yaml:"triggerRepos"`
+}
+
+func (c Config) Validate() error {
+	for source, sourceConfig := range c {
+		if _, ok := verifiers[source]; !ok {
+			return errors.New(fmt.Sprintf("webhooks: unknown source `%s`", source))
+		}
+		if os.ExpandEnv(sourceConfig.Secret) == "" {
+			return errors.New(fmt.Sprintf("webhooks: %s has no secret", source))
+		}
+	}
+	return nil
+}
+
+// Checks the request against the secret
+type verifyFunc func(r *http.Request, body []byte, secret string) error
+
+var verifiers = map[string]verifyFunc{
+	SOURCE_GITHUB:    verifyGithub,
+	SOURCE_GITLAB:    verifyGitlab,
+	SOURCE_DOCKERHUB: verifySharedSecret,
+	SOURCE_HARBOR:    verifyAuthorization,
+	SOURCE_QUAY:      verifySharedSecret,
+}
+
+// Turns a webhook payload into a deploy, without the cluster
+type ParseFunc func(r *http.Request, body []byte) (*deploy.DeployOptions, error)
+
+// Payload parsers, by source
+var Parsers = map[string]ParseFunc{
+	SOURCE_GITHUB: ParseGithub,
+	SOURCE_GITLAB: ParseGitlab,
+	SOURCE_HARBOR: ParseHarbor,
+	SOURCE_QUAY:   ParseQuay,
+}
+
+// Valid webhooks that don't call for a deploy, eg: a failed pipeline
+type IgnoredError struct {
+	Reason string
+}
+
+func (e *IgnoredError) Error() string {
+	return "ignored: " + e.Reason
+}
+
+type Verifier struct {
+	sources map[string]*source
+}
+
+type source struct {
+	secret string
+	client *auth.Client
+}
+
+func NewVerifier(config Config) (*Verifier, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	v := &Verifier{sources: make(map[string]*source)}
+	for name, sourceConfig := range config {
+		v.sources[name] = &source{
+			secret: os.ExpandEnv(sourceConfig.Secret),
+			client: auth.NewClient("webhook:"+name, sourceConfig.Clusters, sourceConfig.TriggerRepos),
+		}
+	}
+
+	return v, nil
+}
+
+// Whether webhooks from `source` are checked
+func (v *Verifier) Configured(source string) bool {
+	if v == nil {
+		return false
+	}
+	_, ok := v.sources[source]
+	return ok
+}
+
+// Checks the request comes from `source`. Returns the client the webhook acts
+// as. The body is left for the handler to read
+func (v *Verifier) Verify(source string, r *http.Request) (*auth.Client, error) {
+	src, ok := v.sources[source]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("no secret configured for %s webhooks", source))
+	}
+
+	body := []byte{}
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot read request body: %v", err))
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if err := verifiers[source](r, body, src.secret); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s webhook: %v", source, err))
+	}
+
+	return src.client, nil
+}
+
+func verifyGithub(r *http.Request, body []byte, secret string) error {
+	signature := r.Header.Get(HEADER_GITHUB_SIGNATURE)
+	if signature == "" {
+		return errors.New(fmt.Sprintf("missing %s header", HEADER_GITHUB_SIGNATURE))
+	}
+
+	if !hmac.Equal([]byte(GithubSignature(secret, body)), []byte(signature)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func verifyGitlab(r *http.Request, body []byte, secret string) error {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(HEADER_GITLAB_TOKEN)), []byte(secret)) != 1 {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+func verifyAuthorization(r *http.Request, body []byte, secret string) error {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(secret)) != 1 {
+		return errors.New("invalid authorization")
+	}
+	return nil
+}
+
+func verifySharedSecret(r *http.Request, body []byte, secret string) error {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get(PARAM_SECRET)), []byte(secret)) != 1 {
+		return errors.New("invalid secret")
+	}
+	return nil
+}
+
+// The `X-Hub-Signature-256` header value GitHub sends for `body`
+func GithubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
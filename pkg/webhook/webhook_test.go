@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestVerifier(t *testing.T) *Verifier {
+	v, err := NewVerifier(Config{
+		SOURCE_GITHUB:    {Secret: "gh-secret", Clusters: []string{"*"}, TriggerRepos: []string{"*"}},
+		SOURCE_GITLAB:    {Secret: "gl-token"},
+		SOURCE_DOCKERHUB: {Secret: "dh-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	v := newTestVerifier(t)
+	body := `{"push_data": {"tag": "1.0.0"}}`
+
+	tests := []struct {
+		Source string
+		URL    string
+		Prep   func(*http.Request)
+		Valid  bool
+	}{
+		{SOURCE_GITHUB, "/", func(r *http.Request) {
+			r.Header.Set(HEADER_GITHUB_SIGNATURE, GithubSignature("gh-secret", []byte(body)))
+		}, true},
+		{SOURCE_GITHUB, "/", func(r *http.Request) { r.Header.Set(HEADER_GITHUB_SIGNATURE, GithubSignature("nope", []byte(body))) }, false},
+		{SOURCE_GITHUB, "/", func(r *http.Request) {}, false},
+		{SOURCE_GITLAB, "/", func(r *http.Request) { r.Header.Set(HEADER_GITLAB_TOKEN, "gl-token") }, true},
+		{SOURCE_GITLAB, "/", func(r *http.Request) { r.Header.Set(HEADER_GITLAB_TOKEN, "gl-tok") }, false},
+		{SOURCE_DOCKERHUB, "/deploy/dockerhub?cluster=prod&secret=dh-secret", func(r *http.Request) {}, true},
+		{SOURCE_DOCKERHUB, "/deploy/dockerhub?cluster=prod&secret=nope", func(r *http.Request) {}, false},
+		{SOURCE_DOCKERHUB, "/deploy/dockerhub?cluster=prod", func(r *http.Request) {}, false},
+		{SOURCE_QUAY, "/?secret=dh-secret", func(r *http.Request) {}, false},
+	}
+
+	for testIdx, test := range tests {
+		req := httptest.NewRequest("POST", test.URL, strings.NewReader(body))
+		test.Prep(req)
+
+		client, err := v.Verify(test.Source, req)
+		if !test.Valid {
+			if err == nil {
+				t.Fatalf("[test %d] Expected an error", testIdx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[test %d] %v", testIdx, err)
+		}
+
+		if client.Name != "webhook:"+test.Source {
+			t.Fatalf("[test %d] Unexpected client %s", testIdx, client.Name)
+		}
+		if b, _ := ioutil.ReadAll(req.Body); string(b) != body {
+			t.Fatalf("[test %d] Expected the body to be readable again, got '%s'", testIdx, string(b))
+		}
+	}
+}
+
+func TestVerifierClients(t *testing.T) {
+	v := newTestVerifier(t)
+
+	if !v.sources[SOURCE_GITHUB].client.Allowed("prod", "github.com/a/repo1") {
+		t.Fatal("Expected the github webhook to be allowed anything")
+	}
+	if v.sources[SOURCE_GITLAB].client.Allowed("prod", "github.com/a/repo1") {
+		t.Fatal("Expected the gitlab webhook to be allowed nothing")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (Config{"bitbucket": {Secret: "x"}}).Validate(); err == nil {
+		t.Fatal("Expected an error for an unknown source")
+	}
+	if err := (Config{SOURCE_GITHUB: {}}).Validate(); err == nil {
+		t.Fatal("Expected an error for a source without secret")
+	}
+
+	var v *Verifier
+	if v.Configured(SOURCE_GITHUB) {
+		t.Fatal("Expected nothing configured without a verifier")
+	}
+}
@@ -0,0 +1,334 @@
+// Package yamledit does surgical edits on yaml files.
+//
+// Files are parsed into a yaml.v3 node tree, which is only used to locate
+// things. Edits are then applied to the original bytes at the positions
+// recorded in the nodes, so comments, anchors, aliases, key order, indentation
+// and line endings are kept as they are everywhere else in the file.
+package yamledit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+type Document struct {
+	content    []byte
+	root       *yaml.Node
+	lineStarts []int
+	newline    string
+	edits      []edit
+}
+
+type edit struct {
+	offset, length int
+	replacement    string
+	seq            int
+}
+
+func Parse(content []byte) (*Document, error) {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	d := &Document{
+		content:    content,
+		lineStarts: []int{0},
+		newline:    "\n",
+	}
+
+	if len(doc.Content) > 0 {
+		d.root = doc.Content[0]
+	}
+
+	for idx, c := range content {
+		if c == '\n' {
+			d.lineStarts = append(d.lineStarts, idx+1)
+		}
+	}
+
+	if bytes.Contains(content, []byte("\r\n")) {
+		d.newline = "\r\n"
+	}
+
+	return d, nil
+}
+
+// The top level node of the document, nil for an empty document
+func (d *Document) Root() *yaml.Node {
+	return d.root
+}
+
+// Look up a value by following mapping keys from `node`. Aliases are resolved
+// along the way. Returns nil if any of the keys is missing
+func Lookup(node *yaml.Node, keys ...string) *yaml.Node {
+	current := resolve(node)
+
+	for _, key := range keys {
+		if current == nil || current.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		var found *yaml.Node
+		for idx := 0; idx+1 < len(current.Content); idx += 2 {
+			if current.Content[idx].Value == key {
+				found = resolve(current.Content[idx+1])
+			}
+		}
+		current = found
+	}
+
+	return current
+}
+
+// Follow aliases (`*foo`) to the anchored node
+func resolve(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// Replace the value of a scalar node, keeping its quoting style, anchor and
+// anything following it on the line (eg: comments)
+func (d *Document) SetScalar(node *yaml.Node, value string) error {
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return errors.New("not a scalar")
+	}
+
+	start, end, err := d.scalarExtent(node)
+	if err != nil {
+		return err
+	}
+
+	replacement, err := formatScalar(value, node.Style)
+	if err != nil {
+		return err
+	}
+
+	d.addEdit(start, end-start, replacement)
+
+	return nil
+}
+
+// Add a `key: value` pair to a block mapping. The new line goes right below
+// the first key of the mapping having a single line scalar value, using the
+// same indentation
+func (d *Document) InsertKey(mapping *yaml.Node, key, value string) error {
+	mapping = resolve(mapping)
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return errors.New("not a mapping")
+	}
+	if mapping.Style&yaml.FlowStyle != 0 {
+		return errors.New(fmt.Sprintf("line %d: cannot insert `%s` into a flow mapping", mapping.Line, key))
+	}
+
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		keyNode, valueNode := mapping.Content[idx], resolve(mapping.Content[idx+1])
+		if valueNode.Kind != yaml.ScalarNode || mapping.Content[idx+1].Kind == yaml.AliasNode {
+			continue
+		}
+
+		_, end, err := d.scalarExtent(valueNode)
+		if err != nil || d.lineOf(end) != keyNode.Line-1 {
+			continue
+		}
+
+		formattedValue, err := formatScalar(value, 0)
+		if err != nil {
+			return err
+		}
+
+		lineEnd := d.lineEnd(keyNode.Line - 1)
+		text := d.newline + strings.Repeat(" ", keyNode.Column-1) + key + ": " + formattedValue
+
+		d.addEdit(lineEnd, 0, text)
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("line %d: cannot find where to insert `%s`", mapping.Line, key))
+}
+
+// The edited content
+func (d *Document) Bytes() ([]byte, error) {
+	edits := make([]edit, len(d.edits))
+	copy(edits, d.edits)
+
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].offset != edits[j].offset {
+			return edits[i].offset > edits[j].offset
+		}
+		return edits[i].seq > edits[j].seq
+	})
+
+	out := make([]byte, len(d.content))
+	copy(out, d.content)
+
+	limit := len(out)
+	for _, e := range edits {
+		if e.offset+e.length > limit {
+			return nil, errors.New("overlapping yaml edits")
+		}
+		limit = e.offset
+
+		patched := append([]byte{}, out[:e.offset]...)
+		patched = append(patched, e.replacement...)
+		out = append(patched, out[e.offset+e.length:]...)
+	}
+
+	return out, nil
+}
+
+func (d *Document) addEdit(offset, length int, replacement string) {
+	// Editing the same scalar twice (eg: through different aliases) keeps
+	// only the last edit
+	for idx := range d.edits {
+		if d.edits[idx].offset == offset && d.edits[idx].length == length && length > 0 {
+			d.edits[idx].replacement = replacement
+			return
+		}
+	}
+
+	d.edits = append(d.edits, edit{
+		offset:      offset,
+		length:      length,
+		replacement: replacement,
+		seq:         len(d.edits),
+	})
+}
+
+// Byte offsets of the scalar's text, quotes included, anchor/tag excluded
+func (d *Document) scalarExtent(node *yaml.Node) (int, int, error) {
+	if node.Line < 1 || node.Line > len(d.lineStarts) {
+		return 0, 0, errors.New(fmt.Sprintf("line %d: out of range", node.Line))
+	}
+
+	// Columns are counted in characters, not bytes
+	start := d.lineStarts[node.Line-1]
+	for col := 1; col < node.Column && start < len(d.content); col++ {
+		_, size := utf8.DecodeRune(d.content[start:])
+		start += size
+	}
+
+	// Skip `&anchor` and `!tag` properties, they're part of the node's position
+	for start < len(d.content) && (d.content[start] == '&' || d.content[start] == '!') {
+		for start < len(d.content) && !isSpace(d.content[start]) {
+			start++
+		}
+		for start < len(d.content) && (d.content[start] == ' ' || d.content[start] == '\t') {
+			start++
+		}
+	}
+
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for end := start + 1; end < len(d.content); end++ {
+			if d.content[end] == '\\' {
+				end++
+			} else if d.content[end] == '"' {
+				return start, end + 1, nil
+			}
+		}
+
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for end := start + 1; end < len(d.content); end++ {
+			if d.content[end] == '\'' {
+				if end+1 < len(d.content) && d.content[end+1] == '\'' {
+					end++
+					continue
+				}
+				return start, end + 1, nil
+			}
+		}
+
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return 0, 0, errors.New(fmt.Sprintf("line %d: block scalars are not supported", node.Line))
+
+	default:
+		// Single line plain scalars are written exactly as their value
+		end := start + len(node.Value)
+		if end <= d.lineEnd(d.lineOf(start)) && string(d.content[start:end]) == node.Value {
+			return start, end, nil
+		}
+		return 0, 0, errors.New(fmt.Sprintf("line %d: multi line plain scalars are not supported", node.Line))
+	}
+
+	return 0, 0, errors.New(fmt.Sprintf("line %d: unterminated quoted scalar", node.Line))
+}
+
+// Zero based line number of a byte offset
+func (d *Document) lineOf(offset int) int {
+	return sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+}
+
+// Byte offset where a zero based line ends, before the line break
+func (d *Document) lineEnd(line int) int {
+	end := len(d.content)
+	if line+1 < len(d.lineStarts) {
+		end = d.lineStarts[line+1] - 1
+	}
+	if end > 0 && end <= len(d.content) && end-1 >= d.lineStarts[line] && d.content[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// Format a string value as a yaml scalar in the requested style. Plain
+// scalars fall back to double quotes when the value would otherwise be read
+// back as something else than the same string (eg: `1.10` or `true`)
+func formatScalar(value string, style yaml.Style) (string, error) {
+	switch {
+	case style&yaml.DoubleQuotedStyle != 0:
+		return doubleQuote(value), nil
+
+	case style&yaml.SingleQuotedStyle != 0:
+		return "'" + strings.Replace(value, "'", "''", -1) + "'", nil
+
+	default:
+		if value != "" && readsBackAs(value, "x: %s") && readsBackAs(value, "{x: %s}") {
+			return value, nil
+		}
+		return doubleQuote(value), nil
+	}
+}
+
+// Whether a plain scalar is read back as the same string, both in block and
+// flow context
+func readsBackAs(value, format string) bool {
+	var parsed map[string]interface{}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return false
+	}
+	if err := yaml.Unmarshal([]byte(fmt.Sprintf(format, value)), &parsed); err != nil || len(parsed) != 1 {
+		return false
+	}
+
+	s, ok := parsed["x"].(string)
+	return ok && s == value
+}
+
+func doubleQuote(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,90 @@
+package yamledit
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestSetScalarKeepsStyle(t *testing.T) {
+	tests := []struct {
+		input    string
+		path     []string
+		value    string
+		expected string
+	}{
+		{"a: foo # comment\n", []string{"a"}, "bar", "a: bar # comment\n"},
+		{"a: \"foo\"\n", []string{"a"}, "b\"ar", "a: \"b\\\"ar\"\n"},
+		{"a: 'foo'\n", []string{"a"}, "it's", "a: 'it''s'\n"},
+		{"a: &anchor foo\nb: *anchor\n", []string{"b"}, "bar", "a: &anchor bar\nb: *anchor\n"},
+		{"a: foo\n", []string{"a"}, "1.10", "a: \"1.10\"\n"},
+		{"a: foo\n", []string{"a"}, "true", "a: \"true\"\n"},
+		{"a: {b: foo, c: d}\n", []string{"a", "b"}, "x,y", "a: {b: \"x,y\", c: d}\n"},
+		{"a: foo\r\nb: bar\r\n", []string{"a"}, "baz", "a: baz\r\nb: bar\r\n"},
+		{"a: ünïcode\nb: foo\n", []string{"b"}, "bar", "a: ünïcode\nb: bar\n"},
+	}
+
+	for _, test := range tests {
+		doc, err := Parse([]byte(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := doc.SetScalar(Lookup(doc.Root(), test.path...), test.value); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := doc.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != test.expected {
+			t.Errorf("Input %q: expected %q, got %q", test.input, test.expected, string(out))
+		}
+	}
+}
+
+func TestInsertKey(t *testing.T) {
+	tests := []struct {
+		input, path, expected string
+	}{
+		{"m:\n  a: b\n  c:\n    d: e\n", "m", "m:\n  a: b\n  x: \"1.0\"\n  c:\n    d: e\n"},
+		{"l:\n- name: foo # comment\n  other: bar\n", "l", "l:\n- name: foo # comment\n  x: \"1.0\"\n  other: bar\n"},
+		{"m:\r\n    a: b", "m", "m:\r\n    a: b\r\n    x: \"1.0\""},
+	}
+
+	for _, test := range tests {
+		doc, err := Parse([]byte(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mapping := Lookup(doc.Root(), test.path)
+		if mapping.Kind == yaml.SequenceNode {
+			mapping = mapping.Content[0]
+		}
+
+		if err := doc.InsertKey(mapping, "x", "1.0"); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := doc.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != test.expected {
+			t.Errorf("Input %q: expected %q, got %q", test.input, test.expected, string(out))
+		}
+	}
+}
+
+func TestInsertKeyFlowMapping(t *testing.T) {
+	doc, err := Parse([]byte("m: {a: b}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := doc.InsertKey(Lookup(doc.Root(), "m"), "x", "y"); err == nil {
+		t.Fatal("Expected inserting into a flow mapping to fail")
+	}
+}